@@ -1,7 +1,10 @@
 package micro
 
 import (
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	lua "github.com/yuin/gopher-lua"
@@ -11,7 +14,9 @@ import (
 	"github.com/schollz/aw/internal/buffer"
 	"github.com/schollz/aw/internal/config"
 	"github.com/schollz/aw/internal/display"
+	"github.com/schollz/aw/internal/globals"
 	ulua "github.com/schollz/aw/internal/lua"
+	"github.com/schollz/aw/internal/parser"
 	"github.com/schollz/aw/internal/screen"
 	"github.com/schollz/aw/internal/shell"
 	"github.com/schollz/aw/internal/util"
@@ -20,6 +25,7 @@ import (
 func init() {
 	ulua.L = lua.NewState()
 	ulua.L.SetGlobal("import", luar.New(ulua.L, LuaImport))
+	parser.RunGenerator = luaGenerator
 }
 
 // LuaImport is meant to be called from lua by a plugin and will import the given micro package
@@ -35,6 +41,8 @@ func LuaImport(pkg string) *lua.LTable {
 		return luaImportMicroConfig()
 	case "micro/util":
 		return luaImportMicroUtil()
+	case "aw":
+		return luaImportAw()
 	default:
 		return ulua.Import(pkg)
 	}
@@ -163,3 +171,165 @@ func luaImportMicroUtil() *lua.LTable {
 
 	return pkg
 }
+
+func luaImportAw() *lua.LTable {
+	pkg := ulua.L.NewTable()
+
+	ulua.L.SetField(pkg, "Play", luar.New(ulua.L, func() {
+		sequencer().Play()
+	}))
+	ulua.L.SetField(pkg, "Stop", luar.New(ulua.L, func() {
+		sequencer().Stop()
+	}))
+	ulua.L.SetField(pkg, "Toggle", luar.New(ulua.L, func() {
+		sequencer().Toggle()
+	}))
+	ulua.L.SetField(pkg, "Playing", luar.New(ulua.L, func() bool {
+		return sequencer().IsPlaying()
+	}))
+	ulua.L.SetField(pkg, "Tempo", luar.New(ulua.L, func() int {
+		return sequencer().Tempo()
+	}))
+	ulua.L.SetField(pkg, "SetTempo", luar.New(ulua.L, func(bpm int) error {
+		return sequencer().SetTempo(bpm)
+	}))
+	ulua.L.SetField(pkg, "Chains", luar.New(ulua.L, func() []string {
+		names := []string{}
+		for _, chain := range sequencer().Rendered() {
+			names = append(names, chain.Name)
+		}
+		return names
+	}))
+	ulua.L.SetField(pkg, "Step", luar.New(ulua.L, func(chain string) (int, error) {
+		return sequencer().Step(chain)
+	}))
+	// parsed text is never played so it does not open any devices
+	ulua.L.SetField(pkg, "Parse", luar.New(ulua.L, parser.NewOffline))
+	ulua.L.SetField(pkg, "Update", luar.New(ulua.L, func(text string) error {
+		return sequencer().Update(text)
+	}))
+	ulua.L.SetField(pkg, "NoteOn", luar.New(ulua.L, func(out string, note int) error {
+		return sequencer().SendNote(out, note, true)
+	}))
+	ulua.L.SetField(pkg, "NoteOff", luar.New(ulua.L, func(out string, note int) error {
+		return sequencer().SendNote(out, note, false)
+	}))
+	ulua.L.SetField(pkg, "MidiName", luar.New(ulua.L, parser.MidiName))
+
+	return pkg
+}
+
+// eventSequencer is the sequencer that sent the event plugins are running
+var eventSequencer *parser.TLI
+
+// sequencer returns the sequencer that the aw functions of plugins act on,
+// which is the one that sent an event while it runs or else the active one
+func sequencer() *parser.TLI {
+	if eventSequencer != nil {
+		return eventSequencer
+	}
+	return globals.TLI
+}
+
+// runSequencerEvent passes an event from a sequencer on to plugins
+func runSequencerEvent(e parser.Event) {
+	eventSequencer = e.Sequencer
+	defer func() { eventSequencer = nil }()
+	var err error
+	switch e.Type {
+	case parser.EventStep:
+		err = config.RunPluginFn("onStep", lua.LString(e.Chain), lua.LNumber(e.Step))
+	case parser.EventCycle:
+		err = config.RunPluginFn("onCycle", lua.LString(e.Chain))
+	case parser.EventPlay:
+		err = config.RunPluginFn("onPlay")
+	case parser.EventStop:
+		err = config.RunPluginFn("onStop")
+	}
+	if err != nil {
+		action.InfoBar.Error(err)
+	}
+}
+
+// the script blocks that are currently loaded
+var luaScript string
+
+// luaGenerator calls the lua function for a lua() token in a loop, looking
+// in the script blocks of the file first and then in the plugins
+func luaGenerator(script string, fn string, args []string) (phrase string, err error) {
+	if script != luaScript {
+		// module() adds to the module that is loaded already, so it is
+		// removed first or functions deleted from the script would stay
+		ulua.L.SetGlobal("awscript", lua.LNil)
+		if loaded, ok := ulua.L.GetField(ulua.L.GetGlobal("package"), "loaded").(*lua.LTable); ok {
+			loaded.RawSetString("awscript", lua.LNil)
+		}
+		luaScript = script
+		if script != "" {
+			err = ulua.LoadFile("awscript", "script", []byte(script))
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var luafn lua.LValue = lua.LNil
+	if mod := ulua.L.GetGlobal("awscript"); mod != lua.LNil {
+		luafn = ulua.L.GetField(mod, fn)
+	}
+	for _, p := range config.Plugins {
+		if luafn != lua.LNil {
+			break
+		}
+		if plug := ulua.L.GetGlobal(p.Name); p.IsLoaded() && plug != lua.LNil {
+			luafn = ulua.L.GetField(plug, fn)
+		}
+	}
+	if luafn == lua.LNil {
+		err = fmt.Errorf("no lua function '%s'", fn)
+		return
+	}
+
+	luaArgs := []lua.LValue{}
+	for _, arg := range args {
+		if num, errNum := strconv.ParseFloat(arg, 64); errNum == nil {
+			luaArgs = append(luaArgs, lua.LNumber(num))
+		} else {
+			luaArgs = append(luaArgs, lua.LString(arg))
+		}
+	}
+	err = ulua.L.CallByParam(lua.P{
+		Fn:      luafn,
+		NRet:    1,
+		Protect: true,
+	}, luaArgs...)
+	if err != nil {
+		return
+	}
+	ret := ulua.L.Get(-1)
+	ulua.L.Pop(1)
+	phrase = luaPhrase(ret)
+	return
+}
+
+// luaPhrase converts what a generator returns into tokens, numbers are midi
+// notes and tables inside of the returned table are grouped in brackets
+func luaPhrase(v lua.LValue) string {
+	switch t := v.(type) {
+	case lua.LString:
+		return string(t)
+	case lua.LNumber:
+		return parser.MidiName(int(t))
+	case *lua.LTable:
+		tokens := []string{}
+		for i := 1; i <= t.Len(); i++ {
+			token := luaPhrase(t.RawGetInt(i))
+			if _, ok := t.RawGetInt(i).(*lua.LTable); ok {
+				token = "[" + token + "]"
+			}
+			tokens = append(tokens, token)
+		}
+		return strings.Join(tokens, " ")
+	}
+	return ""
+}
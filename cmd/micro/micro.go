@@ -22,6 +22,8 @@ import (
 	"github.com/schollz/aw/internal/buffer"
 	"github.com/schollz/aw/internal/clipboard"
 	"github.com/schollz/aw/internal/config"
+	"github.com/schollz/aw/internal/globals"
+	"github.com/schollz/aw/internal/parser"
 	"github.com/schollz/aw/internal/screen"
 	"github.com/schollz/aw/internal/shell"
 	"github.com/schollz/aw/internal/util"
@@ -79,6 +81,13 @@ func InitFlags() {
 		fmt.Println("-plugin available")
 		fmt.Println("    \tList available plugins")
 
+		fmt.Print("\nThe sequencer can be managed at the command line with the following commands.\n")
+		fmt.Println("devices")
+		fmt.Println("    \tList the midi devices and crows")
+		fmt.Println("render [FILE] [--wav] [OUTPUT]")
+		fmt.Println("    \tRender the song of a file, or the project entry, to a midi file")
+		fmt.Println("    \tor with --wav to a wav file using a built-in synth")
+
 		fmt.Print("\nMicro's options can also be set via command line arguments for quick\nadjustments. For real configuration, please use the settings.json\nfile (see 'help options').\n\n")
 		fmt.Println("-option value")
 		fmt.Println("    \tSet `option` to `value` for this session")
@@ -225,6 +234,7 @@ func LoadInput(args []string) []*buffer.Buffer {
 
 func Run() {
 	defer func() {
+		globals.CloseAll()
 		if util.Stdout.Len() > 0 {
 			fmt.Fprint(os.Stdout, util.Stdout.String())
 		}
@@ -234,6 +244,7 @@ func Run() {
 	var err error
 
 	InitFlags()
+	DoSubcommands()
 
 	if *flagProfile {
 		f, err := os.Create("micro.prof")
@@ -291,6 +302,7 @@ func Run() {
 
 	defer func() {
 		if err := recover(); err != nil {
+			globals.CloseAll()
 			if screen.Screen != nil {
 				screen.Screen.Fini()
 			}
@@ -439,7 +451,19 @@ func DoEvent() {
 		}
 	case f := <-timerChan:
 		f()
+	case e := <-parser.Events:
+		runSequencerEvent(e)
+	case <-parser.Transports:
+		for _, e := range parser.TransportEvents() {
+			runSequencerEvent(e)
+		}
+	case <-parser.Cycles:
+		// chains that generate again have their next cycle ready before it plays
+		if err := parser.GenerateCycles(); err != nil {
+			action.InfoBar.Error(err)
+		}
 	case <-sighup:
+		globals.CloseAll()
 		for _, b := range buffer.OpenBuffers {
 			if !b.Modified() {
 				b.Fini()
@@ -447,6 +471,7 @@ func DoEvent() {
 		}
 		os.Exit(0)
 	case <-util.Sigterm:
+		globals.CloseAll()
 		for _, b := range buffer.OpenBuffers {
 			if !b.Modified() {
 				b.Fini()
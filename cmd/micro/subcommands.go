@@ -0,0 +1,103 @@
+package micro
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/schollz/aw/internal/parser"
+	"github.com/schollz/aw/internal/synth"
+)
+
+// DoSubcommands runs any subcommand given on the command line
+// (e.g. `aw devices`) and exits
+func DoSubcommands() {
+	if flag.NArg() == 0 {
+		return
+	}
+	switch flag.Arg(0) {
+	case "devices":
+		// only a subcommand if there is no file called devices
+		if _, err := os.Stat(flag.Arg(0)); err == nil {
+			return
+		}
+		fmt.Print(parser.ListDevices().String())
+		os.Exit(0)
+	case "render":
+		if _, err := os.Stat(flag.Arg(0)); err == nil {
+			return
+		}
+		if err := render(flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+}
+
+// render writes the arrangement of a file to a midi file, or with --wav
+// synthesizes it to a wav file, e.g. `aw render song.tli --wav song.wav`.
+// Without a file the entry of the project in the current directory is used.
+func render(args []string) (err error) {
+	filename, output := "", ""
+	wav := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--wav" || args[i] == "-wav":
+			wav = true
+			if i+1 < len(args) && strings.HasSuffix(args[i+1], ".wav") {
+				output = args[i+1]
+				i++
+			}
+		case strings.HasSuffix(args[i], ".wav"):
+			wav = true
+			output = args[i]
+		case filename == "":
+			filename = args[i]
+		default:
+			output = args[i]
+		}
+	}
+	if filename == "" {
+		var project *parser.Project
+		project, err = parser.FindProject(filepath.Join(".", parser.ProjectFilename))
+		if err != nil {
+			return
+		}
+		if project == nil || project.Entry == "" {
+			return fmt.Errorf("usage: aw render [FILE] [--wav] [OUTPUT]")
+		}
+		filename = project.Entry
+	}
+	if output == "" {
+		output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".mid"
+		if wav {
+			output = strings.TrimSuffix(output, ".mid") + ".wav"
+		}
+	}
+	text, _, err := parser.Load(filename)
+	if err != nil {
+		return
+	}
+	tli, err := parser.NewOffline(text)
+	if err != nil {
+		return
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if wav {
+		err = synth.WriteWav(f, synth.Render(tli.Arrange()))
+	} else {
+		err = tli.WriteMidi(f)
+	}
+	if err != nil {
+		return
+	}
+	fmt.Printf("wrote %s\n", output)
+	return
+}
@@ -20,6 +20,7 @@ require (
 	github.com/zyedidia/json5 v0.0.0-20200102012142-2da050b1a98d
 	github.com/zyedidia/tcell/v2 v2.0.10
 	github.com/zyedidia/terminal v0.0.0-20230315200948-4b3bcf6dddef
+	gitlab.com/gomidi/midi/v2 v2.1.7
 	go.bug.st/serial v1.6.2
 	golang.org/x/text v0.3.8
 	gopkg.in/yaml.v2 v2.2.8
@@ -36,7 +37,6 @@ require (
 	github.com/rivo/uniseg v0.1.0 // indirect
 	github.com/xo/terminfo v0.0.0-20200218205459-454e5b68f9e8 // indirect
 	github.com/zyedidia/poller v1.0.1 // indirect
-	gitlab.com/gomidi/midi/v2 v2.1.7 // indirect
 	golang.org/x/sys v0.0.0-20220829200755-d48e67d00261 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
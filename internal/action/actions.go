@@ -16,6 +16,7 @@ import (
 	"github.com/schollz/aw/internal/config"
 	"github.com/schollz/aw/internal/display"
 	"github.com/schollz/aw/internal/globals"
+	"github.com/schollz/aw/internal/parser"
 	"github.com/schollz/aw/internal/screen"
 	"github.com/schollz/aw/internal/shell"
 	"github.com/schollz/aw/internal/util"
@@ -806,7 +807,8 @@ func (h *BufPane) Autocomplete() bool {
 	}
 	r := h.Cursor.RuneUnder(h.Cursor.X)
 	prev := h.Cursor.RuneUnder(h.Cursor.X - 1)
-	if !util.IsAutocomplete(prev) || util.IsWordChar(r) {
+	tli := isTLI(b) && (prev == '(' || prev == ',' || prev == '#')
+	if (!util.IsAutocomplete(prev) && !tli) || util.IsWordChar(r) {
 		// don't autocomplete if cursor is within a word
 		return false
 	}
@@ -815,6 +817,9 @@ func (h *BufPane) Autocomplete() bool {
 		b.CycleAutocomplete(true)
 		return true
 	}
+	if isTLI(b) && b.Autocomplete(TLIComplete) {
+		return true
+	}
 	return b.Autocomplete(buffer.BufferComplete)
 }
 
@@ -932,7 +937,9 @@ func (h *BufPane) saveBufToFile(filename string, action string, callback func())
 					h.Buf.Path = filename
 					h.Buf.SetName(filename)
 					InfoBar.Message("Saved " + filename)
-					globals.ProcessFilename(filename)
+					if err := globals.ProcessFilename(filename); err != nil {
+						InfoBar.Error(err)
+					}
 					if callback != nil {
 						callback()
 					}
@@ -959,7 +966,9 @@ func (h *BufPane) saveBufToFile(filename string, action string, callback func())
 		h.Buf.Path = filename
 		h.Buf.SetName(filename)
 		InfoBar.Message("Saved " + filename)
-		globals.ProcessFilename(filename)
+		if err := globals.ProcessFilename(filename); err != nil {
+			InfoBar.Error(err)
+		}
 		if callback != nil {
 			callback()
 		}
@@ -1558,6 +1567,104 @@ func (h *BufPane) ToggleRuler() bool {
 	return true
 }
 
+// chainUnderCursor returns the name of the `tie` chain that the cursor is in.
+// The name comes from the text the sequencer of the file plays, which
+// numbers chains of the same name across every included file.
+func (h *BufPane) chainUnderCursor() string {
+	if s := globals.SequencerFor(h.Buf.AbsPath); s != nil {
+		if name, ok := s.ChainAt(h.Buf.AbsPath, h.Cursor.Y); ok {
+			return name
+		}
+	}
+	lines := make([]string, h.Cursor.Y+1)
+	for y := range lines {
+		lines[y] = h.Buf.Line(y)
+	}
+	return parser.ChainAt(lines)
+}
+
+// ToggleChainMute mutes or unmutes the chain under the cursor
+func (h *BufPane) ToggleChainMute() bool {
+	toggleChainMute(h.chainUnderCursor())
+	return true
+}
+
+// ToggleChainSolo solos or unsolos the chain under the cursor
+func (h *BufPane) ToggleChainSolo() bool {
+	toggleChainSolo(h.chainUnderCursor())
+	return true
+}
+
+// ToggleChainLaunch launches or halts the chain under the cursor
+func (h *BufPane) ToggleChainLaunch() bool {
+	toggleChainLaunch(h.chainUnderCursor())
+	return true
+}
+
+// evalRegion returns the run, tie or lane block under the cursor, or the selection,
+// along with the header of the block the selection starts in when the
+// selection does not start with one
+func (h *BufPane) evalRegion() (start, end buffer.Loc, header string, err error) {
+	if h.Cursor.HasSelection() {
+		start, end = h.Cursor.CurSelection[0], h.Cursor.CurSelection[1]
+		if start.GreaterThan(end) {
+			start, end = end, start
+		}
+	} else {
+		start, end = buffer.Loc{X: 0, Y: h.Cursor.Y}, buffer.Loc{X: 0, Y: h.Cursor.Y}
+		for end.Y+1 < h.Buf.LinesNum() && !parser.IsBlockStart(h.Buf.Line(end.Y+1)) {
+			end.Y++
+		}
+		for end.Y > start.Y && strings.TrimSpace(h.Buf.Line(end.Y)) == "" {
+			end.Y--
+		}
+		end.X = util.CharacterCountInString(h.Buf.Line(end.Y))
+	}
+	for y := start.Y; y >= 0; y-- {
+		line := h.Buf.Line(y)
+		if !parser.IsBlockStart(line) {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "run") && !strings.HasPrefix(trimmed, "tie") && !strings.HasPrefix(trimmed, "lane") {
+			break
+		}
+		if y == start.Y && start.X == 0 {
+			return
+		}
+		if h.Cursor.HasSelection() {
+			header = line
+		} else {
+			start.Y = y
+		}
+		return
+	}
+	err = errors.New("not in a run, tie or lane block")
+	return
+}
+
+// Eval plays the run, tie or lane block under the cursor, or the selection,
+// right away without saving the file
+func (h *BufPane) Eval() bool {
+	start, end, header, err := h.evalRegion()
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+	part := string(h.Buf.Substr(start, end))
+	if header != "" {
+		part = header + "\n" + part
+	}
+	names, err := globals.Eval(h.Buf.AbsPath, part)
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+	h.Buf.Flash(start, end)
+	InfoBar.Message("Evaluated " + strings.Join(names, ", "))
+	return true
+}
+
 // ClearStatus clears the messenger bar
 func (h *BufPane) ClearStatus() bool {
 	InfoBar.Message("")
@@ -700,6 +700,11 @@ func (h *BufPane) HSplitBuf(buf *buffer.Buffer) *BufPane {
 // Close this pane.
 func (h *BufPane) Close() {
 	h.Buf.Close()
+	open := []string{}
+	for _, b := range buffer.OpenBuffers {
+		open = append(open, b.AbsPath)
+	}
+	globals.Release(h.Buf.AbsPath, open)
 }
 
 // SetActive marks this pane as active.
@@ -710,6 +715,8 @@ func (h *BufPane) SetActive(b bool) {
 
 	h.BWindow.SetActive(b)
 	if b {
+		globals.Activate(h.Buf.AbsPath)
+
 		// Display any gutter messages for this line
 		c := h.Buf.GetActiveCursor()
 		none := true
@@ -816,6 +823,10 @@ var BufKeyActions = map[string]BufKeyAction{
 	"ToggleKeyMenu":             (*BufPane).ToggleKeyMenu,
 	"ToggleDiffGutter":          (*BufPane).ToggleDiffGutter,
 	"ToggleRuler":               (*BufPane).ToggleRuler,
+	"ToggleChainMute":           (*BufPane).ToggleChainMute,
+	"ToggleChainSolo":           (*BufPane).ToggleChainSolo,
+	"ToggleChainLaunch":         (*BufPane).ToggleChainLaunch,
+	"Eval":                      (*BufPane).Eval,
 	"ToggleHighlightSearch":     (*BufPane).ToggleHighlightSearch,
 	"UnhighlightSearch":         (*BufPane).UnhighlightSearch,
 	"ClearStatus":               (*BufPane).ClearStatus,
@@ -0,0 +1,127 @@
+package action
+
+import (
+	"runtime"
+
+	"github.com/schollz/aw/internal/display"
+	"github.com/schollz/aw/internal/globals"
+	"github.com/schollz/aw/internal/screen"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// ChainsPane shows the state of every chain in the sequencer and lets the
+// user mute, solo, launch and halt them while performing
+type ChainsPane struct {
+	*display.ChainsWindow
+
+	id  uint64
+	tab *Tab
+}
+
+func NewChainsPane(x, y, w, h int, id uint64, tab *Tab) *ChainsPane {
+	cp := new(ChainsPane)
+	cp.ChainsWindow = display.NewChainsWindow(x, y, w, h)
+	cp.id = id
+	cp.tab = tab
+	return cp
+}
+
+func (c *ChainsPane) ID() uint64 {
+	return c.id
+}
+
+func (c *ChainsPane) SetID(i uint64) {
+	c.id = i
+}
+
+func (c *ChainsPane) Name() string {
+	return "chains"
+}
+
+func (c *ChainsPane) SetTab(t *Tab) {
+	c.tab = t
+}
+
+func (c *ChainsPane) Tab() *Tab {
+	return c.tab
+}
+
+func (c *ChainsPane) Close() {}
+
+// Quit closes this pane
+func (c *ChainsPane) Quit() {
+	c.Close()
+	if len(MainTab().Panes) > 1 {
+		c.Unsplit()
+	} else if len(Tabs.List) > 1 {
+		Tabs.RemoveTab(c.id)
+	} else {
+		screen.Screen.Fini()
+		InfoBar.Close()
+		runtime.Goexit()
+	}
+}
+
+// Unsplit removes this split
+func (c *ChainsPane) Unsplit() {
+	n := MainTab().GetNode(c.id)
+	n.Unsplit()
+
+	MainTab().RemovePane(MainTab().GetPane(c.id))
+	MainTab().Resize()
+	MainTab().SetActive(len(MainTab().Panes) - 1)
+}
+
+// NextSplit moves to the next split
+func (c *ChainsPane) NextSplit() {
+	a := c.tab.active
+	if a < len(c.tab.Panes)-1 {
+		a++
+	} else {
+		a = 0
+	}
+
+	c.tab.SetActive(a)
+}
+
+// HandleEvent handles the keys for selecting and toggling chains
+func (c *ChainsPane) HandleEvent(event tcell.Event) {
+	e, ok := event.(*tcell.EventKey)
+	if !ok {
+		return
+	}
+	switch e.Key() {
+	case tcell.KeyUp:
+		c.MoveSelection(-1)
+	case tcell.KeyDown:
+		c.MoveSelection(1)
+	case tcell.KeyEnter:
+		toggleChainLaunch(c.ChainName())
+	case tcell.KeyCtrlSpace:
+		globals.TLI.Toggle()
+	case tcell.KeyCtrlW:
+		c.NextSplit()
+	case tcell.KeyEscape, tcell.KeyCtrlQ:
+		c.Quit()
+	case tcell.KeyRune:
+		switch e.Rune() {
+		case 'k':
+			c.MoveSelection(-1)
+		case 'j':
+			c.MoveSelection(1)
+		case 'm':
+			toggleChainMute(c.ChainName())
+		case 's':
+			toggleChainSolo(c.ChainName())
+		case ' ':
+			globals.TLI.Toggle()
+		case 'q':
+			c.Quit()
+		}
+	}
+}
+
+// HandleCommand handles a command for the chains pane
+func (c *ChainsPane) HandleCommand(input string) {
+	InfoBar.Error("Commands are unsupported in the chains pane")
+}
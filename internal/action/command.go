@@ -15,6 +15,8 @@ import (
 	"github.com/schollz/aw/internal/buffer"
 	"github.com/schollz/aw/internal/clipboard"
 	"github.com/schollz/aw/internal/config"
+	"github.com/schollz/aw/internal/globals"
+	"github.com/schollz/aw/internal/parser"
 	"github.com/schollz/aw/internal/screen"
 	"github.com/schollz/aw/internal/shell"
 	"github.com/schollz/aw/internal/util"
@@ -64,6 +66,14 @@ func InitCommands() {
 		"retab":      {(*BufPane).RetabCmd, nil},
 		"raw":        {(*BufPane).RawCmd, nil},
 		"textfilter": {(*BufPane).TextFilterCmd, nil},
+		"chains":     {(*BufPane).ChainsCmd, nil},
+		"roll":       {(*BufPane).RollCmd, nil},
+		"devices":    {(*BufPane).DevicesCmd, nil},
+		"mute":       {(*BufPane).MuteCmd, ChainComplete},
+		"solo":       {(*BufPane).SoloCmd, ChainComplete},
+		"launch":     {(*BufPane).LaunchCmd, ChainComplete},
+		"halt":       {(*BufPane).HaltCmd, ChainComplete},
+		"scene":      {(*BufPane).SceneCmd, buffer.FileComplete},
 	}
 }
 
@@ -1029,6 +1039,120 @@ func (h *BufPane) TermCmd(args []string) {
 	}
 }
 
+// ChainsCmd opens a pane listing the sequencer's chains in a horizontal split
+func (h *BufPane) ChainsCmd(args []string) {
+	id := MainTab().GetNode(h.splitID).HSplit(h.Buf.Settings["splitbottom"].(bool))
+	cp := NewChainsPane(0, 0, 0, 0, id, MainTab())
+	MainTab().Panes = append(MainTab().Panes, cp)
+	MainTab().Resize()
+	MainTab().SetActive(len(MainTab().Panes) - 1)
+}
+
+// RollCmd opens a piano roll of the sequencer's chains in a horizontal split
+func (h *BufPane) RollCmd(args []string) {
+	id := MainTab().GetNode(h.splitID).HSplit(h.Buf.Settings["splitbottom"].(bool))
+	rp := NewRollPane(0, 0, 0, 0, id, MainTab())
+	MainTab().Panes = append(MainTab().Panes, rp)
+	MainTab().Resize()
+	MainTab().SetActive(len(MainTab().Panes) - 1)
+}
+
+// DevicesCmd lists the midi devices and crows that can be used in out lines
+func (h *BufPane) DevicesCmd(args []string) {
+	devices := parser.ListDevices()
+	h.HSplitBuf(buffer.NewBufferFromString(devices.String(), "devices", buffer.BTScratch))
+}
+
+// chainArg returns the chain given as an argument, or the
+// chain under the cursor if there is no argument
+func (h *BufPane) chainArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return h.chainUnderCursor()
+}
+
+// MuteCmd toggles muting of the given chain
+func (h *BufPane) MuteCmd(args []string) {
+	toggleChainMute(h.chainArg(args))
+}
+
+// SoloCmd toggles soloing of the given chain
+func (h *BufPane) SoloCmd(args []string) {
+	toggleChainSolo(h.chainArg(args))
+}
+
+// LaunchCmd starts the given chain from its first step
+func (h *BufPane) LaunchCmd(args []string) {
+	name := h.chainArg(args)
+	if err := globals.TLI.Launch(name); err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	InfoBar.Message("Launched " + name)
+}
+
+// HaltCmd stops the given chain while the others keep playing
+func (h *BufPane) HaltCmd(args []string) {
+	name := h.chainArg(args)
+	if err := globals.TLI.Halt(name); err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	InfoBar.Message("Halted " + name)
+}
+
+// SceneCmd plays the given file, or the current buffer, and
+// stops every other file that is playing
+func (h *BufPane) SceneCmd(args []string) {
+	filename := h.Buf.AbsPath
+	if len(args) > 0 {
+		filename = args[0]
+	}
+	if filename == "" {
+		InfoBar.Error("No file to play")
+		return
+	}
+	if err := globals.Scene(filename); err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	InfoBar.Message("Playing " + filepath.Base(filename))
+}
+
+func toggleChainMute(name string) {
+	muted, err := globals.TLI.ToggleMute(name)
+	if err != nil {
+		InfoBar.Error(err)
+	} else if muted {
+		InfoBar.Message("Muted " + name)
+	} else {
+		InfoBar.Message("Unmuted " + name)
+	}
+}
+
+func toggleChainSolo(name string) {
+	soloed, err := globals.TLI.ToggleSolo(name)
+	if err != nil {
+		InfoBar.Error(err)
+	} else if soloed {
+		InfoBar.Message("Soloed " + name)
+	} else {
+		InfoBar.Message("Unsoloed " + name)
+	}
+}
+
+func toggleChainLaunch(name string) {
+	launched, err := globals.TLI.ToggleLaunch(name)
+	if err != nil {
+		InfoBar.Error(err)
+	} else if launched {
+		InfoBar.Message("Launched " + name)
+	} else {
+		InfoBar.Message("Halted " + name)
+	}
+}
+
 // HandleCommand handles input from the user
 func (h *BufPane) HandleCommand(input string) {
 	args, err := shellquote.Split(input)
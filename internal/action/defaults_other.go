@@ -110,6 +110,13 @@ var bufdefaults = map[string]string{
 	"Alt-p":        "RemoveMultiCursor",
 	"Alt-c":        "RemoveAllMultiCursors",
 	"Alt-x":        "SkipMultiCursor",
+
+	// Sequencer bindings
+	"Alt-M": "ToggleChainMute",
+	"Alt-S": "ToggleChainSolo",
+	"Alt-L": "ToggleChainLaunch",
+	// evaluates the block under the cursor, or the selection, without saving
+	"Alt-Enter": "Eval",
 }
 
 var infodefaults = map[string]string{
@@ -7,6 +7,7 @@ import (
 
 	"github.com/schollz/aw/internal/buffer"
 	"github.com/schollz/aw/internal/config"
+	"github.com/schollz/aw/internal/globals"
 	"github.com/schollz/aw/internal/util"
 	"github.com/schollz/aw/pkg/highlight"
 )
@@ -58,6 +59,28 @@ func HelpComplete(b *buffer.Buffer) ([]string, []string) {
 	return completions, suggestions
 }
 
+// ChainComplete autocompletes the names of the sequencer's chains
+func ChainComplete(b *buffer.Buffer) ([]string, []string) {
+	c := b.GetActiveCursor()
+	input, argstart := b.GetArg()
+
+	var suggestions []string
+	if globals.TLI != nil {
+		for _, chain := range globals.TLI.Rendered() {
+			if strings.HasPrefix(chain.Name, input) {
+				suggestions = append(suggestions, chain.Name)
+			}
+		}
+	}
+
+	sort.Strings(suggestions)
+	completions := make([]string, len(suggestions))
+	for i := range suggestions {
+		completions[i] = util.SliceEndStr(suggestions[i], c.X-argstart)
+	}
+	return completions, suggestions
+}
+
 // colorschemeComplete tab-completes names of colorschemes.
 // This is just a heper value for OptionValueComplete
 func colorschemeComplete(input string) (string, []string) {
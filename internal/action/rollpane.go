@@ -0,0 +1,133 @@
+package action
+
+import (
+	"runtime"
+
+	"github.com/schollz/aw/internal/display"
+	"github.com/schollz/aw/internal/globals"
+	"github.com/schollz/aw/internal/screen"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// RollPane is a read-only pane that shows the chains of the sequencer
+// as a piano roll
+type RollPane struct {
+	*display.RollWindow
+
+	id  uint64
+	tab *Tab
+}
+
+func NewRollPane(x, y, w, h int, id uint64, tab *Tab) *RollPane {
+	rp := new(RollPane)
+	rp.RollWindow = display.NewRollWindow(x, y, w, h)
+	rp.id = id
+	rp.tab = tab
+	return rp
+}
+
+func (r *RollPane) ID() uint64 {
+	return r.id
+}
+
+func (r *RollPane) SetID(i uint64) {
+	r.id = i
+}
+
+func (r *RollPane) Name() string {
+	return "roll"
+}
+
+func (r *RollPane) SetTab(t *Tab) {
+	r.tab = t
+}
+
+func (r *RollPane) Tab() *Tab {
+	return r.tab
+}
+
+func (r *RollPane) Close() {}
+
+// Quit closes this pane
+func (r *RollPane) Quit() {
+	r.Close()
+	if len(MainTab().Panes) > 1 {
+		r.Unsplit()
+	} else if len(Tabs.List) > 1 {
+		Tabs.RemoveTab(r.id)
+	} else {
+		screen.Screen.Fini()
+		InfoBar.Close()
+		runtime.Goexit()
+	}
+}
+
+// Unsplit removes this split
+func (r *RollPane) Unsplit() {
+	n := MainTab().GetNode(r.id)
+	n.Unsplit()
+
+	MainTab().RemovePane(MainTab().GetPane(r.id))
+	MainTab().Resize()
+	MainTab().SetActive(len(MainTab().Panes) - 1)
+}
+
+// NextSplit moves to the next split
+func (r *RollPane) NextSplit() {
+	a := r.tab.active
+	if a < len(r.tab.Panes)-1 {
+		a++
+	} else {
+		a = 0
+	}
+
+	r.tab.SetActive(a)
+}
+
+// HandleEvent handles the keys for scrolling and zooming the roll
+func (r *RollPane) HandleEvent(event tcell.Event) {
+	e, ok := event.(*tcell.EventKey)
+	if !ok {
+		return
+	}
+	switch e.Key() {
+	case tcell.KeyLeft:
+		r.Scroll(-1)
+	case tcell.KeyRight:
+		r.Scroll(1)
+	case tcell.KeyUp:
+		r.ScrollNotes(-1)
+	case tcell.KeyDown:
+		r.ScrollNotes(1)
+	case tcell.KeyCtrlSpace:
+		globals.TLI.Toggle()
+	case tcell.KeyCtrlW:
+		r.NextSplit()
+	case tcell.KeyEscape, tcell.KeyCtrlQ:
+		r.Quit()
+	case tcell.KeyRune:
+		switch e.Rune() {
+		case 'h':
+			r.Scroll(-1)
+		case 'l':
+			r.Scroll(1)
+		case 'k':
+			r.ScrollNotes(-1)
+		case 'j':
+			r.ScrollNotes(1)
+		case '+', '=':
+			r.ZoomBy(1)
+		case '-':
+			r.ZoomBy(-1)
+		case ' ':
+			globals.TLI.Toggle()
+		case 'q':
+			r.Quit()
+		}
+	}
+}
+
+// HandleCommand handles a command for the roll pane
+func (r *RollPane) HandleCommand(input string) {
+	InfoBar.Error("Commands are unsupported in the roll pane")
+}
@@ -0,0 +1,38 @@
+package action
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/schollz/aw/internal/buffer"
+	"github.com/schollz/aw/internal/globals"
+	"github.com/schollz/aw/internal/parser"
+)
+
+// isTLI returns whether the buffer holds a sequence
+func isTLI(b *buffer.Buffer) bool {
+	return strings.HasSuffix(b.Path, ".tli")
+}
+
+// TLIComplete autocompletes chords, decorators, loop names
+// and midi devices in .tli buffers
+func TLIComplete(b *buffer.Buffer) ([]string, []string) {
+	c := b.GetActiveCursor()
+	lines := make([]string, b.LinesNum())
+	for i := range lines {
+		lines[i] = b.Line(i)
+	}
+
+	devices := []string{}
+	if globals.TLI != nil {
+		for alias := range globals.TLI.Devices {
+			devices = append(devices, alias)
+		}
+		sort.Strings(devices)
+	}
+	devices = append(devices, parser.MidiOutputs()...)
+
+	completions, suggestions, replace := parser.Complete(lines, c.Y, c.X, devices)
+	b.CompletionReplace = replace
+	return completions, suggestions
+}
@@ -25,6 +25,7 @@ func (b *Buffer) GetSuggestions() {
 
 // Autocomplete starts the autocomplete process
 func (b *Buffer) Autocomplete(c Completer) bool {
+	b.CompletionReplace = 0
 	b.Completions, b.Suggestions = c(b)
 	if len(b.Completions) != len(b.Suggestions) || len(b.Completions) == 0 {
 		return false
@@ -54,6 +55,8 @@ func (b *Buffer) CycleAutocomplete(forward bool) {
 	end := c.Loc
 	if prevSuggestion < len(b.Suggestions) && prevSuggestion >= 0 {
 		start = end.Move(-util.CharacterCountInString(b.Completions[prevSuggestion]), b)
+	} else {
+		start = end.Move(-b.CompletionReplace, b)
 	}
 
 	b.Replace(start, end, b.Completions[b.CurSuggestion])
@@ -134,6 +134,7 @@ func (b *Buffer) ApplyBackup(fsize int64) (bool, bool) {
 					// recover
 					b.LineArray = NewLineArray(uint64(fsize), FFAuto, backup)
 					b.isModified = true
+					b.edits++
 					return true, true
 				} else if choice%3 == 1 {
 					// delete
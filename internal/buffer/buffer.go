@@ -94,6 +94,9 @@ type SharedBuffer struct {
 	Suggestions   []string
 	Completions   []string
 	CurSuggestion int
+	// CompletionReplace is how many characters before the cursor the first
+	// completion replaces, a completer can set it to replace what was typed
+	CompletionReplace int
 
 	Messages []*Message
 
@@ -121,6 +124,8 @@ type SharedBuffer struct {
 	SyntaxDef *highlight.Def
 
 	ModifiedThisFrame bool
+	// edits counts every change to the text
+	edits uint64
 
 	// Hash of the original buffer -- empty if fastdirty is on
 	origHash [md5.Size]byte
@@ -128,6 +133,7 @@ type SharedBuffer struct {
 
 func (b *SharedBuffer) insert(pos Loc, value []byte) {
 	b.isModified = true
+	b.edits++
 	b.HasSuggestions = false
 	b.LineArray.insert(pos, value)
 
@@ -136,11 +142,17 @@ func (b *SharedBuffer) insert(pos Loc, value []byte) {
 }
 func (b *SharedBuffer) remove(start, end Loc) []byte {
 	b.isModified = true
+	b.edits++
 	b.HasSuggestions = false
 	defer b.MarkModified(start.Y, end.Y)
 	return b.LineArray.remove(start, end)
 }
 
+// Edits returns how many times the text of the buffer has changed
+func (b *SharedBuffer) Edits() uint64 {
+	return b.edits
+}
+
 // MarkModified marks the buffer as modified for this frame
 // and performs rehighlighting if syntax highlighting is enabled
 func (b *SharedBuffer) MarkModified(start, end int) {
@@ -211,6 +223,10 @@ type Buffer struct {
 	LastSearchRegex bool
 	// HighlightSearch enables highlighting all instances of the last successful search
 	HighlightSearch bool
+
+	// flash is a region that is highlighted until flashUntil
+	flash      [2]Loc
+	flashUntil time.Time
 }
 
 // NewBufferFromFileAtLoc opens a new buffer with a given cursor location
@@ -294,6 +294,27 @@ func BenchmarkEdit100000Lines1Cursor(b *testing.B) {
 	benchEdit(b, 100000, 1)
 }
 
+func TestEdits(t *testing.T) {
+	b := NewBufferFromString("def riff = c4 e g\nriff", "", BTDefault)
+	edits := b.Edits()
+	b.Insert(Loc{17, 0}, " a")
+	assert.Equal(t, edits+1, b.Edits())
+	b.Remove(Loc{17, 0}, Loc{19, 0})
+	assert.Equal(t, edits+2, b.Edits())
+}
+
+func TestAutocompleteReplace(t *testing.T) {
+	b := NewBufferFromString("out midi(um-o", "", BTDefault)
+	b.GetActiveCursor().GotoLoc(Loc{13, 0})
+	assert.True(t, b.Autocomplete(func(b *Buffer) ([]string, []string) {
+		b.CompletionReplace = 4
+		return []string{"UM-ONE", "UM-TWO"}, []string{"UM-ONE", "UM-TWO"}
+	}))
+	assert.Equal(t, "out midi(UM-ONE", b.Line(0))
+	b.CycleAutocomplete(true)
+	assert.Equal(t, "out midi(UM-TWO", b.Line(0))
+}
+
 func BenchmarkEdit100000Lines10Cursors(b *testing.B) {
 	benchEdit(b, 100000, 10)
 }
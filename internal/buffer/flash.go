@@ -0,0 +1,23 @@
+package buffer
+
+import (
+	"time"
+
+	"github.com/schollz/aw/internal/screen"
+)
+
+// FlashDuration is how long a flashed region stays highlighted
+const FlashDuration = 250 * time.Millisecond
+
+// Flash highlights a region of the buffer for a moment, like
+// a block that was just evaluated
+func (b *Buffer) Flash(start, end Loc) {
+	b.flash = [2]Loc{start, end}
+	b.flashUntil = time.Now().Add(FlashDuration)
+	time.AfterFunc(FlashDuration, screen.Redraw)
+}
+
+// Flashing returns whether a location is in the region that is flashing
+func (b *Buffer) Flashing(loc Loc) bool {
+	return time.Now().Before(b.flashUntil) && loc.GreaterEqual(b.flash[0]) && loc.LessThan(b.flash[1])
+}
@@ -129,6 +129,19 @@ func (m *Murder) Close() (err error) {
 	return
 }
 
+// List returns the port of every crow that is connected
+func List() (ports []string, err error) {
+	m, err := New()
+	if err != nil {
+		return
+	}
+	for _, crow := range m.Crow {
+		ports = append(ports, crow.PortName)
+	}
+	err = m.Close()
+	return
+}
+
 // On switches the crow, 1-indexed
 func (m *Murder) On(output int, on bool) (err error) {
 	crowIndex := int(math.Floor(float64(output-1) / 4))
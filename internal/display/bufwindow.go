@@ -557,6 +557,13 @@ func (w *BufWindow) displayBuffer() {
 						}
 					}
 
+					if b.Flashing(bloc) {
+						style = config.DefStyle.Reverse(true)
+						if s, ok := config.Colorscheme["flash"]; ok {
+							style = s
+						}
+					}
+
 					for _, m := range b.Messages {
 						if bloc.GreaterEqual(m.Start) && bloc.LessThan(m.End) ||
 							bloc.LessThan(m.End) && bloc.GreaterEqual(m.Start) {
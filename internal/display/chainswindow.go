@@ -0,0 +1,174 @@
+package display
+
+import (
+	"fmt"
+
+	runewidth "github.com/mattn/go-runewidth"
+	"github.com/schollz/aw/internal/buffer"
+	"github.com/schollz/aw/internal/config"
+	"github.com/schollz/aw/internal/globals"
+	"github.com/schollz/aw/internal/screen"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// ChainsWindow lists every rendered chain of the sequencer along
+// with its mute/solo/launch state and the step it is on
+type ChainsWindow struct {
+	*View
+
+	// Selected is the index of the highlighted chain
+	Selected int
+
+	active bool
+}
+
+func NewChainsWindow(x, y, w, h int) *ChainsWindow {
+	cw := new(ChainsWindow)
+	cw.View = new(View)
+	cw.X, cw.Y = x, y
+	cw.Resize(w, h)
+	return cw
+}
+
+func (w *ChainsWindow) Resize(width, height int) {
+	w.Width, w.Height = width, height
+}
+
+func (w *ChainsWindow) SetActive(b bool) {
+	w.active = b
+}
+
+func (w *ChainsWindow) IsActive() bool {
+	return w.active
+}
+
+func (w *ChainsWindow) LocFromVisual(vloc buffer.Loc) buffer.Loc {
+	return vloc
+}
+
+func (w *ChainsWindow) Clear() {
+	for y := 0; y < w.Height; y++ {
+		for x := 0; x < w.Width; x++ {
+			screen.SetContent(w.X+x, w.Y+y, ' ', nil, config.DefStyle)
+		}
+	}
+}
+
+func (w *ChainsWindow) Relocate() bool { return true }
+func (w *ChainsWindow) GetView() *View {
+	return w.View
+}
+func (w *ChainsWindow) SetView(v *View) {
+	w.View = v
+}
+
+// ChainName returns the name of the selected chain
+func (w *ChainsWindow) ChainName() string {
+	if globals.TLI == nil {
+		return ""
+	}
+	chains := globals.TLI.Rendered()
+	if w.Selected < 0 || w.Selected >= len(chains) {
+		return ""
+	}
+	return chains[w.Selected].Name
+}
+
+// MoveSelection moves the highlighted chain up or down
+func (w *ChainsWindow) MoveSelection(delta int) {
+	w.Selected += delta
+	if globals.TLI != nil {
+		if n := len(globals.TLI.Rendered()); w.Selected >= n {
+			w.Selected = n - 1
+		}
+	}
+	if w.Selected < 0 {
+		w.Selected = 0
+	}
+}
+
+func (w *ChainsWindow) drawLine(y int, text string, style tcell.Style) {
+	x := 0
+	for _, r := range text {
+		if x >= w.Width {
+			break
+		}
+		screen.SetContent(w.X+x, w.Y+y, r, nil, style)
+		x += runewidth.RuneWidth(r)
+	}
+	for ; x < w.Width; x++ {
+		screen.SetContent(w.X+x, w.Y+y, ' ', nil, style)
+	}
+}
+
+// Display draws one line per chain
+func (w *ChainsWindow) Display() {
+	statusLineStyle := config.DefStyle.Reverse(true)
+	if style, ok := config.Colorscheme["statusline"]; ok {
+		statusLineStyle = style
+	}
+	height := w.Height - 1
+	if globals.TLI == nil || len(globals.TLI.Rendered()) == 0 {
+		w.drawLine(0, "no chains", config.GetColor("comment"))
+		for y := 1; y < height; y++ {
+			w.drawLine(y, "", config.DefStyle)
+		}
+	} else {
+		chains := globals.TLI.Rendered()
+		start := 0
+		if w.Selected >= height {
+			start = w.Selected - height + 1
+		}
+		for y := 0; y < height; y++ {
+			i := start + y
+			if i >= len(chains) {
+				w.drawLine(y, "", config.DefStyle)
+				continue
+			}
+			chain := chains[i]
+			state := "▶"
+			if chain.Stopped || !globals.TLI.IsPlaying() {
+				state = "■"
+			}
+			flags := ""
+			if chain.Muted {
+				flags += "M"
+			} else {
+				flags += " "
+			}
+			if chain.Soloed {
+				flags += "S"
+			} else {
+				flags += " "
+			}
+			step := "-"
+			bar := "-"
+			if len(chain.Steps) > 0 && !chain.Stopped && globals.TLI.IsPlaying() {
+				step = fmt.Sprintf("%d", chain.StepCurrent+1)
+				if chain.StepCurrent < len(chain.Steps) {
+					current := chain.Steps[chain.StepCurrent]
+					bar = fmt.Sprintf("%d.%g", current.Bar, current.Beat)
+				}
+			}
+			text := fmt.Sprintf(" %2d %s %s %-20s step %s/%d bar %s", i+1, state, flags, chain.Name, step, len(chain.Steps), bar)
+
+			style := config.DefStyle
+			if chain.Muted || chain.Stopped {
+				style = config.GetColor("comment")
+			} else if chain.Soloed {
+				style = config.GetColor("special")
+			}
+			if i == w.Selected && w.active {
+				style = style.Reverse(true)
+			}
+			w.drawLine(y, text, style)
+		}
+	}
+	footer := " chains:"
+	if globals.TLI != nil {
+		if section := globals.TLI.Section(); section != "" {
+			footer += " " + section + " |"
+		}
+	}
+	w.drawLine(height, footer+" [m]ute [s]olo [enter] launch/halt [space] play/pause [q]uit", statusLineStyle)
+}
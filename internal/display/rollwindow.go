@@ -0,0 +1,315 @@
+package display
+
+import (
+	"fmt"
+	"math"
+
+	runewidth "github.com/mattn/go-runewidth"
+	"github.com/schollz/aw/internal/buffer"
+	"github.com/schollz/aw/internal/config"
+	"github.com/schollz/aw/internal/globals"
+	"github.com/schollz/aw/internal/parser"
+	"github.com/schollz/aw/internal/screen"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// the colorscheme groups that are cycled through to color each chain
+var rollColors = []string{"identifier", "constant", "statement", "special", "type", "preproc", "constant.string", "symbol"}
+
+const rollGutter = 5
+
+type rollCell struct {
+	r     rune
+	style tcell.Style
+}
+
+// RollWindow draws the rendered chains of the sequencer as a piano roll,
+// with beats along the x-axis and midi notes along the y-axis
+type RollWindow struct {
+	*View
+
+	// Zoom is the number of columns per beat
+	Zoom int
+	// StartBeat is the first beat shown when the sequencer is stopped
+	StartBeat float64
+	// NoteOffset scrolls the notes down from the highest note
+	NoteOffset int
+
+	active bool
+}
+
+func NewRollWindow(x, y, w, h int) *RollWindow {
+	rw := new(RollWindow)
+	rw.View = new(View)
+	rw.X, rw.Y = x, y
+	rw.Zoom = 4
+	rw.Resize(w, h)
+	return rw
+}
+
+func (w *RollWindow) Resize(width, height int) {
+	w.Width, w.Height = width, height
+}
+
+func (w *RollWindow) SetActive(b bool) {
+	w.active = b
+}
+
+func (w *RollWindow) IsActive() bool {
+	return w.active
+}
+
+func (w *RollWindow) LocFromVisual(vloc buffer.Loc) buffer.Loc {
+	return vloc
+}
+
+func (w *RollWindow) Clear() {
+	for y := 0; y < w.Height; y++ {
+		for x := 0; x < w.Width; x++ {
+			screen.SetContent(w.X+x, w.Y+y, ' ', nil, config.DefStyle)
+		}
+	}
+}
+
+func (w *RollWindow) Relocate() bool { return true }
+func (w *RollWindow) GetView() *View {
+	return w.View
+}
+func (w *RollWindow) SetView(v *View) {
+	w.View = v
+}
+
+// Scroll moves the view by the given number of beats
+func (w *RollWindow) Scroll(beats float64) {
+	w.StartBeat = math.Max(0, w.StartBeat+beats)
+}
+
+// ScrollNotes moves the view up or down by the given number of notes
+func (w *RollWindow) ScrollNotes(notes int) {
+	w.NoteOffset += notes
+	if w.NoteOffset < 0 {
+		w.NoteOffset = 0
+	}
+}
+
+// ZoomBy changes the number of columns per beat
+func (w *RollWindow) ZoomBy(delta int) {
+	w.Zoom += delta
+	if w.Zoom < 1 {
+		w.Zoom = 1
+	} else if w.Zoom > 32 {
+		w.Zoom = 32
+	}
+}
+
+// ChainStyle returns the style used to draw the chain at index i
+func ChainStyle(i int, chain parser.Chain) tcell.Style {
+	if chain.Muted || chain.Stopped {
+		return config.GetColor("comment")
+	}
+	return config.GetColor(rollColors[i%len(rollColors)])
+}
+
+func (w *RollWindow) drawText(x, y int, text string, style tcell.Style) int {
+	for _, r := range text {
+		if x >= w.Width {
+			break
+		}
+		screen.SetContent(w.X+x, w.Y+y, r, nil, style)
+		x += runewidth.RuneWidth(r)
+	}
+	return x
+}
+
+// Display draws the piano roll
+func (w *RollWindow) Display() {
+	statusLineStyle := config.DefStyle.Reverse(true)
+	if style, ok := config.Colorscheme["statusline"]; ok {
+		statusLineStyle = style
+	}
+	commentStyle := config.GetColor("comment")
+	rows := w.Height - 2
+	cols := w.Width - rollGutter
+	w.Clear()
+	w.drawFooter(statusLineStyle)
+	if rows < 1 || cols < 1 {
+		return
+	}
+
+	// find the range of notes and the longest chain that is playing
+	var chains []parser.Chain
+	if globals.TLI != nil {
+		chains = globals.TLI.Rendered()
+	}
+	noteMin, noteMax := 128, -1
+	longest := -1
+	beats := make([]float64, len(chains))
+	for i, chain := range chains {
+		beats[i] = globals.TLI.Beat(i)
+		if beats[i] >= 0 && (longest < 0 || chain.BeatsTotal > chains[longest].BeatsTotal) {
+			longest = i
+		}
+		for _, step := range chain.Steps {
+			for _, note := range step.Notes {
+				if note.IsRest || note.IsLegato {
+					continue
+				}
+				if note.Midi < noteMin {
+					noteMin = note.Midi
+				}
+				if note.Midi > noteMax {
+					noteMax = note.Midi
+				}
+			}
+		}
+	}
+	if noteMax < 0 {
+		w.drawText(0, 0, "no notes", commentStyle)
+		return
+	}
+
+	// the highest note is drawn on the first row
+	span := noteMax - noteMin + 1
+	noteTop := noteMax + (rows-span)/2
+	if span > rows {
+		if w.NoteOffset > span-rows {
+			w.NoteOffset = span - rows
+		}
+		noteTop = noteMax - w.NoteOffset
+	}
+
+	// follow the playhead of the longest chain while playing
+	visibleBeats := float64(cols) / float64(w.Zoom)
+	beatPlayhead := -1.0
+	if longest >= 0 {
+		beatPlayhead = beats[longest]
+	}
+	beatStart := w.StartBeat
+	if beatPlayhead >= 0 {
+		beatStart = math.Floor(beatPlayhead/visibleBeats) * visibleBeats
+	}
+	beatEnd := beatStart + visibleBeats
+
+	// every chain plays at its own beat, which is drawn in the repeat
+	// of the chain closest to the playhead of the longest chain
+	heads := make([]float64, len(chains))
+	for i, chain := range chains {
+		heads[i] = -1
+		if beatPlayhead >= 0 && beats[i] >= 0 && chain.BeatsTotal > 0 {
+			heads[i] = math.Round((beatPlayhead-beats[i])/chain.BeatsTotal)*chain.BeatsTotal + beats[i]
+		}
+	}
+
+	// grid with a line on every beat
+	cells := make([][]rollCell, rows)
+	for y := range cells {
+		cells[y] = make([]rollCell, cols)
+		for x := range cells[y] {
+			cells[y][x] = rollCell{' ', config.DefStyle}
+			if x%w.Zoom == 0 {
+				cells[y][x] = rollCell{'┊', commentStyle}
+			}
+		}
+	}
+
+	// notes of each chain, shorter chains are repeated
+	for i, chain := range chains {
+		if chain.BeatsTotal <= 0 {
+			continue
+		}
+		style := ChainStyle(i, chain)
+		for k := math.Floor(beatStart / chain.BeatsTotal); k*chain.BeatsTotal < beatEnd; k++ {
+			for _, step := range chain.Steps {
+				stepStart := step.BeatsStart + k*chain.BeatsTotal
+				x0 := int(math.Round((stepStart - beatStart) * float64(w.Zoom)))
+				x1 := int(math.Round((stepStart + step.BeatsDuration - beatStart) * float64(w.Zoom)))
+				if x1 <= x0 {
+					x1 = x0 + 1
+				}
+				noteStyle := style
+				if heads[i] >= stepStart && heads[i] < stepStart+step.BeatsDuration {
+					noteStyle = noteStyle.Reverse(true)
+				}
+				for _, note := range step.Notes {
+					y := noteTop - note.Midi
+					if note.IsRest || note.IsLegato || y < 0 || y >= rows {
+						continue
+					}
+					for x := x0; x < x1 && x < cols; x++ {
+						if x < 0 {
+							continue
+						}
+						r := '▒'
+						if x == x0 {
+							r = '█'
+						}
+						cells[y][x] = rollCell{r, noteStyle}
+					}
+				}
+			}
+		}
+	}
+
+	// the playheads of the other chains are drawn where there are no notes
+	for i, head := range heads {
+		x := int((head - beatStart) * float64(w.Zoom))
+		if head < 0 || i == longest || x < 0 || x >= cols {
+			continue
+		}
+		for y := range cells {
+			if cells[y][x].r == ' ' || cells[y][x].r == '┊' {
+				cells[y][x] = rollCell{'│', ChainStyle(i, chains[i])}
+			}
+		}
+	}
+
+	// playhead
+	if beatPlayhead >= 0 {
+		x := int((beatPlayhead - beatStart) * float64(w.Zoom))
+		if x >= 0 && x < cols {
+			for y := range cells {
+				if cells[y][x].r == ' ' || cells[y][x].r == '┊' {
+					cells[y][x] = rollCell{'│', statusLineStyle}
+				} else {
+					cells[y][x].style = cells[y][x].style.Reverse(true)
+				}
+			}
+		}
+	}
+
+	// beat numbers along the top
+	for x := 0; x < cols; x += w.Zoom {
+		w.drawText(rollGutter+x, 0, fmt.Sprintf("%g", beatStart+float64(x/w.Zoom)), commentStyle)
+	}
+
+	// note names along the side
+	for y := 0; y < rows; y++ {
+		name := parser.MidiName(noteTop - y)
+		style := commentStyle
+		if (noteTop-y)%12 == 0 {
+			style = config.DefStyle
+		}
+		w.drawText(0, y+1, fmt.Sprintf("%-4s", name), style)
+		for x, cell := range cells[y] {
+			screen.SetContent(w.X+rollGutter+x, w.Y+y+1, cell.r, nil, cell.style)
+		}
+	}
+}
+
+func (w *RollWindow) drawFooter(statusLineStyle tcell.Style) {
+	y := w.Height - 1
+	if y < 0 {
+		return
+	}
+	for x := 0; x < w.Width; x++ {
+		screen.SetContent(w.X+x, w.Y+y, ' ', nil, statusLineStyle)
+	}
+	x := w.drawText(0, y, " roll:", statusLineStyle)
+	if globals.TLI != nil {
+		for i, chain := range globals.TLI.Rendered() {
+			x = w.drawText(x, y, " ■", ChainStyle(i, chain))
+			x = w.drawText(x, y, " "+chain.Name, statusLineStyle)
+		}
+	}
+	w.drawText(x, y, "  [←/→] scroll [↑/↓] notes [+/-] zoom [space] play/pause [q]uit", statusLineStyle)
+}
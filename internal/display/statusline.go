@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	luar "layeh.com/gopher-luar"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/schollz/aw/internal/config"
 	"github.com/schollz/aw/internal/globals"
 	ulua "github.com/schollz/aw/internal/lua"
+	"github.com/schollz/aw/internal/parser"
 	"github.com/schollz/aw/internal/screen"
 	"github.com/schollz/aw/internal/util"
 	lua "github.com/yuin/gopher-lua"
@@ -54,6 +56,35 @@ var statusInfo = map[string]func(*buffer.Buffer) string{
 	"percentage": func(b *buffer.Buffer) string {
 		return strconv.Itoa((b.GetActiveCursor().Y + 1) * 100 / b.LinesNum())
 	},
+	"token": tokenInfo,
+}
+
+var tokenInfoKey, tokenInfoValue string
+
+// tokenInfo describes what the token under the cursor of a tli buffer
+// resolves to, which is cached since the statusline redraws constantly
+func tokenInfo(b *buffer.Buffer) string {
+	if !strings.HasSuffix(b.Path, ".tli") {
+		return ""
+	}
+	c := b.GetActiveCursor()
+	tempo := 0
+	if globals.TLI != nil {
+		tempo = globals.TLI.Tempo()
+	}
+	// the token can change with any edit of the buffer, like of a def
+	key := fmt.Sprintf("%s:%d:%d:%d:%d", b.Path, b.Edits(), c.Y, c.X, tempo)
+	if key == tokenInfoKey {
+		return tokenInfoValue
+	}
+	lines := make([]string, b.LinesNum())
+	for i := range lines {
+		lines[i] = b.Line(i)
+	}
+	line := lines[c.Y]
+	x := len(string([]rune(line)[:util.Min(c.X, utf8.RuneCountInString(line))]))
+	tokenInfoKey, tokenInfoValue = key, parser.DescribeToken(lines, c.Y, x, tempo)
+	return tokenInfoValue
 }
 
 func SetStatusInfoFnLua(fn string) {
@@ -166,7 +197,7 @@ func (s *StatusLine) Display() {
 
 	leftText := []byte(s.win.Buf.Settings["statusformatl"].(string))
 	left := ""
-	if globals.TLI.Playing {
+	if globals.TLI.IsPlaying() {
 		left = "▶"
 	} else {
 		left = "⏸"
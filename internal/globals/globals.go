@@ -1,24 +1,218 @@
 package globals
 
 import (
-	"os"
+	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/schollz/aw/internal/parser"
 	log "github.com/schollz/logger"
 )
 
+// TLI is the sequencer of the active buffer
 var TLI *parser.TLI
 
+// Sequencer plays an entry file and every file it includes
+type Sequencer struct {
+	TLI *parser.TLI
+	// Entry is the file that is playing, it may include other files
+	Entry string
+	// Files are the entry and every file it includes
+	Files []string
+	// lines are the lines of the text that was loaded and origins are
+	// the lines of the files they came from
+	lines   []string
+	origins []parser.Origin
+}
+
+// ChainAt returns the name of the chain that a line of one of the files
+// of the sequencer is in, it is not ok if the line was not loaded
+func (s *Sequencer) ChainAt(filename string, y int) (name string, ok bool) {
+	for i, origin := range s.origins {
+		if origin.File == filename && origin.Line == y {
+			return parser.ChainAt(s.lines[:i+1]), true
+		}
+	}
+	return
+}
+
+// Sequencers are the sequencer of every entry that has been saved, they all
+// share the same clock and devices so they can play layered on each other
+var Sequencers = make(map[string]*Sequencer)
+
+// SequencerFor returns the sequencer that plays the file, or nil
+// if the file is not part of any sequencer
+func SequencerFor(filename string) *Sequencer {
+	if filename == "" {
+		return nil
+	}
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+	if s, ok := Sequencers[filename]; ok {
+		return s
+	}
+	for _, s := range Sequencers {
+		for _, f := range s.Files {
+			if f == filename {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// Activate makes the sequencer of the file the one that commands
+// and panes control
+func Activate(filename string) {
+	if s := SequencerFor(filename); s != nil {
+		TLI = s.TLI
+	}
+}
+
+// ProcessFilename reloads the sequencer of a file after it is saved. If the file
+// is included by an entry that is playing, or there is a project file next to it,
+// then the entry is reloaded instead. Each entry has its own sequencer. Files
+// that are not TLI files and are not included by an entry are left alone.
 func ProcessFilename(filename string) (err error) {
-	b, err := os.ReadFile(filename)
+	filename, err = filepath.Abs(filename)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	entry := filename
+	project, err := parser.FindProject(filename)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if project != nil && project.Entry != "" {
+		entry = project.Entry
+	}
+	if s := SequencerFor(filename); s != nil {
+		entry = s.Entry
+	} else if filepath.Ext(filename) != ".tli" && (project == nil || filename != project.Filename) && !includes(entry, filename) {
+		return
+	}
+	if project != nil && entry == project.Filename {
+		// the project file was saved without an entry, so reload
+		// every entry next to it with its devices
+		for _, s := range Sequencers {
+			if filepath.Dir(s.Entry) == filepath.Dir(project.Filename) {
+				if errReload := ProcessFilename(s.Entry); errReload != nil {
+					err = errReload
+				}
+			}
+		}
+		return
+	}
+
+	text, origins, files, err := parser.LoadOrigins(entry)
 	if err != nil {
 		log.Error(err)
 		return
 	}
-	text := string(b)
-	err = TLI.Update(text)
-	if !TLI.Playing {
-		TLI.Play()
+	s, ok := Sequencers[entry]
+	if !ok {
+		tli, errNew := parser.New(``)
+		if errNew != nil {
+			err = errNew
+			log.Error(err)
+			return
+		}
+		s = &Sequencer{TLI: tli, Entry: entry}
+		Sequencers[entry] = s
+	}
+	if project != nil {
+		s.TLI.SetDevices(project.Devices)
+	}
+	s.Files = files
+	s.lines = strings.Split(text, "\n")
+	s.origins = origins
+	TLI = s.TLI
+	err = s.TLI.Update(text)
+	if !s.TLI.IsPlaying() {
+		s.TLI.Play()
+	}
+	return
+}
+
+// includes returns whether an entry includes another file
+func includes(entry string, filename string) bool {
+	if entry == filename {
+		return false
+	}
+	_, files, err := parser.Load(entry)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if f == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// Eval merges a block or a selection of a file into the sequencer that
+// plays it, without saving the file. It returns the names of the blocks.
+func Eval(filename string, part string) (names []string, err error) {
+	s := SequencerFor(filename)
+	if s == nil {
+		err = fmt.Errorf("%s is not playing, save it first", filepath.Base(filename))
+		return
+	}
+	TLI = s.TLI
+	names, err = s.TLI.Eval(part)
+	if err == nil && !s.TLI.IsPlaying() {
+		s.TLI.Play()
 	}
 	return
 }
+
+// Release stops and closes the sequencer of a file that was closed, unless
+// one of the files that are still open is part of it
+func Release(filename string, open []string) {
+	s := SequencerFor(filename)
+	if s == nil {
+		return
+	}
+	for _, o := range open {
+		if SequencerFor(o) == s {
+			return
+		}
+	}
+	s.TLI.Close()
+	delete(Sequencers, s.Entry)
+	if TLI == s.TLI {
+		TLI, _ = parser.New(``)
+	}
+}
+
+// Scene plays the sequencer of the file and stops every other sequencer
+func Scene(filename string) (err error) {
+	s := SequencerFor(filename)
+	if s == nil {
+		return ProcessFilename(filename)
+	}
+	for _, other := range Sequencers {
+		if other != s {
+			other.TLI.Stop()
+		}
+	}
+	TLI = s.TLI
+	if !s.TLI.IsPlaying() {
+		s.TLI.Play()
+	}
+	return
+}
+
+// CloseAll stops every sequencer and lets go of the notes that are
+// sounding and the devices, for when the editor exits
+func CloseAll() {
+	for entry, s := range Sequencers {
+		s.TLI.Close()
+		delete(Sequencers, entry)
+	}
+}
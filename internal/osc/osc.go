@@ -0,0 +1,62 @@
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+)
+
+// Client sends open sound control messages over udp to one address
+type Client struct {
+	conn net.Conn
+}
+
+// Dial returns a client that sends to an address like "localhost:57120"
+func Dial(address string) (c *Client, err error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return
+	}
+	c = &Client{conn: conn}
+	return
+}
+
+// Send sends a message with float arguments to a path like "/cutoff"
+func (c *Client) Send(path string, args ...float32) (err error) {
+	message, err := Message(path, args...)
+	if err != nil {
+		return
+	}
+	_, err = c.conn.Write(message)
+	return
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Message encodes a message with float arguments, strings are padded
+// with zeros to a multiple of 4 bytes and floats are big endian
+func Message(path string, args ...float32) (message []byte, err error) {
+	if !strings.HasPrefix(path, "/") {
+		err = fmt.Errorf("bad osc path '%s', should start with '/'", path)
+		return
+	}
+	var buf bytes.Buffer
+	writeString(&buf, path)
+	writeString(&buf, ","+strings.Repeat("f", len(args)))
+	for _, arg := range args {
+		binary.Write(&buf, binary.BigEndian, math.Float32bits(arg))
+	}
+	message = buf.Bytes()
+	return
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.Write(make([]byte, 4-len(s)%4))
+}
@@ -0,0 +1,43 @@
+package osc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage(t *testing.T) {
+	message, err := Message("/cutoff", 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{
+		'/', 'c', 'u', 't', 'o', 'f', 'f', 0,
+		',', 'f', 0, 0,
+		0x3f, 0, 0, 0,
+	}, message)
+
+	message, err = Message("/abc")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'/', 'a', 'b', 'c', 0, 0, 0, 0, ',', 0, 0, 0}, message)
+
+	_, err = Message("cutoff", 1)
+	assert.NotNil(t, err)
+}
+
+func TestSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	c, err := Dial(conn.LocalAddr().String())
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Nil(t, c.Send("/cutoff", 0.5))
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(t, err)
+	want, _ := Message("/cutoff", 0.5)
+	assert.Equal(t, want, buf[:n])
+}
@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ArpModes are the orders that an arpeggio plays the notes of a chord in
+var ArpModes = []string{"up", "down", "updown", "converge", "diverge", "random", "played"}
+
+// DefaultArpRate is how many beats each note of an arpeggio lasts
+// when the rate is not given, a 16th note
+const DefaultArpRate = 0.25
+
+// Arp is an arpeggio of the notes of a step, which plays one note at a time
+// at its own rate for as long as the step and the holds after it last
+type Arp struct {
+	Mode string `json:"mode"`
+	// Rate is the length of each note in beats
+	Rate    float64 `json:"rate"`
+	Octaves int     `json:"octaves"`
+	// Latch keeps the arpeggio going through rests and carries on from
+	// where it was on the next step instead of starting over
+	Latch bool `json:"latch,omitempty"`
+}
+
+// ParseArp parses an arp decorator like "arp" or "arp(updown,8th,oct2,latch)",
+// the mode, rate, octaves and latch can be given in any order
+func ParseArp(decorator string) (arp *Arp, err error) {
+	arp = &Arp{Mode: "up", Rate: DefaultArpRate, Octaves: 1}
+	if decorator == "arp" {
+		return
+	}
+	if !strings.HasPrefix(decorator, "arp(") || !strings.HasSuffix(decorator, ")") {
+		err = fmt.Errorf("bad arp '%s'", decorator)
+		return
+	}
+	for _, option := range strings.Split(decorator[4:len(decorator)-1], ",") {
+		option = strings.TrimSpace(option)
+		switch {
+		case option == "":
+		case option == "latch":
+			arp.Latch = true
+		case isArpMode(option):
+			arp.Mode = option
+		case strings.HasPrefix(option, "oct"):
+			arp.Octaves, err = strconv.Atoi(option[3:])
+			if err != nil || arp.Octaves < 1 {
+				err = fmt.Errorf("bad arp octaves '%s'", option)
+				return
+			}
+		default:
+			arp.Rate, err = parseRate(option)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func isArpMode(s string) bool {
+	for _, mode := range ArpModes {
+		if s == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRate returns the beats of a note length like "8th", "16th",
+// "32nd" or "8t" for an eighth note triplet
+func parseRate(s string) (beats float64, err error) {
+	triplet := strings.HasSuffix(s, "t") && !strings.HasSuffix(s, "th")
+	number := strings.TrimRight(s, "thsndr")
+	division, errAtoi := strconv.Atoi(number)
+	if errAtoi != nil || division <= 0 {
+		err = fmt.Errorf("bad arp rate '%s', should be like '16th'", s)
+		return
+	}
+	beats = 4 / float64(division)
+	if triplet {
+		beats = beats * 2 / 3
+	}
+	return
+}
+
+// notes returns the notes of a chord over the octaves of the arpeggio, lowest
+// first unless the arpeggio plays them in the order they were written
+func (a *Arp) notes(chord []Note) (notes []Note) {
+	played := playable(chord)
+	if a.Mode != "played" {
+		played = append([]Note{}, played...)
+		sort.SliceStable(played, func(i, j int) bool { return played[i].Midi < played[j].Midi })
+	}
+	for octave := 0; octave < a.Octaves; octave++ {
+		for _, note := range played {
+			if midi := note.Midi + 12*octave; midi <= 127 {
+				notes = append(notes, Note{Midi: midi, Name: MidiName(midi)})
+			}
+		}
+	}
+	return
+}
+
+// order returns the indices of the notes that one cycle of the arpeggio plays
+func (a *Arp) order(n int) (order []int) {
+	switch a.Mode {
+	case "down":
+		for i := n - 1; i >= 0; i-- {
+			order = append(order, i)
+		}
+	case "updown":
+		for i := 0; i < n; i++ {
+			order = append(order, i)
+		}
+		for i := n - 2; i > 0; i-- {
+			order = append(order, i)
+		}
+	case "converge", "diverge":
+		for i, j := 0, n-1; i <= j; i, j = i+1, j-1 {
+			order = append(order, i)
+			if i != j {
+				order = append(order, j)
+			}
+		}
+		if a.Mode == "diverge" {
+			for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+				order[i], order[j] = order[j], order[i]
+			}
+		}
+	default:
+		for i := 0; i < n; i++ {
+			order = append(order, i)
+		}
+	}
+	return
+}
+
+// arpeggiate replaces each step with an arpeggio by a step for every note
+// the arpeggio plays. It is done after the length of each step is known,
+// so arpeggios keep going through holds.
+func (c *Chain) arpeggiate() {
+	// random arpeggios are the same every time the chain is rendered
+	h := fnv.New64a()
+	h.Write([]byte(c.Name))
+	random := rand.New(rand.NewSource(int64(h.Sum64())))
+	position := 0
+	steps := []Step{}
+	for _, step := range c.Steps {
+		if step.Arp == nil || step.BeatsDuration <= 0 {
+			steps = append(steps, step)
+			continue
+		}
+		notes := step.Arp.notes(step.Notes)
+		if len(notes) == 0 {
+			steps = append(steps, step)
+			continue
+		}
+		if !step.Arp.Latch {
+			position = 0
+		}
+		order := step.Arp.order(len(notes))
+		last := -1
+		for beat := 0.0; beat < step.BeatsDuration-1e-9; beat += step.Arp.Rate {
+			index := order[position%len(order)]
+			if step.Arp.Mode == "random" {
+				index = random.Intn(len(notes))
+				if index == last && len(notes) > 1 {
+					index = (index + 1 + random.Intn(len(notes)-1)) % len(notes)
+				}
+			}
+			last = index
+			position++
+			length := math.Min(step.Arp.Rate, step.BeatsDuration-beat)
+			s := step
+			s.Notes = []Note{notes[index]}
+			s.BeatsStart = step.BeatsStart + beat
+			s.BeatsDuration = length
+			s.TimeStartMicroseconds, s.TimeDurationMicroseconds = c.span(s.BeatsStart, length)
+			if beat > 0 {
+				s.Glide = 0
+			}
+			steps = append(steps, s)
+		}
+	}
+	c.Steps = steps
+}
@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseArp(t *testing.T) {
+	for _, test := range []struct {
+		decorator string
+		want      Arp
+		err       bool
+	}{
+		{"arp", Arp{Mode: "up", Rate: 0.25, Octaves: 1}, false},
+		{"arp(8th)", Arp{Mode: "up", Rate: 0.5, Octaves: 1}, false},
+		{"arp(updown,32nd,oct2,latch)", Arp{Mode: "updown", Rate: 0.125, Octaves: 2, Latch: true}, false},
+		{"arp(oct3,random)", Arp{Mode: "random", Rate: 0.25, Octaves: 3}, false},
+		{"arp(8t)", Arp{Mode: "up", Rate: 1.0 / 3, Octaves: 1}, false},
+		{"arp(sideways)", Arp{}, true},
+		{"arp(oct0)", Arp{}, true},
+	} {
+		arp, err := ParseArp(test.decorator)
+		if test.err {
+			assert.NotNil(t, err, test.decorator)
+			continue
+		}
+		assert.Nil(t, err, test.decorator)
+		assert.InDelta(t, test.want.Rate, arp.Rate, 1e-9, test.decorator)
+		arp.Rate = test.want.Rate
+		assert.Equal(t, test.want, *arp, test.decorator)
+	}
+}
+
+func TestArpOrder(t *testing.T) {
+	for _, test := range []struct {
+		mode string
+		want []int
+	}{
+		{"up", []int{0, 1, 2, 3}},
+		{"down", []int{3, 2, 1, 0}},
+		{"updown", []int{0, 1, 2, 3, 2, 1}},
+		{"converge", []int{0, 3, 1, 2}},
+		{"diverge", []int{2, 1, 3, 0}},
+		{"played", []int{0, 1, 2, 3}},
+	} {
+		arp := Arp{Mode: test.mode}
+		assert.Equal(t, test.want, arp.order(4), test.mode)
+	}
+}
+
+func TestArpeggiate(t *testing.T) {
+	log.SetLevel("info")
+	notes := func(line string) (names []string) {
+		tli, err := NewOffline("run a\n" + line)
+		assert.Nil(t, err)
+		for _, step := range tli.ChainsRendered[0].Steps {
+			for _, note := range step.Notes {
+				names = append(names, note.Name)
+			}
+		}
+		return
+	}
+	for _, test := range []struct {
+		line string
+		want []string
+	}{
+		// the rate does not depend on how many tokens are in the line
+		{"Cmaj7;4(arp(8th)) f4", []string{"c4", "e4", "g4", "b4", "f4"}},
+		// arpeggios keep going through holds and stop at rests
+		{"Cmaj7;4(arp(8th)) _ ~ f4", []string{"c4", "e4", "g4", "b4", "f4"}},
+		{"C;4(arp(8th)) _ _ f4", []string{"c4", "e4", "g4", "c4", "e4", "g4", "f4"}},
+		// latched arpeggios keep going through rests
+		{"Cmaj7;4(arp(8th,latch)) _ ~ f4", []string{"c4", "e4", "g4", "b4", "c4", "e4", "f4"}},
+		// and carry on from where they were on the next step
+		{"C;4(arp(latch)) C;4(arp(latch)) f4 g4", []string{"c4", "e4", "g4", "c4", "e4", "g4", "c4", "e4", "f4", "g4"}},
+		{"C;4(arp) C;4(arp) f4 g4", []string{"c4", "e4", "g4", "c4", "c4", "e4", "g4", "c4", "f4", "g4"}},
+		{"C;4(arp(4th,down,oct2))", []string{"g5", "e5", "c5", "g4"}},
+		{"e4c4g4(arp(4th,played))", []string{"e4", "c4", "g4", "e4"}},
+	} {
+		assert.Equal(t, test.want, notes(test.line), test.line)
+	}
+
+	// each note of an arpeggio is a step of its own
+	tli, err := NewOffline("run a\nC;4(arp(8th)) _ ~ ~")
+	assert.Nil(t, err)
+	steps := tli.ChainsRendered[0].Steps
+	assert.Equal(t, 4, len(steps))
+	assert.Equal(t, 1.5, steps[3].BeatsStart)
+	assert.Equal(t, 0.5, steps[3].BeatsDuration)
+	assert.Equal(t, int64(750000), steps[3].TimeStartMicroseconds)
+	assert.Equal(t, int64(250000), steps[3].TimeDurationMicroseconds)
+
+	// random arpeggios are the same every time
+	assert.Equal(t, notes("Cmaj7;4(arp(random)) _"), notes("Cmaj7;4(arp(random)) _"))
+}
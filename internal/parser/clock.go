@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"sync"
+	"time"
+
+	"github.com/loov/hrtime"
+)
+
+// tickInterval is how often the sequencer looks for steps to play
+const tickInterval = 10 * time.Microsecond
+
+// Clock is what a sequencer keeps time with, times are durations since
+// some moment in the past that never changes
+type Clock interface {
+	// Now returns the time
+	Now() time.Duration
+	// NewTicker returns a channel that gets the time every period, a
+	// function to call once each tick is handled and a function that stops it
+	NewTicker(period time.Duration) (ticks <-chan time.Duration, handled func(), stop func())
+}
+
+// SystemClock is the high resolution clock of the computer
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Duration {
+	return hrtime.Now()
+}
+
+func (systemClock) NewTicker(period time.Duration) (<-chan time.Duration, func(), func()) {
+	ticker := time.NewTicker(period)
+	ticks := make(chan time.Duration, 1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				// ticks are dropped when the sequencer is behind, like a ticker
+				select {
+				case ticks <- hrtime.Now():
+				default:
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return ticks, func() {}, func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// ManualClock is a clock that only moves when it is told to, so that
+// tests can play a sequencer without waiting
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Duration
+	tickers []*manualTicker
+}
+
+type manualTicker struct {
+	period time.Duration
+	next   time.Duration
+	ticks  chan time.Duration
+	// handled gets a value once a tick was handled
+	handled chan struct{}
+	done    chan struct{}
+}
+
+// NewManualClock returns a clock that starts at 0
+func NewManualClock() *ManualClock {
+	return &ManualClock{}
+}
+
+func (c *ManualClock) Now() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) NewTicker(period time.Duration) (<-chan time.Duration, func(), func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTicker{
+		period:  period,
+		next:    c.now + period,
+		ticks:   make(chan time.Duration),
+		handled: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	c.tickers = append(c.tickers, t)
+	handled := func() {
+		select {
+		case t.handled <- struct{}{}:
+		case <-t.done:
+		}
+	}
+	return t.ticks, handled, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, other := range c.tickers {
+			if other == t {
+				c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+				close(t.done)
+				break
+			}
+		}
+	}
+}
+
+// Tickers returns how many tickers are running
+func (c *ManualClock) Tickers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tickers)
+}
+
+// Advance moves the clock forward and sends every tick that is due on the
+// way, in order. It waits for each tick to be handled, so whatever a tick
+// does is done by the time the clock moves on and by the time Advance returns.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now + d
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		var due *manualTicker
+		for _, t := range c.tickers {
+			if t.next <= end && (due == nil || t.next < due.next) {
+				due = t
+			}
+		}
+		if due == nil {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		c.now = due.next
+		due.next += due.period
+		now := c.now
+		c.mu.Unlock()
+		select {
+		case due.ticks <- now:
+		case <-due.done:
+			continue
+		}
+		select {
+		case <-due.handled:
+		case <-due.done:
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// timedMidi records the notes played on it at the time of a clock
+type timedMidi struct {
+	fakeMidi
+	clock  Clock
+	events []string
+}
+
+func (f *timedMidi) NoteOn(channel, note, velocity uint8) error {
+	f.events = append(f.events, fmt.Sprintf("%v on %s", f.clock.Now(), MidiName(int(note))))
+	return f.fakeMidi.NoteOn(channel, note, velocity)
+}
+
+func (f *timedMidi) NoteOff(channel, note uint8) error {
+	f.events = append(f.events, fmt.Sprintf("%v off %s", f.clock.Now(), MidiName(int(note))))
+	return f.fakeMidi.NoteOff(channel, note)
+}
+
+// waitPlaying waits for the transport to start playing, which is when
+// the sequencer is ready for the clock to move
+func waitPlaying(tli *TLI) {
+	for tli.State() != TransportPlaying {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// playFor plays a text on a manual clock for a while and returns
+// the notes that were played and let go of
+func playFor(t *testing.T, text string, d time.Duration) []string {
+	clock := NewManualClock()
+	device := &timedMidi{clock: clock}
+	dm := NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		return device, nil
+	}
+	tli, err := newWithDevices(text, nil, dm)
+	assert.Nil(t, err)
+	tli.SetClock(clock)
+	tli.Play()
+	waitPlaying(tli)
+	clock.Advance(d)
+	tli.Stop()
+	return device.events
+}
+
+func TestClock(t *testing.T) {
+	log.SetLevel("info")
+	for _, test := range []struct {
+		name string
+		text string
+		d    time.Duration
+		want []string
+	}{
+		{"gate", "run a\nc4 d e f\n\ntie a\nout midi(synth)", 2 * time.Second, []string{
+			"10µs on c4", "475.01ms off c4",
+			"500ms on d4", "975ms off d4",
+			"1s on e4", "1.475s off e4",
+			"1.5s on f4", "1.975s off f4",
+			"2s on c4", "2s off c4",
+		}},
+		// the rest is silent and stopping lets go of the note that is playing
+		{"legato", "run a\nc4 _ e ~\n\ntie a\nout midi(synth)", 2 * time.Second, []string{
+			"10µs on c4", "950.01ms off c4",
+			"1s on e4", "1.475s off e4",
+			"2s on c4", "2s off c4",
+		}},
+		{"gate percent", "run a\nc4(h50) d e(h100) f\n\ntie a\nout midi(synth)", 2 * time.Second, []string{
+			"10µs on c4", "250.01ms off c4",
+			"500ms on d4", "750ms off d4",
+			// a note with a gate of 100 is let go of before the next step plays
+			"1s on e4", "1.5s off e4",
+			"1.5s on f4", "2s off f4",
+			"2s on c4", "2s off c4",
+		}},
+		// the tempo changes from the step that sets it on
+		{"tempo", "run a\nc4 d(t240) e f\n\ntie a\nout midi(synth)", 2 * time.Second, []string{
+			"10µs on c4", "475.01ms off c4",
+			"500ms on d4", "737.5ms off d4",
+			"750ms on e4", "987.5ms off e4",
+			"1s on f4", "1.2375s off f4",
+			"1.25s on c4", "1.725s off c4",
+			"1.75s on d4", "1.9875s off d4",
+			"2s on e4", "2s off e4",
+		}},
+	} {
+		events := playFor(t, test.text, test.d)
+		assert.Equal(t, test.want, events, test.name)
+	}
+}
+
+func TestManualClockHandled(t *testing.T) {
+	clock := NewManualClock()
+	ticks, handled, stop := clock.NewTicker(time.Millisecond)
+	var seen []time.Duration
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for now := range ticks {
+			// a slow tick is still handled before the clock moves on
+			time.Sleep(time.Millisecond)
+			seen = append(seen, now)
+			handled()
+			if len(seen) == 3 {
+				return
+			}
+		}
+	}()
+	clock.Advance(3 * time.Millisecond)
+	assert.Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}, seen)
+	<-done
+	stop()
+	// a stopped ticker does not hold up the clock
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, 0, clock.Tickers())
+}
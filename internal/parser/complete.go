@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Decorators are examples of every decorator that can follow a note
+// or chord in parentheses, used for autocompletion
+var Decorators = []string{
+	"t120",     // tempo
+	"t120>140", // tempo ramp
+	"h50",      // gate in percent
+	"v80",      // velocity
+	"b8",       // beats in the line
+	"ru4d4",    // arpeggio
+	"arp(",     // arpeggio mode, rate, octaves and latch
+	"adsr(",    // envelope
+	"drop2",    // voicings
+	"drop3",
+	"open",
+	"spread",
+	"omit5",
+	"oct+1",
+}
+
+var chordRootRegex = regexp.MustCompile(`^([A-G][#b]?)(.*)$`)
+
+// ChordSuffixes returns every name of a chord type, like "m7" or "maj9"
+func ChordSuffixes() (suffixes []string) {
+	seen := make(map[string]bool)
+	for _, chord := range dbChords {
+		for _, names := range chord[2:] {
+			for _, name := range strings.Fields(names) {
+				if !seen[name] {
+					seen[name] = true
+					suffixes = append(suffixes, name)
+				}
+			}
+		}
+	}
+	return
+}
+
+// Complete returns autocompletions for the text before the cursor in line y of
+// a TLI file: chord types after a root note, decorators after "(", loop names
+// in tie lines and midi devices in "out midi(". The completions are what gets
+// inserted in place of the last replace characters before the cursor, which
+// were typed in any case, and the suggestions are what is shown, which for
+// chords includes the notes of the chord.
+func Complete(lines []string, y int, x int, devices []string) (completions []string, suggestions []string, replace int) {
+	if y < 0 || y >= len(lines) {
+		return
+	}
+	line := []rune(lines[y])
+	if x > len(line) {
+		x = len(line)
+	}
+	before := strings.TrimLeft(string(line[:x]), " \t")
+
+	add := func(input string, suggestion string, completion string) {
+		if strings.HasPrefix(strings.ToLower(completion), strings.ToLower(input)) && len(completion) > len(input) {
+			completions = append(completions, completion)
+			suggestions = append(suggestions, suggestion)
+			replace = utf8.RuneCountInString(input)
+		}
+	}
+
+	// midi devices
+	if strings.HasPrefix(before, "out") {
+		i := strings.LastIndex(before, "midi(")
+		if i >= 0 && !strings.ContainsAny(before[i:], ",)") {
+			input := before[i+len("midi("):]
+			for _, device := range devices {
+				add(input, device, device)
+			}
+		}
+		return
+	}
+
+	// loop names
+	if strings.HasPrefix(before, "tie ") {
+		input := before[strings.LastIndexAny(before, " ()*+")+1:]
+		for _, l := range lines {
+			fields := strings.Fields(l)
+			if len(fields) > 1 && fields[0] == "run" {
+				// the name of a loop comes without its arguments
+				fn, err := ParseFunction(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "run")))
+				if err == nil && fn.Name != "" {
+					add(input, fn.Name, fn.Name)
+				}
+			}
+		}
+		return
+	}
+
+	// the token being typed
+	token := before[strings.LastIndexAny(before, " []")+1:]
+
+	// decorators
+	if i := strings.LastIndex(token, "("); i >= 0 && strings.Count(token, "(") > strings.Count(token, ")") {
+		input := token[i+1:]
+		input = input[strings.LastIndex(input, ",")+1:]
+		for _, decorator := range Decorators {
+			add(input, decorator, decorator)
+		}
+		return
+	}
+
+	// chord types
+	match := chordRootRegex.FindStringSubmatch(token)
+	if match == nil {
+		return
+	}
+	suffixes := ChordSuffixes()
+	sort.Strings(suffixes)
+	for _, suffix := range suffixes {
+		if !strings.HasPrefix(suffix, match[2]) || suffix == match[2] {
+			continue
+		}
+		notes, err := ParseChord(match[1]+suffix, 60)
+		if err != nil {
+			continue
+		}
+		names := []string{}
+		for _, note := range notes {
+			names = append(names, note.Name)
+		}
+		completions = append(completions, suffix)
+		suggestions = append(suggestions, match[1]+suffix+"("+strings.Join(names, ",")+")")
+		replace = utf8.RuneCountInString(match[2])
+	}
+	return
+}
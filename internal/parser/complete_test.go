@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	lines := []string{
+		"run intro",
+		"Am7 c4(",
+		"Cmaj",
+		"run verse",
+		"e4(t120,v",
+		"tie intro v",
+		"out midi(um",
+		"out midi(synth,",
+	}
+	devices := []string{"synth", "UM-ONE MIDI 1", "OP-1"}
+
+	completions, suggestions, replace := Complete(lines, 1, 7, devices)
+	assert.Equal(t, len(Decorators), len(completions))
+	assert.Equal(t, Decorators, suggestions)
+	assert.Equal(t, 0, replace)
+
+	completions, suggestions, replace = Complete(lines, 4, 9, devices)
+	assert.Equal(t, []string{"v80"}, completions)
+	assert.Equal(t, []string{"v80"}, suggestions)
+	assert.Equal(t, 1, replace)
+
+	completions, suggestions, replace = Complete(lines, 2, 4, devices)
+	assert.Contains(t, completions, "maj7")
+	assert.Contains(t, suggestions, "Cmaj7(c4,e4,g4,b4)")
+	assert.Equal(t, 3, replace)
+
+	completions, suggestions, replace = Complete(lines, 5, 11, devices)
+	assert.Equal(t, []string{"verse"}, completions)
+	assert.Equal(t, []string{"verse"}, suggestions)
+	assert.Equal(t, 1, replace)
+
+	// what was typed is replaced, so the device keeps its case
+	completions, suggestions, replace = Complete(lines, 6, 11, devices)
+	assert.Equal(t, []string{"UM-ONE MIDI 1"}, completions)
+	assert.Equal(t, []string{"UM-ONE MIDI 1"}, suggestions)
+	assert.Equal(t, 2, replace)
+
+	completions, _, _ = Complete(lines, 7, 15, devices)
+	assert.Empty(t, completions)
+
+	// loops with arguments complete with their names
+	lines = []string{"run drums(map=gm)", "bd sd", "tie d"}
+	completions, suggestions, replace = Complete(lines, 2, 5, devices)
+	assert.Equal(t, []string{"drums"}, completions)
+	assert.Equal(t, []string{"drums"}, suggestions)
+	assert.Equal(t, 1, replace)
+}
+
+func TestChordSuffixes(t *testing.T) {
+	suffixes := ChordSuffixes()
+	assert.Contains(t, suffixes, "m7")
+	assert.Contains(t, suffixes, "maj7")
+	assert.NotContains(t, suffixes, "")
+}
@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenAt returns the token in the line that contains the column x,
+// along with where it starts
+func tokenAt(line string, x int) (token string, start int) {
+	parens := 0
+	start = -1
+	for i, r := range line + " " {
+		switch {
+		case r == '(':
+			parens++
+		case r == ')':
+			parens--
+		case parens <= 0 && (r == ' ' || r == '[' || r == ']'):
+			if start >= 0 && x >= start && x <= i {
+				token = line[start:i]
+				return
+			}
+			start = -1
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	return "", -1
+}
+
+// DescribeToken explains what the token under the cursor at column x of line y
+// resolves to, for example "Am;3 → a3 c4 e4 (57 60 64) · 2 beats · 1000ms"
+func DescribeToken(lines []string, y int, x int, tempo int) (description string) {
+	if y < 0 || y >= len(lines) {
+		return
+	}
+	line := strings.Split(lines[y], "//")[0]
+	token, start := tokenAt(line, x)
+	if token == "" || token == HOLD || token == "~" {
+		return
+	}
+
+	// find the start of the loop
+	blockStart := -1
+	for i := y; i >= 0; i-- {
+		l := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(l, "run ") {
+			blockStart = i
+			break
+		} else if i < y && blockStartRegex.MatchString(l) {
+			return
+		}
+	}
+	if blockStart < 0 {
+		return
+	}
+
+	defs := make(map[string]string)
+	for _, l := range lines {
+		if name, phrase, err := ParseDef(l); err == nil && strings.HasPrefix(strings.TrimSpace(l), "def ") {
+			defs[name] = phrase
+		}
+	}
+
+	// play the loop up to the token to find which octave it is near,
+	// without calling into lua every time the cursor moves
+	loop := LoopNew()
+	loop.defs = defs
+	loop.skipGenerators = true
+	for i := blockStart + 1; i < y; i++ {
+		l := strings.TrimSpace(strings.Split(lines[i], "//")[0])
+		if l != "" {
+			loop.AddLine(l)
+		}
+	}
+	before := strings.NewReplacer("[", " ", "]", " ").Replace(line[:start])
+	if strings.TrimSpace(before) != "" {
+		loop.AddLine(before)
+	}
+
+	fn, _ := ParseFunction(token)
+	fn.Name, _, _ = splitDuration(fn.Name)
+	notes, err := ParseChord(fn.Name, loop.lastMidiNote)
+	if err != nil {
+		notes, err = ParseMidi(fn.Name, loop.lastMidiNote)
+	}
+	if err != nil {
+		return
+	}
+	names := []string{}
+	midis := []string{}
+	for _, note := range notes {
+		names = append(names, note.Name)
+		midis = append(midis, fmt.Sprint(note.Midi))
+	}
+	description = fmt.Sprintf("%s → %s (%s)", fn.Name, strings.Join(names, " "), strings.Join(midis, " "))
+
+	// show how an arpeggio expands
+	for _, arg := range fn.Args {
+		if strings.HasPrefix(arg.Value, "r") {
+			arp, errArp := RetokenizeArpeggioArgument([]string{token})
+			if errArp == nil && len(arp) > 0 {
+				description += fmt.Sprintf(" · %s → %s", arg.Value, strings.TrimSpace(arp[0]))
+			}
+		}
+	}
+
+	// find the step for the token on the whole line
+	loop.Steps = nil
+	loop.AddLine(strings.TrimSpace(line))
+	occurrence := 0
+	for _, t := range strings.Fields(strings.NewReplacer("[", " ", "]", " ").Replace(line[:start])) {
+		if t == token {
+			occurrence++
+		}
+	}
+	for i, step := range loop.Steps {
+		if step.Token != token {
+			continue
+		}
+		if occurrence > 0 {
+			occurrence--
+			continue
+		}
+		beats := step.beats()
+		for _, next := range loop.Steps[i+1:] {
+			if len(next.Notes) == 0 || !next.Notes[0].IsLegato {
+				break
+			}
+			beats += next.beats()
+		}
+		if step.Params.CheckSet(TempoSet) {
+			tempo = step.Params.Tempo
+		}
+		description += fmt.Sprintf(" · %.3g beats", beats)
+		if tempo > 0 {
+			description += fmt.Sprintf(" · %dms", int(beats*60000/float64(tempo)))
+		}
+		break
+	}
+	return
+}
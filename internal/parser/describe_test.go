@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeToken(t *testing.T) {
+	lines := []string{
+		"run intro",
+		"c4 e g",
+		"Am;3 _ c ~",
+		"c(ru4d4)",
+		"g:q. a",
+		"set",
+		"bpm 120",
+	}
+	assert.Equal(t, "Am;3 → a3 c4 e4 (57 60 64) · 2 beats · 1000ms", DescribeToken(lines, 2, 1, 120))
+	assert.Contains(t, DescribeToken(lines, 3, 1, 120), "ru4d4 →")
+	assert.Equal(t, "e → e4 (64) · 1.33 beats · 666ms", DescribeToken(lines, 1, 3, 120))
+	assert.Equal(t, "", DescribeToken(lines, 2, 5, 120))
+	assert.Contains(t, DescribeToken(lines, 4, 1, 120), "g → g")
+	assert.Contains(t, DescribeToken(lines, 4, 1, 120), "1.5 beats · 750ms")
+	assert.Equal(t, "", DescribeToken(lines, 6, 1, 120))
+	assert.Equal(t, "", DescribeToken(lines, 0, 1, 120))
+}
+
+func TestDescribeTokenGenerators(t *testing.T) {
+	defer func() {
+		RunGenerator = nil
+	}()
+	calls := 0
+	RunGenerator = func(script string, fn string, args []string) (phrase string, err error) {
+		calls++
+		return "c5", nil
+	}
+	lines := []string{
+		"run a",
+		"lua(up) e4",
+		"c lua(up,cycle=true) g",
+	}
+	// the generators are not run but still take their share of the line
+	assert.Equal(t, "g → g3 (55) · 1.33 beats · 666ms", DescribeToken(lines, 2, 21, 120))
+	assert.Equal(t, "e4 → e4 (64) · 2 beats · 1000ms", DescribeToken(lines, 1, 9, 120))
+	assert.Equal(t, 0, calls)
+}
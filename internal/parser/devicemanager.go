@@ -0,0 +1,528 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/schollz/aw/internal/crow"
+	"github.com/schollz/aw/internal/osc"
+	"github.com/schollz/aw/internal/util"
+	"github.com/schollz/gomidi"
+	log "github.com/schollz/logger"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// midiOut is an open midi output
+type midiOut interface {
+	NoteOn(channel, note, velocity uint8) error
+	NoteOff(channel, note uint8) error
+	PitchBend(channel uint8, value int16) error
+	ControlChange(channel, controller, value uint8) error
+	Pressure(channel, value uint8) error
+	Close() error
+}
+
+// DeviceManager opens the midi devices and crows used by the sequencers. Every
+// sequencer shares the same devices, each device is opened by the first
+// sequencer that uses it and closed when the last one lets go of it.
+type DeviceManager struct {
+	mu    sync.Mutex
+	midi  map[string]midiOut
+	mpe   map[string]*mpeZone
+	crows crow.Murder
+	osc   map[string]*osc.Client
+	refs  map[string]int
+	// offline devices are never opened
+	offline bool
+
+	// openMidi opens the midi output with the given name
+	openMidi func(name string) (midiOut, error)
+}
+
+// SharedDevices are the devices shared by every sequencer
+var SharedDevices = NewDeviceManager()
+
+// offlineDevices are used when rendering to a file
+var offlineDevices = &DeviceManager{midi: make(map[string]midiOut), mpe: make(map[string]*mpeZone), osc: make(map[string]*osc.Client), refs: make(map[string]int), offline: true}
+
+func NewDeviceManager() *DeviceManager {
+	return &DeviceManager{
+		midi:     make(map[string]midiOut),
+		mpe:      make(map[string]*mpeZone),
+		osc:      make(map[string]*osc.Client),
+		refs:     make(map[string]int),
+		openMidi: openMidiPort,
+	}
+}
+
+// noteDevice plays notes on a midi device, it is a gomidi.Device which
+// opens ports on every platform
+type noteDevice interface {
+	Open() error
+	NoteOn(channel, note, velocity uint8) error
+	NoteOff(channel, note uint8) error
+	Close() error
+}
+
+// midiPort is a midi output that plays notes through the gomidi device and
+// sends the other messages through a driver port when there is one for the
+// device, windows has none. It remembers the notes that are on, so they
+// can be let go of when it is closed.
+type midiPort struct {
+	device noteDevice
+	out    drivers.Out
+	// opened is whether the driver port was opened here, the gomidi
+	// device may have opened it already
+	opened  bool
+	notesOn map[[2]uint8]bool
+}
+
+// findDriverPort finds the driver port of a device
+var findDriverPort = func(name string) (drivers.Out, error) {
+	return midi.FindOutPort(name)
+}
+
+func openMidiPort(name string) (out midiOut, err error) {
+	match, err := MatchDevice(name, gomidi.Devices())
+	if err != nil {
+		return
+	}
+	device, err := gomidi.New(match)
+	if err != nil {
+		return
+	}
+	return newMidiPort(match, device)
+}
+
+func newMidiPort(name string, device noteDevice) (m *midiPort, err error) {
+	if err = device.Open(); err != nil {
+		return
+	}
+	m = &midiPort{device: device, notesOn: make(map[[2]uint8]bool)}
+	if port, errFind := findDriverPort(name); errFind == nil {
+		if port.IsOpen() {
+			m.out = port
+		} else if errOpen := port.Open(); errOpen == nil {
+			m.out = port
+			m.opened = true
+		}
+	} else {
+		log.Debugf("'%s' only plays notes: %s", name, errFind)
+	}
+	return
+}
+
+func (m *midiPort) NoteOn(channel, note, velocity uint8) (err error) {
+	err = m.device.NoteOn(channel, note, velocity)
+	if err == nil {
+		m.notesOn[[2]uint8{channel, note}] = true
+	}
+	return
+}
+
+func (m *midiPort) NoteOff(channel, note uint8) (err error) {
+	err = m.device.NoteOff(channel, note)
+	delete(m.notesOn, [2]uint8{channel, note})
+	return
+}
+
+// send sends a message that is not a note through the driver port
+func (m *midiPort) send(msg midi.Message) error {
+	if m.out == nil {
+		return fmt.Errorf("midi device can not send %s", msg.Type())
+	}
+	return m.out.Send(msg)
+}
+
+func (m *midiPort) PitchBend(channel uint8, value int16) error {
+	return m.send(midi.Pitchbend(channel, value))
+}
+
+func (m *midiPort) ControlChange(channel, controller, value uint8) error {
+	return m.send(midi.ControlChange(channel, controller, value))
+}
+
+func (m *midiPort) Pressure(channel, value uint8) error {
+	return m.send(midi.AfterTouch(channel, value))
+}
+
+func (m *midiPort) Close() error {
+	for key := range m.notesOn {
+		m.NoteOff(key[0], key[1])
+	}
+	if m.opened {
+		m.out.Close()
+	}
+	return m.device.Close()
+}
+
+// portArg returns the index of the argument that is the midi port of an out
+// function, it can be given first or by name like "midi(synth)", "midi(name=synth)"
+// or "midi(output=synth)". It is -1 when there is no port.
+func portArg(fn Function) int {
+	for _, name := range []string{"name", "output"} {
+		for i, arg := range fn.Args {
+			if arg.Name == name {
+				return i
+			}
+		}
+	}
+	if len(fn.Args) > 0 && fn.Args[0].Name == "" {
+		return 0
+	}
+	return -1
+}
+
+// portName returns the midi port of an out function
+func portName(fn Function) (name string, err error) {
+	i := portArg(fn)
+	if i < 0 {
+		err = fmt.Errorf("no midi port in '%s', should be like 'midi(synth)'", fn.Name)
+		return
+	}
+	name = fn.Args[i].Value
+	return
+}
+
+// deviceKey is the name the device of an out function is counted by,
+// it is empty for outputs without a device
+func deviceKey(fn Function) string {
+	switch fn.Name {
+	case "midi", "cc":
+		name, err := portName(fn)
+		if err == nil {
+			return "midi:" + name
+		}
+	case "osc":
+		address, err := fn.GetStringPlace("address", 0)
+		if err == nil {
+			return "osc:" + address
+		}
+	case "crow":
+		return "crow"
+	}
+	return ""
+}
+
+// Acquire opens the device of an out function if it is not open yet and
+// counts one more user of it, the key is what it is released with
+func (dm *DeviceManager) Acquire(fn Function) (key string, err error) {
+	key = deviceKey(fn)
+	if key == "" {
+		return
+	}
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.refs[key] == 0 && !dm.offline {
+		if name := strings.TrimPrefix(key, "midi:"); name != key {
+			dm.midi[name], err = dm.openMidi(name)
+			if err != nil {
+				delete(dm.midi, name)
+				key = ""
+				return
+			}
+			log.Debugf("opened midi device '%s'", name)
+		} else if address := strings.TrimPrefix(key, "osc:"); address != key {
+			dm.osc[address], err = osc.Dial(address)
+			if err != nil {
+				delete(dm.osc, address)
+				key = ""
+				return
+			}
+		} else if !dm.crows.IsReady {
+			dm.crows, err = crow.New()
+			if err != nil {
+				key = ""
+				return
+			}
+		}
+	}
+	dm.refs[key]++
+	return
+}
+
+// Release lets go of a device, closing it if nothing else uses it
+func (dm *DeviceManager) Release(key string) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.refs[key] <= 0 {
+		return
+	}
+	dm.refs[key]--
+	if dm.refs[key] > 0 {
+		return
+	}
+	delete(dm.refs, key)
+	if name := strings.TrimPrefix(key, "midi:"); name != key {
+		if out, ok := dm.midi[name]; ok {
+			if err := out.Close(); err != nil {
+				log.Error(err)
+			}
+			delete(dm.midi, name)
+			log.Debugf("closed midi device '%s'", name)
+		}
+	} else if address := strings.TrimPrefix(key, "osc:"); address != key {
+		if client, ok := dm.osc[address]; ok {
+			client.Close()
+			delete(dm.osc, address)
+		}
+	} else if dm.crows.IsReady {
+		dm.crows.Close()
+		dm.crows = crow.Murder{}
+	}
+}
+
+// Refs returns how many sequencers are using a device
+func (dm *DeviceManager) Refs(key string) int {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.refs[key]
+}
+
+// configureCrow sets the envelope and slew of a crow output
+func (dm *DeviceManager) configureCrow(fn Function) (err error) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if !dm.crows.IsReady {
+		return
+	}
+	output, _ := fn.GetIntPlace("output", 0)
+	if output > 0 {
+		dm.crows.UseEnv[output], _ = fn.GetInt("env")
+		if val, errSlew := fn.GetFloat("slew"); errSlew == nil {
+			err = dm.crows.SetSlew(output, val)
+		}
+	}
+	return
+}
+
+// crowPorts returns the ports of the crows that are open
+func (dm *DeviceManager) crowPorts() (ports []string) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.crows.IsReady {
+		for _, c := range dm.crows.Crow {
+			ports = append(ports, c.PortName)
+		}
+	}
+	return
+}
+
+// playable returns the notes without rests and holds
+func playable(notes []Note) (played []Note) {
+	for _, note := range notes {
+		if !note.IsRest && !note.IsLegato {
+			played = append(played, note)
+		}
+	}
+	return
+}
+
+// stepVelocity returns the velocity of a step, from a velocity decorator
+func stepVelocity(step Step) int {
+	if step.Params.CheckSet(VelocitySet) {
+		return step.Params.Velocity
+	}
+	return 120
+}
+
+// noteVelocity returns the velocity of a note of a step
+func noteVelocity(step Step, note Note) int {
+	if note.Velocity > 0 {
+		return note.Velocity
+	}
+	return stepVelocity(step)
+}
+
+// PlayNote plays or releases the notes of a step on every out function. With
+// a tuning each midi note is bent to its pitch and crow outputs get the tuned
+// voltage. Outputs like "midi(synth,mpe=true)" play each note on its own channel
+// and outputs like "midi(synth,oct=-1)" or "crow(1,transpose=7)" move the notes.
+func (dm *DeviceManager) PlayNote(step Step, on bool, outFns []Function, tuning *Tuning) (err error) {
+	return dm.playNote(step, on, outFns, tuning, -1)
+}
+
+// tunedChannel returns the channel of the note at an index of a step on a
+// midi output with a tuning. A channel has one pitch bend, so each note of a
+// chord plays on the channel after the one before.
+func tunedChannel(channel int, index int) int {
+	return (channel + index) % 16
+}
+
+// noteChannel returns the channel that the note at an index of a step plays
+// on with a midi output
+func noteChannel(out Function, tuning *Tuning, index int) int {
+	channel, _ := out.GetIntPlace("ch", 1)
+	if tuning != nil {
+		channel = tunedChannel(channel, index)
+	}
+	return channel
+}
+
+// playNote plays or lets go of the notes of a step, a channel that is not
+// negative is the channel of a single note that a voice was played on
+func (dm *DeviceManager) playNote(step Step, on bool, outFns []Function, tuning *Tuning, channel int) (err error) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	for _, out := range outFns {
+		step := transposeStep(step, transformSemitones(out.Args))
+		notes := playable(step.Notes)
+		step.Notes = notes
+		log.Debugf("[%+v] note %v: %+v", out, on, notes)
+		switch out.Name {
+		case "midi":
+			var output string
+			output, err = portName(out)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			log.Tracef("midi out: %s", output)
+			device, ok := dm.midi[output]
+			if !ok {
+				continue
+			}
+			if mpe, _ := out.GetBool("mpe"); mpe {
+				dm.playMpe(output, device, out, step, on, tuning)
+				continue
+			}
+			bendRange, errBend := out.GetInt("bend")
+			if errBend != nil {
+				bendRange = DefaultBendRange
+			}
+			for i, note := range notes {
+				key, bend, ok := tuning.PitchBend(note.Midi, bendRange)
+				if !ok {
+					continue
+				}
+				ch := noteChannel(out, tuning, i)
+				if channel >= 0 {
+					ch = channel
+				}
+				if on {
+					if tuning != nil {
+						device.PitchBend(uint8(ch), bend)
+					}
+					device.NoteOn(uint8(ch), uint8(key), uint8(util.Clamp(noteVelocity(step, note), 1, 127)))
+				} else {
+					device.NoteOff(uint8(ch), uint8(key))
+				}
+			}
+		case "crow":
+			// crow out
+			var output int
+			output, err = out.GetIntPlace("output", 0)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			if dm.crows.IsReady {
+				for i, note := range notes {
+					j := i * 2
+					volts, ok := tuning.Volts(note.Midi)
+					if !ok {
+						continue
+					}
+					if on {
+						dm.crows.SetVoltage(output+j, volts)
+					}
+					if dm.crows.UseEnv[output] > 0 {
+						dm.crows.On(dm.crows.UseEnv[output], on)
+					}
+				}
+			}
+		case "sc":
+			// sc out
+		}
+	}
+	return
+}
+
+// SendLane sends a value of a lane to an out function: a control change like
+// "cc(synth,74,ch=1)" which is rounded to 0-127, the volts of a crow output
+// like "crow(2)" or a float to an osc address like "osc(localhost:57120,/cutoff)"
+func (dm *DeviceManager) SendLane(out Function, value float64) (err error) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	switch out.Name {
+	case "cc":
+		var output string
+		output, err = portName(out)
+		if err != nil {
+			return
+		}
+		var controller int
+		controller, err = out.GetIntPlace("cc", 1)
+		if err != nil {
+			return
+		}
+		channel, _ := out.GetInt("ch")
+		if device, ok := dm.midi[output]; ok {
+			err = device.ControlChange(uint8(channel), uint8(util.Clamp(controller, 0, 127)), uint8(util.Clamp(int(math.Round(value)), 0, 127)))
+		}
+	case "crow":
+		var output int
+		output, err = out.GetIntPlace("output", 0)
+		if err == nil && dm.crows.IsReady {
+			err = dm.crows.SetVoltage(output, value)
+		}
+	case "osc":
+		var address, path string
+		address, err = out.GetStringPlace("address", 0)
+		if err == nil {
+			path, err = out.GetStringPlace("path", 1)
+		}
+		if err != nil {
+			return
+		}
+		if client, ok := dm.osc[address]; ok {
+			err = client.Send(path, float32(value))
+		}
+	}
+	return
+}
+
+// setCrowAdsr sets the envelope of the crow outputs of the chain
+// from an adsr decorator, scaled to the duration of the step
+func (dm *DeviceManager) setCrowAdsr(chain Chain, step Step, arg Arg) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	log.Trace("[setCrowAdsr] start")
+	for _, out := range chain.OutFns {
+		if out.Name == "crow" {
+			log.Trace("[setCrowAdsr] have crow out")
+			// set adsr
+			output, errInt := out.GetIntPlace("output", 0)
+			log.Tracef("[setCrowAdsr] output: %d", output)
+			log.Trace(errInt)
+			log.Trace(dm.crows.IsReady)
+
+			if errInt == nil && dm.crows.IsReady {
+				vals := SplitArgFloat(arg.Value)
+				log.Tracef("arg: %+v, vals: %+v", arg, vals)
+				if len(vals) == 4 {
+					log.Tracef("setting adsr: %+v", arg.Value)
+					log.Tracef("step: %+v", step)
+					for i, v := range vals {
+						if i != 2 {
+							vals[i] = v * float64(step.TimeDurationMicroseconds) / 1000000.0
+						}
+					}
+					dm.crows.SetADSR(output+1, crow.ADSR{Attack: vals[0], Decay: vals[1], Sustain: vals[2], Release: vals[3]})
+				}
+			}
+		}
+	}
+}
+
+// Flush sends anything that is waiting to be sent to the crows
+func (dm *DeviceManager) Flush() {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.crows.NeedsFlush {
+		dm.crows.Flush()
+	}
+}
@@ -0,0 +1,230 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+type fakeMidi struct {
+	notes    []uint8
+	offs     []uint8
+	channels []uint8
+	// offChannels and bendChannels are the channels of note offs and bends
+	offChannels  []uint8
+	bends        []int16
+	bendChannels []uint8
+	controls     [][2]uint8
+	pressure     []uint8
+	closed       bool
+}
+
+func (f *fakeMidi) NoteOn(channel, note, velocity uint8) error {
+	f.notes = append(f.notes, note)
+	f.channels = append(f.channels, channel)
+	return nil
+}
+
+func (f *fakeMidi) NoteOff(channel, note uint8) error {
+	f.offs = append(f.offs, note)
+	f.offChannels = append(f.offChannels, channel)
+	return nil
+}
+
+func (f *fakeMidi) PitchBend(channel uint8, value int16) error {
+	f.bends = append(f.bends, value)
+	f.bendChannels = append(f.bendChannels, channel)
+	return nil
+}
+
+func (f *fakeMidi) ControlChange(channel, controller, value uint8) error {
+	f.controls = append(f.controls, [2]uint8{controller, value})
+	return nil
+}
+
+func (f *fakeMidi) Pressure(channel, value uint8) error {
+	f.pressure = append(f.pressure, value)
+	return nil
+}
+
+func (f *fakeMidi) Open() error {
+	return nil
+}
+
+func (f *fakeMidi) Close() error {
+	f.closed = true
+	return nil
+}
+
+// fakeDriverOut is a driver port that keeps the messages sent to it
+type fakeDriverOut struct {
+	open bool
+	sent [][]byte
+}
+
+func (f *fakeDriverOut) Open() error             { f.open = true; return nil }
+func (f *fakeDriverOut) Close() error            { f.open = false; return nil }
+func (f *fakeDriverOut) IsOpen() bool            { return f.open }
+func (f *fakeDriverOut) Number() int             { return 0 }
+func (f *fakeDriverOut) String() string          { return "fake" }
+func (f *fakeDriverOut) Underlying() interface{} { return f }
+func (f *fakeDriverOut) Send(data []byte) error {
+	f.sent = append(f.sent, data)
+	return nil
+}
+
+func TestDeviceManager(t *testing.T) {
+	log.SetLevel("info")
+	opened := make(map[string]*fakeMidi)
+	dm := NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		opened[name] = &fakeMidi{}
+		return opened[name], nil
+	}
+	text := `
+run a
+c4 d
+
+tie a
+out midi(synth)
+`
+	tli1, err := newWithDevices(text, nil, dm)
+	assert.Nil(t, err)
+	tli2, err := newWithDevices(text, nil, dm)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(opened))
+	assert.Equal(t, 2, dm.Refs("midi:synth"))
+
+	// both sequencers play on the same device
+	assert.Nil(t, tli1.SendNote("midi(synth)", 60, true))
+	assert.Nil(t, tli2.SendNote("midi(synth)", 62, true))
+	assert.Equal(t, []uint8{60, 62}, opened["synth"].notes)
+
+	// updating to another device lets go of the old one
+	assert.Nil(t, tli1.Update(`
+run a
+c4 d
+
+tie a
+out midi(drums)
+`))
+	assert.Equal(t, 1, dm.Refs("midi:synth"))
+	assert.Equal(t, 1, dm.Refs("midi:drums"))
+	assert.Nil(t, tli1.Update(tli1.Text))
+	assert.Equal(t, 1, dm.Refs("midi:drums"))
+
+	tli2.Close()
+	assert.Equal(t, 0, dm.Refs("midi:synth"))
+	assert.True(t, opened["synth"].closed)
+	assert.False(t, opened["drums"].closed)
+	tli1.Close()
+	assert.True(t, opened["drums"].closed)
+}
+
+func TestMidiPort(t *testing.T) {
+	log.SetLevel("info")
+	defer func(find func(string) (drivers.Out, error)) { findDriverPort = find }(findDriverPort)
+
+	// without a driver port, like on windows, notes still play
+	findDriverPort = func(name string) (drivers.Out, error) {
+		return nil, fmt.Errorf("no driver registered")
+	}
+	device := &fakeMidi{}
+	port, err := newMidiPort("synth", device)
+	assert.Nil(t, err)
+	assert.Nil(t, port.NoteOn(1, 60, 100))
+	assert.Nil(t, port.NoteOn(1, 64, 100))
+	assert.Nil(t, port.NoteOff(1, 64))
+	assert.NotNil(t, port.PitchBend(1, 100))
+	assert.Nil(t, port.Close())
+	assert.Equal(t, []uint8{60, 64}, device.notes)
+	assert.Equal(t, []uint8{64, 60}, device.offs)
+	assert.True(t, device.closed)
+
+	// other messages go through the driver port
+	out := &fakeDriverOut{}
+	findDriverPort = func(name string) (drivers.Out, error) {
+		return out, nil
+	}
+	device = &fakeMidi{}
+	port, err = newMidiPort("synth", device)
+	assert.Nil(t, err)
+	assert.Nil(t, port.PitchBend(1, 0))
+	assert.Nil(t, port.ControlChange(1, 74, 10))
+	assert.Equal(t, [][]byte{{0xe1, 0x00, 0x40}, {0xb1, 74, 10}}, out.sent)
+	assert.Nil(t, port.Close())
+	assert.False(t, out.open)
+	assert.True(t, device.closed)
+}
+
+func TestPortForms(t *testing.T) {
+	log.SetLevel("info")
+	for _, out := range []string{
+		"midi(synth)",
+		"midi(name=synth)",
+		"midi(output=synth)",
+		"midi(ch=2,name=synth)",
+		"midi(synth,mpe=true)",
+		"midi(name=synth,mpe=true)",
+		"midi(output=synth,mpe=true)",
+	} {
+		opened := make(map[string]*fakeMidi)
+		dm := NewDeviceManager()
+		dm.openMidi = func(name string) (midiOut, error) {
+			opened[name] = &fakeMidi{}
+			return opened[name], nil
+		}
+		tli, err := newWithDevices("run a\nc4 d4\n\ntie a\nout "+out, nil, dm)
+		assert.Nil(t, err, out)
+		assert.Equal(t, 1, dm.Refs("midi:synth"), out)
+		chain := tli.ChainsRendered[0]
+		assert.Nil(t, dm.PlayNote(chain.Steps[0], true, chain.OutFns, nil), out)
+		if assert.NotNil(t, opened["synth"], out) {
+			assert.Equal(t, []uint8{60}, opened["synth"].notes, out)
+		}
+		tli.Close()
+	}
+
+	// aliases are resolved in every form
+	for _, out := range []string{"midi(lead)", "midi(name=lead)", "midi(output=lead)", "cc(output=lead,74,lane=cutoff)"} {
+		opened := make(map[string]*fakeMidi)
+		dm := NewDeviceManager()
+		dm.openMidi = func(name string) (midiOut, error) {
+			opened[name] = &fakeMidi{}
+			return opened[name], nil
+		}
+		tli, err := newWithDevices("lane cutoff: 0 1\n\nrun a\nc4 d4\n\ntie a\nout "+out, map[string]string{"lead": "synth"}, dm)
+		assert.Nil(t, err, out)
+		assert.Equal(t, 1, dm.Refs("midi:synth"), out)
+		assert.NotNil(t, opened["synth"], out)
+		tli.Close()
+	}
+}
+
+func TestClockShared(t *testing.T) {
+	clock := NewManualClock()
+	start1, joined := clockStart(clock)
+	assert.False(t, joined)
+	clock.Advance(time.Second)
+	start2, joined := clockStart(clock)
+	assert.True(t, joined)
+	assert.Equal(t, start1, start2)
+	clockStop(clock)
+	clockStop(clock)
+	clockStop(clock)
+	start3, joined := clockStart(clock)
+	assert.False(t, joined)
+	assert.NotEqual(t, start1, start3)
+
+	// sequencers on another clock keep their own time
+	other := NewManualClock()
+	start4, joined := clockStart(other)
+	assert.False(t, joined)
+	assert.Equal(t, time.Duration(0), start4)
+	clockStop(other)
+	clockStop(clock)
+}
@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/schollz/aw/internal/crow"
+	"github.com/schollz/gomidi"
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// DeviceList is every device that can be used in out lines
+type DeviceList struct {
+	MidiIns  []string
+	MidiOuts []string
+	Crows    []string
+}
+
+// ListDevices finds the connected midi devices and crows
+func ListDevices() (devices DeviceList) {
+	for _, in := range midi.GetInPorts() {
+		devices.MidiIns = append(devices.MidiIns, in.String())
+	}
+	devices.MidiOuts = MidiOutputs()
+	devices.Crows = SharedDevices.crowPorts()
+	if len(devices.Crows) == 0 {
+		devices.Crows, _ = crow.List()
+	}
+	return
+}
+
+// MidiOutputs returns the names of the midi outputs
+func MidiOutputs() []string {
+	return gomidi.Devices()
+}
+
+func (d DeviceList) String() string {
+	var sb strings.Builder
+	write := func(title string, names []string) {
+		sb.WriteString(title + ":\n")
+		if len(names) == 0 {
+			sb.WriteString("  (none)\n")
+		}
+		for i, name := range names {
+			sb.WriteString(fmt.Sprintf("  %d. %s\n", i+1, name))
+		}
+	}
+	write("midi outputs", d.MidiOuts)
+	write("midi inputs", d.MidiIns)
+	write("crows", d.Crows)
+	return sb.String()
+}
+
+// MatchDevice finds the device that best matches the name, first by the exact
+// name, then ignoring case and punctuation, then by part of the name and last
+// by the letters of the name in order
+func MatchDevice(name string, names []string) (match string, err error) {
+	if len(names) == 0 {
+		err = fmt.Errorf("no midi device '%s', no midi devices found", name)
+		return
+	}
+	for _, n := range names {
+		if n == name {
+			return n, nil
+		}
+	}
+	query := normalizeDeviceName(name)
+	matchers := []func(string) bool{
+		func(n string) bool { return n == query },
+		func(n string) bool { return strings.Contains(n, query) },
+		func(n string) bool { return isSubsequence(query, n) },
+	}
+	for _, matcher := range matchers {
+		for _, n := range names {
+			if matcher(normalizeDeviceName(n)) && (match == "" || len(n) < len(match)) {
+				match = n
+			}
+		}
+		if match != "" {
+			return
+		}
+	}
+	err = fmt.Errorf("no midi device '%s', found: %s", name, strings.Join(names, ", "))
+	return
+}
+
+func normalizeDeviceName(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// isSubsequence returns whether all the letters of a appear in b in order
+func isSubsequence(a, b string) bool {
+	runes := []rune(a)
+	i := 0
+	for _, r := range b {
+		if i < len(runes) && runes[i] == r {
+			i++
+		}
+	}
+	return len(runes) > 0 && i == len(runes)
+}
@@ -0,0 +1,31 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchDevice(t *testing.T) {
+	names := []string{"Midi Through:Midi Through Port-0 14:0", "UM-ONE:UM-ONE MIDI 1 20:0", "OP-1:OP-1 MIDI 1 24:0"}
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"UM-ONE:UM-ONE MIDI 1 20:0", "UM-ONE:UM-ONE MIDI 1 20:0"},
+		{"um-one", "UM-ONE:UM-ONE MIDI 1 20:0"},
+		{"umone midi", "UM-ONE:UM-ONE MIDI 1 20:0"},
+		{"op1", "OP-1:OP-1 MIDI 1 24:0"},
+		{"through", "Midi Through:Midi Through Port-0 14:0"},
+	}
+	for _, test := range tests {
+		match, err := MatchDevice(test.name, names)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, match)
+	}
+
+	_, err := MatchDevice("volca", names)
+	assert.NotNil(t, err)
+	_, err = MatchDevice("volca", nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,184 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/schollz/logger"
+)
+
+// DrumMaps are the built-in maps from drum names to midi notes, a loop uses
+// one with "run drums(map=gm)"
+var DrumMaps = map[string]map[string]int{
+	"gm": {
+		"bd":  36, // bass drum
+		"rs":  37, // rim shot
+		"sn":  38, // snare
+		"sd":  38,
+		"cp":  39, // clap
+		"lt":  45, // low tom
+		"mt":  47, // mid tom
+		"ht":  50, // high tom
+		"hh":  42, // closed hi-hat
+		"ch":  42,
+		"ph":  44, // pedal hi-hat
+		"oh":  46, // open hi-hat
+		"cr":  49, // crash
+		"rd":  51, // ride
+		"tb":  54, // tambourine
+		"cb":  56, // cowbell
+		"sh":  70, // shaker
+		"cl":  75, // claves
+		"wb":  76, // wood block
+		"cym": 49,
+	},
+}
+
+// DefaultDrumMap is the map that custom drum maps start from
+const DefaultDrumMap = "gm"
+
+// LaneSeparator separates the lanes of a drum line, e.g. "bd ~ sn ~ | hh*8"
+const LaneSeparator = "|"
+
+// ParseDrumMap parses a line of a set block like "map tr8 bd=35 sn=d2" into
+// the name of the map and the drums it changes from the gm map
+func ParseDrumMap(line string) (name string, drums map[string]int, err error) {
+	fields := strings.Fields(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "map")))
+	if len(fields) == 0 {
+		err = fmt.Errorf("drum map needs a name")
+		return
+	}
+	name = fields[0]
+	drums = make(map[string]int)
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			err = fmt.Errorf("drum map '%s': '%s' should be like 'bd=36'", name, field)
+			return
+		}
+		midi, errAtoi := strconv.Atoi(parts[1])
+		if errAtoi != nil {
+			note, ok := exactMatch(strings.ToLower(parts[1]))
+			if !ok {
+				err = fmt.Errorf("drum map '%s': bad note '%s'", name, parts[1])
+				return
+			}
+			midi = note.Midi
+		}
+		drums[parts[0]] = midi
+	}
+	return
+}
+
+// drumMap returns the drums of the loop's map, custom maps are
+// the gm map with some drums changed
+func (p *Loop) drumMap() (drums map[string]int, err error) {
+	if drums, ok := DrumMaps[p.Drums]; ok {
+		return drums, nil
+	}
+	custom, ok := p.drumMaps[p.Drums]
+	if !ok {
+		err = fmt.Errorf("unknown drum map '%s'", p.Drums)
+		return
+	}
+	drums = make(map[string]int)
+	for k, v := range DrumMaps[DefaultDrumMap] {
+		drums[k] = v
+	}
+	for k, v := range custom {
+		drums[k] = v
+	}
+	return
+}
+
+// addDrumLine adds a line of a drum loop, where each lane separated by "|"
+// is spread over the same beats and the lanes play together
+func (p *Loop) addDrumLine(line string) (err error) {
+	drums, err := p.drumMap()
+	if err != nil {
+		return
+	}
+	lanes := [][]string{}
+	slots := 1
+	for _, lane := range strings.Split(line, LaneSeparator) {
+		if strings.TrimSpace(lane) == "" {
+			continue
+		}
+		var tokens []string
+		tokens, err = p.expandLine(lane)
+		if err != nil {
+			return
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		lanes = append(lanes, tokens)
+		slots = lcm(slots, len(tokens))
+	}
+	if len(lanes) == 0 {
+		return
+	}
+	steps := []Step{}
+	for slot := 0; slot < slots; slot++ {
+		step := Step{BeatsPerLine: p.lastBeatsPerLine, StepLineCount: slots}
+		played := []string{}
+		resting := false
+		for _, lane := range lanes {
+			every := slots / len(lane)
+			token := lane[slot/every]
+			fn, _ := ParseFunction(token)
+			if fn.Name == REST {
+				resting = true
+			}
+			if slot%every != 0 || fn.Name == HOLD || fn.Name == REST {
+				continue
+			}
+			var notes []Note
+			if midi, ok := drums[fn.Name]; ok {
+				notes = []Note{{Midi: midi, Name: MidiName(midi), NameOriginal: fn.Name}}
+			} else if notes, err = ParseMidi(fn.Name, p.lastMidiNote); err != nil {
+				err = fmt.Errorf("unknown drum '%s' in map '%s'", fn.Name, p.Drums)
+				log.Error(err)
+				return
+			}
+			params := step.Params
+			p.decorate(&step, fn.Args)
+			if len(lanes) > 1 && step.Params.CheckSet(VelocitySet) {
+				// the velocity of a lane is only for the notes of the lane
+				for k := range notes {
+					notes[k].Velocity = step.Params.Velocity
+				}
+				step.Params.IsSet = step.Params.IsSet&^VelocitySet | params.IsSet&VelocitySet
+				step.Params.Velocity = params.Velocity
+			}
+			step.Notes = append(step.Notes, notes...)
+			step.Arguments = append(step.Arguments, fn.Args...)
+			played = append(played, token)
+		}
+		step.Token = strings.Join(played, " ")
+		if len(step.Notes) == 0 {
+			if resting {
+				step.Notes = []Note{{IsRest: true}}
+				step.Token = REST
+			} else {
+				step.Notes = []Note{{IsLegato: true}}
+				step.Token = HOLD
+			}
+		}
+		steps = append(steps, step)
+	}
+	p.Steps = append(p.Steps, steps...)
+	return
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func lcm(a, b int) int {
+	return a / gcd(a, b) * b
+}
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrumLine(t *testing.T) {
+	log.SetLevel("info")
+	for _, test := range []struct {
+		line  string
+		notes [][]int
+	}{
+		{"bd sn", [][]int{{36}, {38}}},
+		{"bd ~ sn ~", [][]int{{36}, {}, {38}, {}}},
+		{"bd _ sn _", [][]int{{36}, nil, {38}, nil}},
+		{"bd ~ sn ~ | hh*8", [][]int{{36, 42}, {42}, {42}, {42}, {38, 42}, {42}, {42}, {42}}},
+		{"bd [sn sn] | oh hh cp", [][]int{{36, 46}, nil, nil, nil, {42}, nil, {38}, nil, {39}, {38}, nil, nil}},
+		{"bd c2", [][]int{{36}, {36}}},
+	} {
+		loop := LoopNew()
+		loop.Drums = "gm"
+		assert.Nil(t, loop.AddLine(test.line), test.line)
+		notes := [][]int{}
+		for _, step := range loop.Steps {
+			assert.Equal(t, len(test.notes), step.StepLineCount, test.line)
+			midis := []int{}
+			if step.Notes[0].IsLegato {
+				midis = nil
+			}
+			for _, note := range step.Notes {
+				if !note.IsRest && !note.IsLegato {
+					midis = append(midis, note.Midi)
+				}
+			}
+			notes = append(notes, midis)
+		}
+		assert.Equal(t, test.notes, notes, test.line)
+	}
+
+	loop := LoopNew()
+	loop.Drums = "gm"
+	assert.NotNil(t, loop.AddLine("bd xx"))
+	loop.Drums = "nope"
+	assert.NotNil(t, loop.AddLine("bd"))
+}
+
+func TestDrumLaneVelocity(t *testing.T) {
+	loop := LoopNew()
+	loop.Drums = "gm"
+	assert.Nil(t, loop.AddLine("bd | hh(v40)"))
+	step := loop.Steps[0]
+	assert.False(t, step.Params.CheckSet(VelocitySet))
+	assert.Equal(t, 120, noteVelocity(step, step.Notes[0]))
+	assert.Equal(t, 40, noteVelocity(step, step.Notes[1]))
+
+	// with one lane the velocity is the velocity of the step
+	loop = LoopNew()
+	loop.Drums = "gm"
+	assert.Nil(t, loop.AddLine("bd(v40) sn"))
+	assert.Equal(t, 40, loop.Steps[0].Params.Velocity)
+	assert.Equal(t, 0, loop.Steps[0].Notes[0].Velocity)
+}
+
+func TestParseDrumMap(t *testing.T) {
+	name, drums, err := ParseDrumMap("map tr8 bd=35 sn=d2")
+	assert.Nil(t, err)
+	assert.Equal(t, "tr8", name)
+	assert.Equal(t, map[string]int{"bd": 35, "sn": 38}, drums)
+	_, _, err = ParseDrumMap("map tr8 bd")
+	assert.NotNil(t, err)
+	_, _, err = ParseDrumMap("map tr8 bd=x9")
+	assert.NotNil(t, err)
+}
+
+func TestDrums(t *testing.T) {
+	tli, err := NewOffline(`
+run beat(map=tr8)
+bd ~ sn ~ | hh*4
+
+tie beat
+
+set
+map tr8 bd=35
+`)
+	assert.Nil(t, err)
+	assert.Empty(t, tli.warnings)
+	assert.Equal(t, "beat", tli.Loops[0].Name)
+	assert.Equal(t, "tr8", tli.Loops[0].Drums)
+	assert.Equal(t, 35, tli.ChainsRendered[0].Steps[0].Notes[0].Midi)
+	assert.Equal(t, 42, tli.ChainsRendered[0].Steps[0].Notes[1].Midi)
+	assert.Equal(t, 4, len(tli.ChainsRendered[0].Steps))
+}
@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsBlockStart returns whether a line starts a block like "run a" or "set"
+func IsBlockStart(line string) bool {
+	return blockStartRegex.MatchString(strings.TrimSpace(line))
+}
+
+// block is a block of a text, the lines before the first block have no header
+type block struct {
+	key   string
+	lines []string
+}
+
+// blockKey returns the name that a block is merged by, like "run a",
+// "lane cutoff" or "tie a b", which is empty for blocks that are not merged
+func blockKey(header string) string {
+	header = strings.TrimSpace(strings.Split(header, "//")[0])
+	switch {
+	case strings.HasPrefix(header, "run"):
+		fn, err := ParseFunction(strings.TrimSpace(strings.TrimPrefix(header, "run")))
+		if err == nil && fn.Name != "" {
+			return "run " + fn.Name
+		}
+	case strings.HasPrefix(header, "lane"):
+		if lane, err := ParseLane(header); err == nil {
+			return "lane " + lane.Name
+		}
+	case strings.HasPrefix(header, "tie"):
+		// a tie block is the same block when only its transforms change
+		header, _, _ = SplitTransforms(header)
+		return "tie " + strings.Join(strings.Fields(strings.TrimPrefix(header, "tie")), " ")
+	}
+	return ""
+}
+
+func splitBlocks(text string) (blocks []block) {
+	blocks = []block{{}}
+	for _, line := range strings.Split(text, "\n") {
+		if IsBlockStart(line) {
+			blocks = append(blocks, block{key: blockKey(line)})
+		}
+		blocks[len(blocks)-1].lines = append(blocks[len(blocks)-1].lines, line)
+	}
+	return
+}
+
+// trimBlank splits the blank lines off the end of a block
+func trimBlank(lines []string) (trimmed []string, blank []string) {
+	end := len(lines)
+	for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return lines[:end], lines[end:]
+}
+
+// MergeBlocks replaces the run, tie and lane blocks of a text with the blocks
+// of the same name in a part of a text, blocks that are not in the text yet
+// are added at the end. It returns the names of the merged blocks.
+func MergeBlocks(text string, part string) (merged string, names []string, err error) {
+	blocks := splitBlocks(text)
+	for i, b := range splitBlocks(part) {
+		lines, _ := trimBlank(b.lines)
+		if b.key == "" {
+			if len(lines) > 0 {
+				if i == 0 {
+					err = fmt.Errorf("'%s' is not in a run, tie or lane block", strings.TrimSpace(lines[0]))
+				} else {
+					err = fmt.Errorf("only run, tie and lane blocks can be evaluated")
+				}
+				return
+			}
+			continue
+		}
+		names = append(names, b.key)
+		found := false
+		for j := range blocks {
+			if blocks[j].key == b.key {
+				_, blank := trimBlank(blocks[j].lines)
+				blocks[j].lines = append(append([]string{}, lines...), blank...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			last := &blocks[len(blocks)-1]
+			if _, blank := trimBlank(last.lines); len(blank) == 0 {
+				last.lines = append(last.lines, "")
+			}
+			blocks = append(blocks, block{key: b.key, lines: append(lines, "")})
+		}
+	}
+	if len(names) == 0 {
+		err = fmt.Errorf("nothing to evaluate")
+		return
+	}
+	all := []string{}
+	for _, b := range blocks {
+		all = append(all, b.lines...)
+	}
+	merged = strings.Join(all, "\n")
+	return
+}
+
+// Eval merges a part of a text, like the run block that is being edited,
+// into the text that is playing without reloading anything else
+func (tli *TLI) Eval(part string) (names []string, err error) {
+	tli.mu.Lock()
+	text := tli.Text
+	tli.mu.Unlock()
+	merged, names, err := MergeBlocks(text, part)
+	if err != nil {
+		return
+	}
+	err = tli.Update(merged)
+	return
+}
@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeBlocks(t *testing.T) {
+	text := `set
+bpm 120
+
+run a
+c4 e
+
+run b
+g4
+
+tie a b
+out crow(1)
+`
+	for _, test := range []struct {
+		part   string
+		merged string
+		names  []string
+	}{
+		{"run a\nd4 f\n", "set\nbpm 120\n\nrun a\nd4 f\n\nrun b\ng4\n\ntie a b\nout crow(1)\n", []string{"run a"}},
+		{"run b(map=gm)\nbd sn", "set\nbpm 120\n\nrun a\nc4 e\n\nrun b(map=gm)\nbd sn\n\ntie a b\nout crow(1)\n", []string{"run b"}},
+		{"\nrun c\na3\n\ntie   a  b\nout midi(synth)", "set\nbpm 120\n\nrun a\nc4 e\n\nrun b\ng4\n\ntie   a  b\nout midi(synth)\n\nrun c\na3\n", []string{"run c", "tie a b"}},
+		// changing the transforms of a tie replaces it
+		{"tie a b (rev)\nout crow(1)", "set\nbpm 120\n\nrun a\nc4 e\n\nrun b\ng4\n\ntie a b (rev)\nout crow(1)\n", []string{"tie a b"}},
+	} {
+		merged, names, err := MergeBlocks(text, test.part)
+		assert.Nil(t, err, test.part)
+		assert.Equal(t, test.merged, merged, test.part)
+		assert.Equal(t, test.names, names, test.part)
+	}
+	for _, part := range []string{"", "\n\n", "c4 e", "set\nbpm 90", "run a\nc4\nset\nbpm 90"} {
+		_, _, err := MergeBlocks(text, part)
+		assert.NotNil(t, err, part)
+	}
+	_, _, err := MergeBlocks(text, "run a\nc4\nset\nbpm 90")
+	assert.EqualError(t, err, "only run, tie and lane blocks can be evaluated")
+}
+
+func TestEval(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := NewOffline(`
+run a
+c4 e
+
+run b
+g4
+`)
+	assert.Nil(t, err)
+	names, err := tli.Eval("run a\nd4 f4 a4")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"run a"}, names)
+	assert.Equal(t, 2, len(tli.Loops))
+	assert.Equal(t, 3, len(tli.Loops[0].Steps))
+	assert.Equal(t, 62, tli.Loops[0].Steps[0].Notes[0].Midi)
+	assert.Equal(t, 67, tli.Loops[1].Steps[0].Notes[0].Midi)
+
+	_, err = tli.Eval("d4")
+	assert.NotNil(t, err)
+}
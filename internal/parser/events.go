@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"sync"
+
+	log "github.com/schollz/logger"
+)
+
+// EventType is the kind of thing that happened in the sequencer
+type EventType int
+
+const (
+	// EventStep is sent when a chain plays a step
+	EventStep EventType = iota
+	// EventCycle is sent when a chain starts over from the beginning
+	EventCycle
+	// EventPlay is sent when the transport starts
+	EventPlay
+	// EventStop is sent when the transport stops
+	EventStop
+)
+
+// Event is something that happened in the sequencer
+type Event struct {
+	Type EventType
+	// Sequencer is the sequencer that sent the event
+	Sequencer *TLI
+	// Chain is the name of the chain for step and cycle events
+	Chain string
+	// Step is the index of the step for step events
+	Step int
+}
+
+// Events receives the steps and cycles of the sequencers so the editor
+// can pass them on to plugins. They are dropped if nothing is reading them
+// so that the sequencer never waits on the editor.
+var Events = make(chan Event, 256)
+
+// Transports gets a value when a sequencer starts or stops, the events
+// are then returned by TransportEvents
+var Transports = make(chan struct{}, 1)
+
+// transports are the latest play or stop event of every sequencer, in the
+// order the sequencers first sent one, they are never dropped
+var transports struct {
+	sync.Mutex
+	latest map[*TLI]Event
+	order  []*TLI
+}
+
+// TransportEvents returns the latest play or stop event of each sequencer
+// since it was last called
+func TransportEvents() (events []Event) {
+	transports.Lock()
+	defer transports.Unlock()
+	for _, tli := range transports.order {
+		events = append(events, transports.latest[tli])
+	}
+	transports.latest = nil
+	transports.order = nil
+	return
+}
+
+func emit(e Event) {
+	if e.Type == EventPlay || e.Type == EventStop {
+		transports.Lock()
+		if transports.latest == nil {
+			transports.latest = make(map[*TLI]Event)
+		}
+		if _, ok := transports.latest[e.Sequencer]; !ok {
+			transports.order = append(transports.order, e.Sequencer)
+		}
+		transports.latest[e.Sequencer] = e
+		transports.Unlock()
+		select {
+		case Transports <- struct{}{}:
+		default:
+		}
+		return
+	}
+	select {
+	case Events <- e:
+	default:
+		log.Tracef("dropped event %+v", e)
+	}
+}
@@ -0,0 +1,34 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportEvents(t *testing.T) {
+	a, b := &TLI{}, &TLI{}
+	TransportEvents()
+	for i := 0; i < cap(Events)+1; i++ {
+		emit(Event{Type: EventStep, Sequencer: a})
+	}
+	emit(Event{Type: EventPlay, Sequencer: a})
+	emit(Event{Type: EventPlay, Sequencer: b})
+	emit(Event{Type: EventStop, Sequencer: a})
+	<-Transports
+
+	var got []Event
+	for _, e := range TransportEvents() {
+		if e.Sequencer == a || e.Sequencer == b {
+			got = append(got, e)
+		}
+	}
+	assert.Equal(t, []Event{
+		{Type: EventStop, Sequencer: a},
+		{Type: EventPlay, Sequencer: b},
+	}, got)
+	assert.Empty(t, TransportEvents())
+	for len(Events) > 0 {
+		<-Events
+	}
+}
@@ -13,7 +13,8 @@ func (f Function) GetFloatPlace(name string, place int) (val float64, err error)
 			return
 		}
 	}
-	if place < len(f.Args) {
+	// a named argument is not in a place
+	if place < len(f.Args) && f.Args[place].Name == "" {
 		val, err = strconv.ParseFloat(f.Args[place].Value, 64)
 		return
 	}
@@ -32,6 +33,16 @@ func (f Function) GetFloat(name string) (val float64, err error) {
 	err = fmt.Errorf("could not find argument %s", name)
 	return
 }
+func (f Function) GetString(name string) (val string, err error) {
+	for _, arg := range f.Args {
+		if arg.Name == name {
+			val = arg.Value
+			return
+		}
+	}
+	err = fmt.Errorf("could not find argument %s", name)
+	return
+}
 func (f Function) GetStringPlace(name string, place int) (val string, err error) {
 	for _, arg := range f.Args {
 		if arg.Name == name {
@@ -39,7 +50,7 @@ func (f Function) GetStringPlace(name string, place int) (val string, err error)
 			return
 		}
 	}
-	if place < len(f.Args) {
+	if place < len(f.Args) && f.Args[place].Name == "" {
 		val = f.Args[place].Value
 		return
 	}
@@ -58,6 +69,17 @@ func (f Function) GetInt(name string) (val int, err error) {
 	return
 }
 
+func (f Function) GetBool(name string) (val bool, err error) {
+	for _, arg := range f.Args {
+		if arg.Name == name {
+			val, err = strconv.ParseBool(arg.Value)
+			return
+		}
+	}
+	err = fmt.Errorf("could not find argument %s", name)
+	return
+}
+
 func (f Function) GetIntPlace(name string, place int) (val int, err error) {
 	for _, arg := range f.Args {
 		if arg.Name == name {
@@ -65,7 +87,7 @@ func (f Function) GetIntPlace(name string, place int) (val int, err error) {
 			return
 		}
 	}
-	if place < len(f.Args) {
+	if place < len(f.Args) && f.Args[place].Name == "" {
 		val, err = strconv.Atoi(f.Args[place].Value)
 		return
 	}
@@ -0,0 +1,236 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// Generator returns the notes or tokens for a "lua(fn,arg1,...)" token by
+// calling the lua function fn with the arguments. The script is the text of
+// every script block in the file.
+type Generator func(script string, fn string, args []string) (phrase string, err error)
+
+// RunGenerator is set by the editor to call into lua
+var RunGenerator Generator
+
+// lines that end a script block
+var blockStartRegex = regexp.MustCompile(`^(run|lane|tie|set|script|def|include|song)(\s|$)`)
+
+// expandGenerators replaces every lua() token with the tokens it generates
+func (p *Loop) expandGenerators(tokens []string) (newTokens []string, err error) {
+	for _, token := range tokens {
+		fn, _ := ParseFunction(token)
+		if fn.Name != "lua" || p.skipGenerators {
+			newTokens = append(newTokens, token)
+			continue
+		}
+		if len(fn.Args) == 0 {
+			err = fmt.Errorf("'%s' needs the name of a function", token)
+			return
+		}
+		if RunGenerator == nil {
+			err = fmt.Errorf("lua is not available for '%s'", token)
+			return
+		}
+		args := []string{}
+		for _, arg := range fn.Args[1:] {
+			if arg.Name == "cycle" {
+				// generate again every time the chain starts over
+				p.Regenerate = arg.Value != "false" && arg.Value != "0"
+				continue
+			}
+			args = append(args, arg.Value)
+		}
+		phrase, errRun := RunGenerator(p.script, fn.Args[0].Value, args)
+		if errRun != nil {
+			err = fmt.Errorf("%s: %s", token, errRun.Error())
+			return
+		}
+		phrase, err = ExpandMacros(SanitizeLine(phrase), p.defs)
+		if err != nil {
+			return
+		}
+		phraseTokens, errTokenize := TokenizeLineString(ExpandMultiplication(phrase))
+		if errTokenize != nil {
+			err = fmt.Errorf("%s: %s", token, errTokenize.Error())
+			return
+		}
+		newTokens = append(newTokens, phraseTokens...)
+	}
+	return
+}
+
+// cycle is the next cycle of a chain that generates again, it is wanted
+// until it is generated or generating it failed
+type cycle struct {
+	chain  *Chain
+	failed bool
+}
+
+// Cycles rings when a sequencer wants the next cycle of a chain generated.
+// The editor then calls GenerateCycles so lua only runs where the editor runs
+// it. A ring is never lost, it is dropped only when one is waiting already.
+var Cycles = make(chan struct{}, 1)
+
+var (
+	cyclesMu     sync.Mutex
+	cyclesWanted = make(map[*TLI]bool)
+)
+
+// regenerates returns whether the chain plays a loop with
+// generators that run on every cycle
+func (tli *TLI) regenerates(i int) bool {
+	for _, loopName := range tli.ChainsRendered[i].NameLoop {
+		for _, loop := range tli.Loops {
+			if loop.Name == loopName && loop.Regenerate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wantCycle asks for the next cycle of a chain that generates again
+// to be generated, if it is not already
+func (tli *TLI) wantCycle(i int) {
+	if _, ok := tli.cycles[i]; ok || !tli.regenerates(i) {
+		return
+	}
+	if tli.cycles == nil {
+		tli.cycles = make(map[int]*cycle)
+	}
+	tli.cycles[i] = &cycle{}
+	cyclesMu.Lock()
+	cyclesWanted[tli] = true
+	cyclesMu.Unlock()
+	select {
+	case Cycles <- struct{}{}:
+	default:
+	}
+}
+
+// nextCycle starts the next cycle of a chain that generates again with the
+// steps that were generated for it, from a time after the transport started
+func (tli *TLI) nextCycle(i int, start int64) (chain Chain, ok bool) {
+	c, wanted := tli.cycles[i]
+	if !wanted || (c.chain == nil && !c.failed) {
+		// the cycle plays again while the next one is being generated
+		return
+	}
+	delete(tli.cycles, i)
+	if c.failed || len(c.chain.Steps) == 0 || c.chain.MicrosecondsTotal <= 0 {
+		return
+	}
+	chain = *c.chain
+	current := tli.ChainsRendered[i]
+	chain.Muted = current.Muted
+	chain.Soloed = current.Soloed
+	chain.Stopped = current.Stopped
+	chain.StepCurrent = current.StepCurrent
+	chain.TimeOffset = start
+	tli.ChainsRendered[i] = chain
+	ok = true
+	return
+}
+
+// GenerateCycles generates the next cycle of every chain that wants one, it
+// runs the generators so it is called by the editor when Cycles rings
+func GenerateCycles() (err error) {
+	cyclesMu.Lock()
+	sequencers := []*TLI{}
+	for tli := range cyclesWanted {
+		sequencers = append(sequencers, tli)
+	}
+	cyclesWanted = make(map[*TLI]bool)
+	cyclesMu.Unlock()
+	for _, tli := range sequencers {
+		if errGenerate := tli.generateCycles(); errGenerate != nil && err == nil {
+			err = errGenerate
+		}
+	}
+	return
+}
+
+// generateCycles parses the loops of each chain that wants its next cycle again,
+// the sequencer keeps playing while the generators run
+func (tli *TLI) generateCycles() (err error) {
+	tli.mu.Lock()
+	wanted := make(map[int]*cycle)
+	for i, c := range tli.cycles {
+		if c.chain == nil && !c.failed {
+			wanted[i] = c
+		}
+	}
+	chains := append([]Chain{}, tli.Chains...)
+	loops := append([]Loop{}, tli.Loops...)
+	tempo, scale := tli.tempo, float64(tli.tempo)/float64(tli.Params.Tempo)
+	tli.mu.Unlock()
+
+	for i, c := range wanted {
+		generated, errGenerate := generateLoops(chains[i], loops)
+		tli.mu.Lock()
+		// the text may have changed while generating
+		if tli.cycles[i] == c {
+			if errGenerate == nil {
+				// the chain as it was parsed, before its steps were rendered
+				chain := Chain{
+					Name:       chains[i].Name,
+					NameLoop:   chains[i].NameLoop,
+					Outs:       chains[i].Outs,
+					VoiceLead:  chains[i].VoiceLead,
+					Transforms: chains[i].Transforms,
+					Tempo:      chains[i].Tempo,
+				}
+				// any warnings were given when the text was loaded
+				tli.renderChain(&chain, generated, tempo)
+				chain.scaleTempo(scale)
+				c.chain = &chain
+			}
+			c.failed = errGenerate != nil
+		}
+		tli.mu.Unlock()
+		if errGenerate != nil && err == nil {
+			err = errGenerate
+		}
+	}
+	return
+}
+
+// generateLoops parses the loops of a chain that generate again once more
+func generateLoops(chain Chain, loops []Loop) (generated []Loop, err error) {
+	generated = make([]Loop, len(loops))
+	for j, loop := range loops {
+		generated[j] = loop
+		if !loop.Regenerate {
+			continue
+		}
+		for _, name := range chain.NameLoop {
+			if name == loop.Name {
+				generated[j], err = loop.generate()
+				if err != nil {
+					return
+				}
+				break
+			}
+		}
+	}
+	return
+}
+
+// generate parses the lines of a loop again, which runs its generators again
+func (p Loop) generate() (loop Loop, err error) {
+	loop = LoopNew()
+	loop.Name = p.Name
+	loop.Drums = p.Drums
+	loop.drumMaps = p.drumMaps
+	loop.defs = p.defs
+	loop.script = p.script
+	loop.lines = p.lines
+	for _, line := range p.lines {
+		if err = loop.AddLine(line); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator(t *testing.T) {
+	defer func() {
+		RunGenerator = nil
+	}()
+	scripts := []string{}
+	RunGenerator = func(script string, fn string, args []string) (phrase string, err error) {
+		scripts = append(scripts, script)
+		switch fn {
+		case "up":
+			return "c4 d e " + strings.Join(args, " "), nil
+		case "riffs":
+			return "riff [riff]", nil
+		}
+		return "", fmt.Errorf("no lua function '%s'", fn)
+	}
+
+	text := `
+def riff = g4 a
+
+script
+function up(n)
+  return "c4 d e"
+end
+
+run a
+lua(up,f) ~
+
+run b
+lua(riffs,cycle=true)
+
+run c
+lua(nothing)
+`
+	tli, err := New(text)
+	assert.Nil(t, err)
+	assert.Equal(t, "function up(n)\n  return \"c4 d e\"\nend\n\n", tli.Script)
+	assert.Equal(t, tli.Script, scripts[0])
+
+	assert.Equal(t, 5, len(tli.Loops[0].Steps))
+	assert.Equal(t, 65, tli.Loops[0].Steps[3].Notes[0].Midi)
+	assert.True(t, tli.Loops[0].Steps[4].Notes[0].IsRest)
+	assert.False(t, tli.Loops[0].Regenerate)
+
+	assert.Equal(t, 6, len(tli.Loops[1].Steps))
+	assert.True(t, tli.Loops[1].Regenerate)
+	assert.Equal(t, 2, len(tli.Loops))
+
+	err = tli.Update(text)
+	assert.NotNil(t, err)
+}
+
+func TestGenerateCycles(t *testing.T) {
+	log.SetLevel("info")
+	defer func() {
+		RunGenerator = nil
+	}()
+	phrases := []string{"c4 d e f", "g4 a b c5", "e4 _ _ _"}
+	calls := 0
+	RunGenerator = func(script string, fn string, args []string) (phrase string, err error) {
+		phrase = phrases[calls%len(phrases)]
+		calls++
+		return
+	}
+	// a ring from another test is not wanted
+	select {
+	case <-Cycles:
+	default:
+	}
+
+	clock := NewManualClock()
+	device := &timedMidi{clock: clock}
+	dm := NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		return device, nil
+	}
+	tli, err := newWithDevices("run a\nlua(gen,cycle=true)\n\nrun b\nc2\n\ntie a\nout midi(synth)\n\ntie b\nout midi(synth)", nil, dm)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	tli.SetClock(clock)
+	tli.Play()
+	waitPlaying(tli)
+	defer tli.Stop()
+
+	// the next cycle is generated while the first one plays
+	clock.Advance(100 * time.Millisecond)
+	select {
+	case <-Cycles:
+	case <-time.After(time.Second):
+		t.Fatal("no cycle was wanted")
+	}
+	assert.Nil(t, GenerateCycles())
+	assert.Equal(t, 2, calls)
+	clock.Advance(1900*time.Millisecond + 100*time.Millisecond)
+	<-Cycles
+	assert.Nil(t, GenerateCycles())
+	assert.Equal(t, 3, calls)
+	clock.Advance(2 * time.Second)
+
+	notes := []string{}
+	for _, event := range device.events {
+		if strings.Contains(event, " on ") && !strings.HasSuffix(event, "c2") {
+			notes = append(notes, event)
+		}
+	}
+	// every cycle starts with the notes that were generated for it
+	assert.Equal(t, []string{
+		"10µs on c4", "500ms on d4", "1s on e4", "1.5s on f4",
+		"2s on g4", "2.5s on a4", "3s on b4", "3.5s on c5",
+		"4s on e4",
+	}, notes)
+	// the chain that does not generate again plays on
+	assert.Equal(t, 3, strings.Count(strings.Join(device.events, ","), "on c2"))
+}
+
+func TestGenerateCyclesUpdate(t *testing.T) {
+	log.SetLevel("info")
+	defer func() {
+		RunGenerator = nil
+	}()
+	RunGenerator = func(script string, fn string, args []string) (phrase string, err error) {
+		return "c4 d e f", nil
+	}
+	tli, err := NewOffline("run a\nlua(gen,cycle=true)\n\ntie a\nout midi(synth)")
+	assert.Nil(t, err)
+	tli.mu.Lock()
+	tli.wantCycle(0)
+	c := tli.cycles[0]
+	tli.mu.Unlock()
+	assert.NotNil(t, c)
+
+	// a cycle generated for text that was replaced is not played
+	assert.Nil(t, tli.Update("run a\nlua(gen,cycle=true) g4\n\ntie a\nout midi(synth)"))
+	assert.Nil(t, GenerateCycles())
+	assert.Nil(t, c.chain)
+	assert.Empty(t, tli.cycles)
+	// the loops keep their lines after an update
+	assert.Equal(t, []string{"lua(gen,cycle=true) g4"}, tli.Loops[0].lines)
+}
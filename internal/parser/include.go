@@ -0,0 +1,113 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Load reads a TLI file along with every file it includes with lines like
+// "include drums.tli", which are resolved relative to the including file.
+// Tuning files are resolved the same way. It returns the combined text, in
+// which every include line follows the text it included, and all the files
+// that were read.
+func Load(filename string) (text string, files []string, err error) {
+	text, _, files, err = LoadOrigins(filename)
+	return
+}
+
+// Origin is the line of a file that a line of loaded text came from
+type Origin struct {
+	File string
+	Line int
+}
+
+// LoadOrigins is like Load but also returns the origin of every line of
+// the combined text
+func LoadOrigins(filename string) (text string, origins []Origin, files []string, err error) {
+	filename, err = filepath.Abs(filename)
+	if err != nil {
+		return
+	}
+	text, err = loadFile(filename, []string{}, &files, &origins)
+	return
+}
+
+func loadFile(filename string, stack []string, files *[]string, origins *[]Origin) (text string, err error) {
+	for _, f := range stack {
+		if f == filename {
+			err = fmt.Errorf("include cycle: %s", strings.Join(append(stack, filename), " -> "))
+			return
+		}
+	}
+	// files that were already included somewhere else are only read once
+	for _, f := range *files {
+		if f == filename {
+			return
+		}
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	*files = append(*files, filename)
+	stack = append(stack, filename)
+
+	var sb strings.Builder
+	for y, line := range strings.Split(string(b), "\n") {
+		includeName, ok := parseInclude(line)
+		if !ok {
+			sb.WriteString(resolveTuning(line, filepath.Dir(filename)) + "\n")
+			*origins = append(*origins, Origin{filename, y})
+			continue
+		}
+		if !filepath.IsAbs(includeName) {
+			includeName = filepath.Join(filepath.Dir(filename), includeName)
+		}
+		includeText, errInclude := loadFile(includeName, stack, files, origins)
+		if errInclude != nil {
+			err = errInclude
+			return
+		}
+		// the include line stays after the included text so that the last
+		// block of the included file ends there
+		sb.WriteString(includeText + line + "\n")
+		*origins = append(*origins, Origin{filename, y})
+	}
+	text = sb.String()
+	return
+}
+
+// parseInclude returns the name of the file in an include line
+func parseInclude(line string) (filename string, ok bool) {
+	line = strings.TrimSpace(strings.Split(line, "//")[0])
+	if !strings.HasPrefix(line, "include ") {
+		return
+	}
+	filename = strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "include")), `"'`)
+	ok = filename != ""
+	return
+}
+
+// resolveTuning makes the files of a tuning line relative to a folder
+func resolveTuning(line string, dir string) string {
+	if !strings.HasPrefix(strings.TrimSpace(line), "tuning ") {
+		return line
+	}
+	scl, kbm, err := ParseTuning(strings.Split(line, "//")[0])
+	if err != nil {
+		return line
+	}
+	resolved := []string{}
+	for _, name := range []string{scl, kbm} {
+		if name == "" {
+			continue
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		resolved = append(resolved, name)
+	}
+	return "tuning " + strings.Join(resolved, " ")
+}
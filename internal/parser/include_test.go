@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "parts"), 0755)
+	os.WriteFile(filepath.Join(dir, "main.tli"), []byte("include parts/drums.tli\ninclude bass.tli\n\ntie a b\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "parts", "drums.tli"), []byte("run a\nc4 d\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bass.tli"), []byte("include parts/drums.tli\nrun b\nc2 e\n"), 0644)
+
+	text, files, err := Load(filepath.Join(dir, "main.tli"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(files))
+	assert.Equal(t, "run a\nc4 d\n\ninclude parts/drums.tli\ninclude parts/drums.tli\nrun b\nc2 e\n\ninclude bass.tli\n\ntie a b\n\n", text)
+
+	tli, err := New(text)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tli.Loops))
+	assert.Equal(t, []string{"a", "b"}, tli.Chains[0].NameLoop)
+	assert.Empty(t, tli.warnings)
+
+	// lines after an include do not join the last block of the included file
+	os.WriteFile(filepath.Join(dir, "main.tli"), []byte("include bass.tli\ng2 a\nrun c\nd3\n"), 0644)
+	text, _, err = Load(filepath.Join(dir, "main.tli"))
+	assert.Nil(t, err)
+	tli, err = New(text)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(tli.Loops))
+	assert.Equal(t, 2, len(tli.Loops[1].Steps))
+	assert.Equal(t, []string{"'g2 a' is not in a block, start a block after 'include bass.tli'"}, tli.warnings)
+
+	// cycles are an error
+	os.WriteFile(filepath.Join(dir, "bass.tli"), []byte("include main.tli\n"), 0644)
+	_, _, err = Load(filepath.Join(dir, "main.tli"))
+	assert.NotNil(t, err)
+}
+
+func TestLoadOrigins(t *testing.T) {
+	dir := t.TempDir()
+	main, bass := filepath.Join(dir, "main.tli"), filepath.Join(dir, "bass.tli")
+	os.WriteFile(main, []byte("tie a\ninclude bass.tli\ntie b"), 0644)
+	os.WriteFile(bass, []byte("run b\nc2\ntie a\n"), 0644)
+
+	text, origins, _, err := LoadOrigins(main)
+	assert.Nil(t, err)
+	lines := strings.Split(text, "\n")
+	assert.Equal(t, []Origin{{main, 0}, {bass, 0}, {bass, 1}, {bass, 2}, {bass, 3}, {main, 1}, {main, 2}}, origins)
+
+	// the chain in the included file is the second one named "a"
+	assert.Equal(t, "a (2)", ChainAt(lines[:4]))
+	assert.Equal(t, "a", ChainAt(lines[:1]))
+	assert.Equal(t, "", ChainAt(lines[:3]))
+	assert.Equal(t, "", ChainAt(lines[:6]))
+	assert.Equal(t, "b", ChainAt(lines[:7]))
+}
+
+func TestFindProject(t *testing.T) {
+	dir := t.TempDir()
+	project, err := FindProject(filepath.Join(dir, "main.tli"))
+	assert.Nil(t, err)
+	assert.Nil(t, project)
+
+	os.WriteFile(filepath.Join(dir, ProjectFilename), []byte("entry: main.tli\ndevices:\n  synth: UM-ONE MIDI 1\n"), 0644)
+	project, err = FindProject(filepath.Join(dir, "drums.tli"))
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "main.tli"), project.Entry)
+	assert.Equal(t, "UM-ONE MIDI 1", project.Devices["synth"])
+}
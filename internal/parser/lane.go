@@ -0,0 +1,222 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LaneShapes are how a lane moves from one value to the next: it jumps,
+// moves in a straight line, starts slow and speeds up, or eases in and out
+var LaneShapes = []string{"step", "lin", "exp", "smooth"}
+
+// defaultLaneResolution is how many beats apart the values of a lane
+// that moves between its points are sent
+const defaultLaneResolution = 0.125
+
+// Lane is a sequence of values over beats, like a loop of notes, that
+// automates a parameter of an output while a chain plays
+type Lane struct {
+	Name   string      `json:"name"`
+	Shape  string      `json:"shape"`
+	Points []LanePoint `json:"points"`
+	Beats  float64     `json:"beats"`
+	// beatsPerLine is how many beats each line of values lasts
+	beatsPerLine int
+}
+
+// LanePoint is a value of a lane and the beat it is at
+type LanePoint struct {
+	Beat  float64 `json:"beat"`
+	Value float64 `json:"value"`
+}
+
+// LaneEvent is a value of a lane that is sent at a time of a chain
+type LaneEvent struct {
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// LaneRoute sends the values of a lane to an output, it comes from an out
+// line of a tie block with a lane, like "out cc(synth,74,lane=cutoff)"
+type LaneRoute struct {
+	Lane   string      `json:"lane"`
+	Out    Function    `json:"out"`
+	Events []LaneEvent `json:"events"`
+}
+
+// ParseLane parses the line that starts a lane block, like "lane cutoff(lin,b8)",
+// which can have its first values after a colon like "lane cutoff: 0 32 64 127"
+func ParseLane(line string) (lane Lane, err error) {
+	header, values, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "lane")), ":")
+	fn, err := ParseFunction(strings.TrimSpace(header))
+	if err != nil {
+		return
+	}
+	if fn.Name == "" {
+		err = fmt.Errorf("lane needs a name, like 'lane cutoff: 0 64 127'")
+		return
+	}
+	lane = Lane{Name: fn.Name, Shape: "step", beatsPerLine: 4}
+	for _, arg := range fn.Args {
+		switch {
+		case isLaneShape(arg.Value):
+			lane.Shape = arg.Value
+		case strings.HasPrefix(arg.Value, "b"):
+			lane.beatsPerLine, err = strconv.Atoi(arg.Value[1:])
+			if err != nil || lane.beatsPerLine <= 0 {
+				err = fmt.Errorf("bad beats '%s' of lane '%s'", arg.Value, lane.Name)
+				return
+			}
+		default:
+			err = fmt.Errorf("unknown option '%s' of lane '%s'", arg.Value, lane.Name)
+			return
+		}
+	}
+	if strings.TrimSpace(values) != "" {
+		err = lane.AddLine(values)
+	}
+	return
+}
+
+func isLaneShape(s string) bool {
+	for _, shape := range LaneShapes {
+		if s == shape {
+			return true
+		}
+	}
+	return false
+}
+
+// AddLine adds a line of values that share the beats of the line,
+// a "_" holds the value before it for longer
+func (l *Lane) AddLine(line string) (err error) {
+	tokens := strings.Fields(line)
+	for _, token := range tokens {
+		beats := float64(l.beatsPerLine) / float64(len(tokens))
+		if token != HOLD {
+			value, errParse := strconv.ParseFloat(token, 64)
+			if errParse != nil {
+				return fmt.Errorf("bad value '%s' of lane '%s'", token, l.Name)
+			}
+			l.Points = append(l.Points, LanePoint{Beat: l.Beats, Value: value})
+		}
+		l.Beats += beats
+	}
+	return
+}
+
+// At returns the value of a lane at a beat, the lane starts over when it ends
+func (l Lane) At(beat float64) float64 {
+	if len(l.Points) == 0 || l.Beats <= 0 {
+		return 0
+	}
+	beat = math.Mod(beat, l.Beats)
+	// a lane that has not got to its first point is still on its last
+	i := len(l.Points) - 1
+	for j, point := range l.Points {
+		if point.Beat <= beat {
+			i = j
+		}
+	}
+	from, to := l.Points[i], l.Points[(i+1)%len(l.Points)]
+	length := to.Beat - from.Beat
+	if length <= 0 {
+		length += l.Beats
+	}
+	position := beat - from.Beat
+	if position < 0 {
+		position += l.Beats
+	}
+	t := position / length
+	switch l.Shape {
+	case "lin":
+	case "exp":
+		t = t * t
+	case "smooth":
+		t = t * t * (3 - 2*t)
+	default:
+		t = 0
+	}
+	return from.Value + (to.Value-from.Value)*t
+}
+
+// laneEvents returns the values a lane sends over the beats of a chain, every
+// point of a lane that steps and every so many beats of a lane that moves
+func (c *Chain) laneEvents(lane Lane, resolution float64) (events []LaneEvent) {
+	beats := []float64{}
+	if lane.Shape == "step" {
+		for offset := 0.0; offset < c.BeatsTotal && lane.Beats > 0; offset += lane.Beats {
+			for _, point := range lane.Points {
+				if offset+point.Beat < c.BeatsTotal {
+					beats = append(beats, offset+point.Beat)
+				}
+			}
+		}
+		if len(beats) == 0 || beats[0] > 0 {
+			beats = append([]float64{0}, beats...)
+		}
+	} else {
+		for beat := 0.0; beat < c.BeatsTotal; beat += resolution {
+			beats = append(beats, beat)
+		}
+	}
+	for _, beat := range beats {
+		value := lane.At(beat)
+		// values that do not change are not sent again
+		if len(events) > 0 && events[len(events)-1].Value == value {
+			continue
+		}
+		events = append(events, LaneEvent{Time: c.microseconds(beat), Value: value})
+	}
+	return
+}
+
+// routeLanes works out what each lane of a chain sends, lanes start over with
+// the chain. It returns a warning for each lane that does not exist.
+func (c *Chain) routeLanes(lanes []Lane) (warnings []string) {
+	for i, route := range c.LaneRoutes {
+		found := false
+		for _, lane := range lanes {
+			if lane.Name != route.Lane {
+				continue
+			}
+			found = true
+			resolution := defaultLaneResolution
+			if res, errRes := route.Out.GetString("res"); errRes == nil {
+				var errRate error
+				resolution, errRate = parseRate(res)
+				if errRate != nil {
+					warnings = append(warnings, fmt.Sprintf("bad resolution '%s' of lane '%s', should be like '16th'", res, route.Lane))
+					resolution = defaultLaneResolution
+				}
+			}
+			c.LaneRoutes[i].Events = c.laneEvents(lane, resolution)
+		}
+		if !found {
+			warnings = append(warnings, fmt.Sprintf("lane '%s' not found", route.Lane))
+		}
+	}
+	return
+}
+
+// crossed returns the value of the last event of a route that was passed
+// going from one time of its chain to another, which can start the chain over
+func (r LaneRoute) crossed(from int64, to int64) (value float64, ok bool) {
+	for _, event := range r.Events {
+		if (event.Time > from || to < from) && event.Time <= to {
+			value, ok = event.Value, true
+		}
+	}
+	if ok || to >= from {
+		return
+	}
+	// the chain started over without passing an event since its start
+	for _, event := range r.Events {
+		if event.Time > from {
+			value, ok = event.Value, true
+		}
+	}
+	return
+}
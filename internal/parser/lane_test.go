@@ -0,0 +1,158 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func TestParseLane(t *testing.T) {
+	for _, test := range []struct {
+		line   string
+		shape  string
+		points []LanePoint
+		beats  float64
+		err    bool
+	}{
+		{"lane cutoff: 0 32 64 127", "step", []LanePoint{{0, 0}, {1, 32}, {2, 64}, {3, 127}}, 4, false},
+		{"lane cutoff(lin): 0 127", "lin", []LanePoint{{0, 0}, {2, 127}}, 4, false},
+		{"lane cutoff(exp,b8): 0 _ 5 _", "exp", []LanePoint{{0, 0}, {4, 5}}, 8, false},
+		{"lane cutoff(smooth)", "smooth", nil, 0, false},
+		{"lane cutoff: 0 -1.5", "step", []LanePoint{{0, 0}, {2, -1.5}}, 4, false},
+		{"lane: 0 127", "", nil, 0, true},
+		{"lane cutoff(wobbly): 0 127", "", nil, 0, true},
+		{"lane cutoff(b0): 0 127", "", nil, 0, true},
+		{"lane cutoff: 0 c4", "", nil, 0, true},
+	} {
+		lane, err := ParseLane(test.line)
+		if test.err {
+			assert.NotNil(t, err, test.line)
+			continue
+		}
+		assert.Nil(t, err, test.line)
+		assert.Equal(t, "cutoff", lane.Name, test.line)
+		assert.Equal(t, test.shape, lane.Shape, test.line)
+		assert.Equal(t, test.points, lane.Points, test.line)
+		assert.Equal(t, test.beats, lane.Beats, test.line)
+	}
+}
+
+func TestLaneAt(t *testing.T) {
+	for _, test := range []struct {
+		line   string
+		beats  []float64
+		values []float64
+	}{
+		{"lane a: 0 32 64 127", []float64{0, 0.5, 1, 3.9, 4, 5}, []float64{0, 0, 32, 127, 0, 32}},
+		{"lane a(lin): 0 100", []float64{0, 1, 2, 3, 4}, []float64{0, 50, 100, 50, 0}},
+		{"lane a(exp): 0 100", []float64{0, 1, 2}, []float64{0, 25, 100}},
+		{"lane a(smooth): 0 100", []float64{0, 0.5, 1, 1.5, 2}, []float64{0, 15.625, 50, 84.375, 100}},
+		// a lane that starts with a hold stays on its last value
+		{"lane a: _ 10 _ 20", []float64{0, 1, 3}, []float64{20, 10, 20}},
+		{"lane a(lin): _ 0 _ 80", []float64{0, 1, 2, 3}, []float64{40, 0, 40, 80}},
+	} {
+		lane, err := ParseLane(test.line)
+		assert.Nil(t, err, test.line)
+		values := []float64{}
+		for _, beat := range test.beats {
+			values = append(values, lane.At(beat))
+		}
+		assert.InDeltaSlice(t, test.values, values, 0.001, test.line)
+	}
+}
+
+func TestLaneRoutes(t *testing.T) {
+	log.SetLevel("info")
+	for _, test := range []struct {
+		text   string
+		out    string
+		events []LaneEvent
+	}{
+		// a lane that steps sends each of its values, and starts over with the chain
+		{"lane cutoff: 0 64\n\nrun a\nc4 d4 e4 f4\ng4 a4 b4 c5\n\ntie a\nout cc(synth,74,lane=cutoff)",
+			"cc", []LaneEvent{{0, 0}, {1000000, 64}, {2000000, 0}, {3000000, 64}}},
+		// values that do not change are not sent again
+		{"lane cutoff: 5 5 _ 7\n\nrun a\nc4 d4 e4 f4\n\ntie a\nout cc(synth,74,lane=cutoff)",
+			"cc", []LaneEvent{{0, 5}, {1500000, 7}}},
+		// a lane that moves sends a value every so many beats
+		{"lane wobble(lin): 0 4\n\nrun a\nc4 d4 e4 f4\n\ntie a\nout crow(2,lane=wobble,res=4th)",
+			"crow", []LaneEvent{{0, 0}, {500000, 2}, {1000000, 4}, {1500000, 2}}},
+		{"lane cutoff(lin,b2): 0 1\n\nrun a\nc4 d4\n\ntie a\nout osc(127.0.0.1:57120,/cutoff,lane=cutoff,res=8th)",
+			"osc", []LaneEvent{{0, 0}, {250000, 0.5}, {500000, 1}, {750000, 0.5}, {1000000, 0}, {1250000, 0.5}, {1500000, 1}, {1750000, 0.5}}},
+		// the times of lanes follow the tempo of the chain
+		{"set bpm 60\n\nlane cutoff: 0 1\n\nrun a\nc4 d4 e4 f4\n\ntie a\nout cc(synth,1,lane=cutoff)",
+			"cc", []LaneEvent{{0, 0}, {2000000, 1}}},
+	} {
+		tli, err := NewOffline(test.text)
+		assert.Nil(t, err, test.text)
+		assert.Empty(t, tli.warnings, test.text)
+		chain := tli.ChainsRendered[0]
+		// outs with a lane do not play notes
+		assert.Empty(t, chain.OutFns, test.text)
+		assert.Equal(t, 1, len(chain.LaneRoutes), test.text)
+		assert.Equal(t, test.out, chain.LaneRoutes[0].Out.Name, test.text)
+		assert.Equal(t, test.events, chain.LaneRoutes[0].Events, test.text)
+	}
+
+	tli, err := NewOffline("run a\nc4 d4\n\ntie a\nout cc(synth,74,lane=cutoff)")
+	assert.Nil(t, err)
+	assert.Contains(t, tli.warnings, "lane 'cutoff' not found")
+
+	tli, err = NewOffline("lane cutoff: 0 1\n\nrun a\nc4 d4\n\ntie a\nout cc(synth,74,lane=cutoff,res=slow)")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tli.warnings))
+}
+
+func TestLaneCrossed(t *testing.T) {
+	route := LaneRoute{Events: []LaneEvent{{0, 1}, {100, 2}, {200, 3}}}
+	for _, test := range []struct {
+		from, to int64
+		value    float64
+		ok       bool
+	}{
+		{-1, 0, 1, true},
+		{0, 50, 0, false},
+		{50, 150, 2, true},
+		{50, 250, 3, true},
+		// the chain started over
+		{250, 10, 1, true},
+		{150, 10, 1, true},
+	} {
+		value, ok := route.crossed(test.from, test.to)
+		assert.Equal(t, test.ok, ok, test)
+		assert.Equal(t, test.value, value, test)
+	}
+	// a chain that starts over before the first event still sends the last one
+	route = LaneRoute{Events: []LaneEvent{{100, 2}, {200, 3}}}
+	value, ok := route.crossed(150, 10)
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, value)
+}
+
+func TestLaneMidi(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := NewOffline("lane cutoff: 0 64\n\nrun a\nc4 d4 e4 f4\n\ntie a\nout midi(synth)\nout cc(synth,74,ch=2,lane=cutoff)")
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, tli.WriteMidi(&buf))
+	s, err := smf.ReadFrom(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	ticks := []int64{}
+	values := []uint8{}
+	tick := int64(0)
+	for _, event := range s.Tracks[1] {
+		tick += int64(event.Delta)
+		var channel, controller, value uint8
+		if event.Message.GetControlChange(&channel, &controller, &value) {
+			assert.Equal(t, uint8(2), channel)
+			assert.Equal(t, uint8(74), controller)
+			ticks = append(ticks, tick)
+			values = append(values, value)
+		}
+	}
+	assert.Equal(t, []int64{0, 1920}, ticks)
+	assert.Equal(t, []uint8{0, 64}, values)
+}
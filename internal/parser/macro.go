@@ -0,0 +1,398 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maximum depth of defs that use other defs, anything deeper is
+// assumed to be a def that refers to itself
+const macroDepthMax = 16
+
+var macroNameRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)([+-]\d+)?$`)
+
+var noteNamesSharp = []string{"c", "c#", "d", "d#", "e", "f", "f#", "g", "g#", "a", "a#", "b"}
+
+// ParseDef parses a line like "def riff = c4 e g [a b]" into
+// the name of the def and the phrase it stands for
+func ParseDef(line string) (name string, phrase string, err error) {
+	line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "def"))
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		err = fmt.Errorf("def '%s' needs an '='", line)
+		return
+	}
+	name = strings.TrimSpace(parts[0])
+	phrase = strings.TrimSpace(parts[1])
+	if !macroNameRegex.MatchString(name) || strings.ContainsAny(name, "+-") {
+		err = fmt.Errorf("bad def name '%s'", name)
+	}
+	return
+}
+
+// ExpandMacros replaces every use of a def in the line with its phrase. A def can be
+// transposed with "riff+5" or "riff-12", reversed with "rev(riff)" and rotated
+// with "rot(riff,2)", and these can be combined like "rot(rev(riff+5),1)".
+func ExpandMacros(input string, defs map[string]string) (result string, err error) {
+	return expandMacros(input, defs, 0)
+}
+
+func expandMacros(input string, defs map[string]string, depth int) (result string, err error) {
+	if len(defs) == 0 {
+		return input, nil
+	}
+	if depth > macroDepthMax {
+		err = fmt.Errorf("def is recursive in '%s'", input)
+		return
+	}
+	// remove any space around a multiplication sign so it stays with its token
+	for strings.Contains(input, " *") || strings.Contains(input, "* ") {
+		input = strings.ReplaceAll(input, " *", "*")
+		input = strings.ReplaceAll(input, "* ", "*")
+	}
+
+	var sb strings.Builder
+	for _, token := range splitMacroTokens(input) {
+		if token == " " || token == "[" || token == "]" {
+			sb.WriteString(token)
+			continue
+		}
+		expr, suffix := token, ""
+		if i := strings.Index(token, "*"); i > 0 && strings.Count(token[:i], "(") == strings.Count(token[:i], ")") {
+			expr, suffix = token[:i], token[i:]
+		}
+		phrase, ok, errEval := evalMacro(expr, defs, depth)
+		if errEval != nil {
+			err = errEval
+			return
+		}
+		if !ok {
+			sb.WriteString(token)
+		} else if suffix != "" {
+			sb.WriteString("[" + phrase + "]" + suffix)
+		} else {
+			sb.WriteString(phrase)
+		}
+	}
+	result = sb.String()
+	return
+}
+
+// evalMacro evaluates a single use of a def, returning ok=false
+// if the expression does not refer to a def
+func evalMacro(expr string, defs map[string]string, depth int) (phrase string, ok bool, err error) {
+	if strings.HasPrefix(expr, "rev(") && strings.HasSuffix(expr, ")") {
+		phrase, ok, err = evalMacro(strings.TrimSpace(expr[4:len(expr)-1]), defs, depth)
+		if ok && err == nil {
+			phrase = reversePhrase(anchorPhrase(phrase))
+		}
+		return
+	}
+	if strings.HasPrefix(expr, "rot(") && strings.HasSuffix(expr, ")") {
+		args := strings.Split(expr[4:len(expr)-1], ",")
+		amount := 1
+		if len(args) > 1 {
+			amount, err = strconv.Atoi(strings.TrimSpace(args[len(args)-1]))
+			if err != nil {
+				err = fmt.Errorf("bad rotation in '%s'", expr)
+				return
+			}
+			args = args[:len(args)-1]
+		}
+		phrase, ok, err = evalMacro(strings.TrimSpace(strings.Join(args, ",")), defs, depth)
+		if ok && err == nil {
+			phrase = rotatePhrase(anchorPhrase(phrase), amount)
+		}
+		return
+	}
+
+	match := macroNameRegex.FindStringSubmatch(expr)
+	if match == nil {
+		return
+	}
+	def, exists := defs[match[1]]
+	if !exists {
+		return
+	}
+	ok = true
+	phrase, err = expandMacros(def, defs, depth+1)
+	if err != nil {
+		return
+	}
+	if match[2] != "" {
+		semitones, _ := strconv.Atoi(match[2])
+		phrase = transposePhrase(phrase, semitones)
+	}
+	return
+}
+
+// splitMacroTokens splits a line into tokens, spaces and brackets
+// while keeping anything inside parentheses together
+func splitMacroTokens(input string) (tokens []string) {
+	parens := 0
+	start := 0
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '(':
+			parens++
+		case ')':
+			parens--
+		case ' ', '[', ']':
+			if parens > 0 {
+				continue
+			}
+			if i > start {
+				tokens = append(tokens, input[start:i])
+			}
+			tokens = append(tokens, input[i:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(input) {
+		tokens = append(tokens, input[start:])
+	}
+	return
+}
+
+// splitEntities splits a phrase into its top-level entities,
+// where a bracketed group counts as one entity
+func splitEntities(phrase string) (entities []string) {
+	brackets := 0
+	var sb strings.Builder
+	for _, token := range splitMacroTokens(phrase) {
+		switch token {
+		case "[":
+			brackets++
+		case "]":
+			brackets--
+		case " ":
+			if brackets == 0 {
+				if sb.Len() > 0 {
+					entities = append(entities, sb.String())
+					sb.Reset()
+				}
+				continue
+			}
+		}
+		sb.WriteString(token)
+	}
+	if sb.Len() > 0 {
+		entities = append(entities, sb.String())
+	}
+	return
+}
+
+// reversePhrase reverses the order of the entities, including
+// inside of any bracketed groups
+func reversePhrase(phrase string) string {
+	entities := splitEntities(phrase)
+	for i, j := 0, len(entities)-1; i < j; i, j = i+1, j-1 {
+		entities[i], entities[j] = entities[j], entities[i]
+	}
+	for i, entity := range entities {
+		if strings.HasPrefix(entity, "[") {
+			end := strings.LastIndex(entity, "]")
+			entities[i] = "[" + reversePhrase(entity[1:end]) + entity[end:]
+		}
+	}
+	return strings.Join(entities, " ")
+}
+
+// rotatePhrase moves the first entities of the phrase to the end,
+// a negative amount rotates the other way
+func rotatePhrase(phrase string, amount int) string {
+	entities := splitEntities(phrase)
+	if len(entities) == 0 {
+		return phrase
+	}
+	amount = ((amount % len(entities)) + len(entities)) % len(entities)
+	return strings.Join(append(entities[amount:], entities[:amount]...), " ")
+}
+
+// anchorPhrase gives every note and chord of the phrase the octave it plays
+// in, so that it stays there when the phrase is put in another order. Notes
+// are placed like the notes of a loop that starts with the phrase.
+func anchorPhrase(phrase string) string {
+	var sb strings.Builder
+	near := LoopNew().lastMidiNote
+	for _, token := range splitMacroTokens(phrase) {
+		if token == " " || token == "[" || token == "]" {
+			sb.WriteString(token)
+			continue
+		}
+		name, rest := token, ""
+		if i := strings.IndexAny(token, "(*:"); i >= 0 {
+			name, rest = token[:i], token[i:]
+		}
+		if len(name) > 0 && name[0] >= 'A' && name[0] <= 'G' {
+			if notes, err := ParseChord(name, near); err == nil && len(notes) > 0 {
+				name = transposeChord(name, 0, near)
+				near = notes[len(notes)-1].Midi
+			}
+		} else if len(name) > 0 && name[0] >= 'a' && name[0] <= 'g' {
+			if notes, err := ParseMidi(name, near); err == nil && len(notes) > 0 {
+				name = ""
+				for _, note := range notes {
+					name += noteNamesSharp[note.Midi%12] + strconv.Itoa(note.Midi/12-1)
+				}
+				near = notes[len(notes)-1].Midi
+			}
+		}
+		sb.WriteString(name + rest)
+	}
+	return sb.String()
+}
+
+// transposePhrase shifts every note and chord in the phrase by some semitones.
+// Chords and the first note without an octave get an octave so they move by
+// octaves too, they are placed like the notes of a loop that starts with the phrase.
+func transposePhrase(phrase string, semitones int) string {
+	var sb strings.Builder
+	// near is the last note of the phrase before it is transposed, like
+	// the last note of a loop, and anchored is whether there was one
+	near := LoopNew().lastMidiNote
+	anchored := false
+	for _, token := range splitMacroTokens(phrase) {
+		if token == " " || token == "[" || token == "]" {
+			sb.WriteString(token)
+			continue
+		}
+		// decorators, durations and multiplication stay the same
+		name, rest := token, ""
+		if i := strings.IndexAny(token, "(*:"); i >= 0 {
+			name, rest = token[:i], token[i:]
+		}
+		notes, err := ParseChord(name, near)
+		if err != nil {
+			notes, err = ParseMidi(name, near)
+		}
+		if len(name) > 0 && name[0] >= 'A' && name[0] <= 'G' {
+			name = transposeChord(name, semitones, near)
+		} else {
+			name = transposeNotes(name, semitones, near, anchored)
+		}
+		if err == nil && len(notes) > 0 {
+			near = notes[len(notes)-1].Midi
+			anchored = true
+		}
+		sb.WriteString(name + rest)
+	}
+	return sb.String()
+}
+
+// transposeChord transposes the root and the bass note of a chord like "Am7/E;3",
+// the octave of the chord is the one it would have after the note near it
+func transposeChord(chord string, semitones int, near int) string {
+	octave := near/12 - 1
+	if i := strings.Index(chord, ";"); i >= 0 {
+		n, err := strconv.Atoi(chord[i+1:])
+		if err != nil {
+			return chord
+		}
+		chord, octave = chord[:i], n
+	}
+	bass := ""
+	if i := strings.Index(chord, "/"); i >= 0 {
+		chord, bass = chord[:i], chord[i+1:]
+	}
+	root, rest := splitNoteName(chord)
+	pc, ok := pitchClass(root)
+	if !ok {
+		if bass != "" {
+			chord += "/" + bass
+		}
+		return chord + ";" + strconv.Itoa(octave)
+	}
+	chord = strings.ToUpper(noteNamesSharp[(pc+semitones%12+12)%12][:1]) + noteNamesSharp[(pc+semitones%12+12)%12][1:] + rest
+	if bass != "" {
+		if pcBass, okBass := pitchClass(bass); okBass {
+			bass = noteNamesSharp[(pcBass+semitones%12+12)%12]
+			bass = strings.ToUpper(bass[:1]) + bass[1:]
+		}
+		chord += "/" + bass
+	}
+	return chord + ";" + strconv.Itoa(octave+floorDiv(pc+semitones, 12))
+}
+
+// transposeNotes transposes one or more notes like "c4eg", notes with an octave
+// keep an octave while notes without stay relative. The first note of a phrase
+// without an octave gets the octave it would have after the note near it.
+func transposeNotes(notes string, semitones int, near int, anchored bool) string {
+	var sb strings.Builder
+	for len(notes) > 0 {
+		if notes[0] < 'a' || notes[0] > 'g' {
+			// rest, legato or something else
+			return sb.String() + notes
+		}
+		name, rest := splitNoteName(notes)
+		pc, _ := pitchClass(name)
+		octaveString := ""
+		for len(rest) > 0 && (rest[0] == '-' || (rest[0] >= '0' && rest[0] <= '9')) {
+			octaveString += rest[:1]
+			rest = rest[1:]
+		}
+		midi := -1
+		if octave, err := strconv.Atoi(octaveString); err == nil {
+			midi = (octave+1)*12 + pc
+		} else if !anchored {
+			if found, errFind := ParseMidi(name, near); errFind == nil {
+				midi = found[0].Midi
+			}
+		}
+		if midi >= 0 {
+			midi += semitones
+			sb.WriteString(noteNamesSharp[(midi%12+12)%12] + strconv.Itoa(floorDiv(midi, 12)-1))
+		} else {
+			sb.WriteString(noteNamesSharp[(pc+semitones%12+12)%12])
+		}
+		anchored = true
+		notes = rest
+	}
+	return sb.String()
+}
+
+// splitNoteName splits the note name with its accidental from the rest
+func splitNoteName(s string) (name string, rest string) {
+	if len(s) == 0 {
+		return
+	}
+	n := 1
+	if strings.HasPrefix(s[1:], "#") || strings.HasPrefix(s[1:], "s") {
+		n = 2
+	} else if strings.HasPrefix(s[1:], "♭") {
+		n = 1 + len("♭")
+	} else if s[0] >= 'A' && s[0] <= 'G' && strings.HasPrefix(s[1:], "b") {
+		n = 2
+	}
+	return s[:n], s[n:]
+}
+
+// pitchClass returns 0-11 for a note name like "c", "F#", "eb"
+func pitchClass(name string) (pc int, ok bool) {
+	name = strings.ToLower(name)
+	if len(name) == 0 {
+		return
+	}
+	pc = strings.Index("c d ef g a b", name[:1])
+	if pc < 0 || name[0] == ' ' {
+		return
+	}
+	ok = true
+	switch name[1:] {
+	case "#", "s":
+		pc++
+	case "b", "♭":
+		pc--
+	}
+	pc = (pc + 12) % 12
+	return
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 && a%b != 0 {
+		return a/b - 1
+	}
+	return a / b
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandMacros(t *testing.T) {
+	defs := map[string]string{
+		"riff":  "c4 e g [a b]",
+		"chord": "Am7/E;3 ~ C",
+		"verse": "riff riff+2",
+		"self":  "c self",
+		"up":    "c e g",
+	}
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"a b c", "a b c"},
+		{"riff", "c4 e g [a b]"},
+		{"riff d", "c4 e g [a b] d"},
+		{"[riff ~]", "[c4 e g [a b] ~]"},
+		{"riff*2", "[c4 e g [a b]]*2"},
+		{"riff+5", "f4 a c [d e]"},
+		{"riff-1", "b3 d# f# [g# a#]"},
+		{"rev(riff)", "[b4 a4] g4 e4 c4"},
+		{"rot(riff,2)", "g4 [a4 b4] c4 e4"},
+		{"rot(riff, -1)", "[a4 b4] c4 e4 g4"},
+		{"rot(rev(riff+5),1)", "c5 a4 f4 [e5 d5]"},
+		{"chord+2", "Bm7/F#;3 ~ D;4"},
+		{"chord+12", "Am7/E;4 ~ C;5"},
+		{"chord-3", "F#m7/C#;3 ~ A;3"},
+		{"up+12", "c5 e g"},
+		{"up-12", "c3 e g"},
+		{"up+5", "f4 a c"},
+		{"verse", "c4 e g [a b] d4 f# a [b c#]"},
+		{"riff(v80)", "riff(v80)"},
+		{"rev(nothing)", "rev(nothing)"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("line(%s)", test.line), func(t *testing.T) {
+			result, err := ExpandMacros(test.line, defs)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+
+	_, err := ExpandMacros("self", defs)
+	assert.NotNil(t, err)
+}
+
+func TestParseDef(t *testing.T) {
+	name, phrase, err := ParseDef("def riff = c4 e g [a b]")
+	assert.Nil(t, err)
+	assert.Equal(t, "riff", name)
+	assert.Equal(t, "c4 e g [a b]", phrase)
+
+	_, _, err = ParseDef("def riff c4 e g")
+	assert.NotNil(t, err)
+	_, _, err = ParseDef("def riff+2 = c4 e g")
+	assert.NotNil(t, err)
+}
+
+func TestDefInLoop(t *testing.T) {
+	text := `
+def riff = c4 e g
+
+run a
+riff riff+12
+`
+	tli, err := New(text)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(tli.Loops[0].Steps))
+	assert.Equal(t, 60, tli.Loops[0].Steps[0].Notes[0].Midi)
+	assert.Equal(t, 72, tli.Loops[0].Steps[3].Notes[0].Midi)
+	assert.Equal(t, 79, tli.Loops[0].Steps[5].Notes[0].Midi)
+
+	// reversed and rotated notes keep the octaves they play in
+	tli, err = New("def riff = c4 e g a b\ndef ch = Am;3 e\n\nrun a\nrev(riff)\n\nrun b\nrot(riff,2)\n\nrun c\nrev(ch)")
+	assert.Nil(t, err)
+	for i, expected := range [][]int{{71, 69, 67, 64, 60}, {67, 69, 71, 60, 64}, {64, 57, 60, 64}} {
+		midis := []int{}
+		for _, step := range tli.Loops[i].Steps {
+			for _, note := range step.Notes {
+				midis = append(midis, note.Midi)
+			}
+		}
+		assert.Equal(t, expected, midis, tli.Loops[i].Name)
+	}
+
+	// chords and notes without an octave move by octaves too
+	for _, test := range []struct {
+		def       string
+		semitones string
+	}{
+		{"Am;3 e g", "+12"},
+		{"Am;3 e g", "-12"},
+		{"A;3", "+12"},
+		{"A;3", "-12"},
+		{"A;3", "+5"},
+		{"Am e g", "+12"},
+		{"c e g", "+12"},
+		{"c e g", "-12"},
+		{"e g c", "+7"},
+		{"c ~ Am", "-12"},
+	} {
+		text := "def riff = " + test.def + "\n\nrun a\nriff\n\nrun b\nriff" + test.semitones
+		tli, err := New(text)
+		assert.Nil(t, err, text)
+		semitones, _ := strconv.Atoi(test.semitones)
+		original, transposed := tli.Loops[0].Steps, tli.Loops[1].Steps
+		assert.Equal(t, len(original), len(transposed), text)
+		for i := range original {
+			assert.Equal(t, len(original[i].Notes), len(transposed[i].Notes), text)
+			for j := range original[i].Notes {
+				if original[i].Notes[j].IsRest {
+					continue
+				}
+				assert.Equal(t, original[i].Notes[j].Midi+semitones, transposed[i].Notes[j].Midi, text)
+			}
+		}
+	}
+}
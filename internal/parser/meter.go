@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Meter is the time signature that steps are counted in bars and beats of,
+// like 7/8 which has 7 beats of an eighth note in a bar
+type Meter struct {
+	Beats int `json:"beats"`
+	Unit  int `json:"unit"`
+}
+
+// DefaultMeter is the meter when it is not set
+var DefaultMeter = Meter{Beats: 4, Unit: 4}
+
+// ParseMeter parses a meter like "7/8"
+func ParseMeter(s string) (meter Meter, err error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) == 2 {
+		meter.Beats, err = strconv.Atoi(parts[0])
+		if err == nil {
+			meter.Unit, err = strconv.Atoi(parts[1])
+		}
+	}
+	if len(parts) != 2 || err != nil || meter.Beats <= 0 || meter.Unit <= 0 || meter.Unit&(meter.Unit-1) != 0 {
+		err = fmt.Errorf("bad meter '%s', should be like '7/8'", s)
+	}
+	return
+}
+
+func (m Meter) String() string {
+	return fmt.Sprintf("%d/%d", m.Beats, m.Unit)
+}
+
+// BarBeats returns how many quarter note beats are in a bar
+func (m Meter) BarBeats() float64 {
+	return float64(m.Beats) * 4 / float64(m.Unit)
+}
+
+// Position returns the bar and the beat of the bar, in beats of the meter,
+// that a number of quarter note beats is at. Both count from 1.
+func (m Meter) Position(beats float64) (bar int, beat float64) {
+	// a little is added so that steps right on a bar line are in the bar
+	bars := math.Floor(beats/m.BarBeats() + 1e-9)
+	bar = int(bars) + 1
+	beat = math.Max(0, beats-bars*m.BarBeats())*float64(m.Unit)/4 + 1
+	return
+}
+
+// durations are the beats of the notes an explicit duration can be written with
+var durations = map[string]float64{
+	"w": 4,
+	"h": 2,
+	"q": 1,
+	"e": 0.5,
+	"s": 0.25,
+}
+
+// ParseDuration returns the beats of an explicit duration, which is a number
+// of sixteenths like "3" or a note like "q" for a quarter note. Each dot after
+// it adds half of what was added before, so "q." is 1.5 beats.
+func ParseDuration(s string) (beats float64, err error) {
+	value := strings.TrimRight(s, ".")
+	if beats = durations[value]; beats == 0 {
+		sixteenths, errAtoi := strconv.Atoi(value)
+		if errAtoi != nil || sixteenths <= 0 {
+			err = fmt.Errorf("bad duration '%s', should be like '3', 'q' or 'q.'", s)
+			return
+		}
+		beats = float64(sixteenths) / 4
+	}
+	add := beats
+	for i := len(value); i < len(s); i++ {
+		add /= 2
+		beats += add
+	}
+	return
+}
+
+// splitDuration splits an explicit duration off a token name like "c4:q."
+func splitDuration(name string) (note string, beats float64, err error) {
+	i := strings.LastIndex(name, ":")
+	if i < 0 {
+		return name, 0, nil
+	}
+	beats, err = ParseDuration(name[i+1:])
+	return name[:i], beats, err
+}
+
+// beats returns how long a step is, its explicit duration or else
+// its share of the beats of its line
+func (s Step) beats() float64 {
+	if s.Beats > 0 {
+		return s.Beats
+	}
+	return float64(s.BeatsPerLine) / float64(s.StepLineCount)
+}
+
+// markBars sets the bar and beat that each step of a chain starts on
+func (c *Chain) markBars(meter Meter) {
+	for i := range c.Steps {
+		c.Steps[i].Bar, c.Steps[i].Beat = meter.Position(c.Steps[i].BeatsStart)
+	}
+}
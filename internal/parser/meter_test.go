@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMeter(t *testing.T) {
+	for _, test := range []struct {
+		s     string
+		meter Meter
+		err   bool
+	}{
+		{"4/4", Meter{4, 4}, false},
+		{"7/8", Meter{7, 8}, false},
+		{" 6/8 ", Meter{6, 8}, false},
+		{"3/3", Meter{}, true},
+		{"0/4", Meter{}, true},
+		{"7", Meter{}, true},
+		{"a/b", Meter{}, true},
+	} {
+		meter, err := ParseMeter(test.s)
+		if test.err {
+			assert.NotNil(t, err, test.s)
+			continue
+		}
+		assert.Nil(t, err, test.s)
+		assert.Equal(t, test.meter, meter, test.s)
+	}
+}
+
+func TestMeterPosition(t *testing.T) {
+	for _, test := range []struct {
+		meter Meter
+		beats float64
+		bar   int
+		beat  float64
+	}{
+		{Meter{4, 4}, 0, 1, 1},
+		{Meter{4, 4}, 5.5, 2, 2.5},
+		{Meter{7, 8}, 3.5, 2, 1},
+		{Meter{7, 8}, 4, 2, 2},
+		{Meter{3, 4}, 2.9999999999, 2, 1},
+	} {
+		bar, beat := test.meter.Position(test.beats)
+		assert.Equal(t, test.bar, bar, test.meter.String())
+		assert.InDelta(t, test.beat, beat, 1e-6, test.meter.String())
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, test := range []struct {
+		s     string
+		beats float64
+		err   bool
+	}{
+		{"3", 0.75, false},
+		{"16", 4, false},
+		{"q", 1, false},
+		{"e", 0.5, false},
+		{"h.", 3, false},
+		{"q..", 1.75, false},
+		{"2.", 0.75, false},
+		{"x", 0, true},
+		{"0", 0, true},
+		{".", 0, true},
+	} {
+		beats, err := ParseDuration(test.s)
+		if test.err {
+			assert.NotNil(t, err, test.s)
+			continue
+		}
+		assert.Nil(t, err, test.s)
+		assert.Equal(t, test.beats, beats, test.s)
+	}
+}
+
+func TestDurations(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := NewOffline(`
+set meter 7/8
+
+run a
+c4:q. d4:3 e4 f4:e.(v80)
+~:q g4
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, Meter{7, 8}, tli.Meter)
+	steps := tli.ChainsRendered[0].Steps
+	assert.Equal(t, 5, len(steps))
+	for i, want := range []struct {
+		name     string
+		start    float64
+		duration float64
+		bar      int
+		beat     float64
+	}{
+		{"c4", 0, 1.5, 1, 1},
+		{"d4", 1.5, 0.75, 1, 4},
+		{"e4", 2.25, 1, 1, 5.5},
+		{"f4", 3.25, 0.75, 1, 7.5},
+		// the rest lasts a quarter note and the g4 the rest of its line
+		{"g4", 5, 3, 2, 4},
+	} {
+		assert.Equal(t, want.name, steps[i].Notes[0].Name, want.name)
+		assert.Equal(t, want.start, steps[i].BeatsStart, want.name)
+		assert.Equal(t, want.duration, steps[i].BeatsDuration, want.name)
+		assert.Equal(t, want.bar, steps[i].Bar, want.name)
+		assert.Equal(t, want.beat, steps[i].Beat, want.name)
+	}
+	assert.True(t, steps[3].Params.CheckSet(VelocitySet))
+
+	// when the lengths fill the line the other tokens keep their share
+	// of the line and the line lasts longer
+	tli, err = NewOffline("run a\nc4:w d4 e4 f4")
+	assert.Nil(t, err)
+	durations := []float64{}
+	for _, step := range tli.ChainsRendered[0].Steps {
+		durations = append(durations, step.BeatsDuration)
+	}
+	assert.Equal(t, []float64{4, 1, 1, 1}, durations)
+
+	tli, err = NewOffline("run a\nc4:z\n\nset meter 5/5")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tli.warnings))
+	assert.Equal(t, DefaultMeter, tli.Meter)
+}
+
+func TestMeterSong(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := NewOffline("set\nmeter 7/8\nbpm 120\n\nrun a\nc4\n\nsong\nintro 2: a")
+	assert.Nil(t, err)
+	// two bars of seven eighths at 120 bpm
+	assert.Equal(t, int64(3500000), tli.songMicroseconds())
+}
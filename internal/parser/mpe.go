@@ -0,0 +1,250 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/schollz/aw/internal/util"
+)
+
+// MpeBendRange is the pitch bend range in semitones of mpe member channels
+const MpeBendRange = 48
+
+// mpe controllers and the values notes start from when a step does not set them
+const (
+	ccTimbre        = 74
+	defaultTimbre   = 64
+	defaultPressure = 0
+)
+
+// slideInterval is how often slides are sent while a note plays, in microseconds
+const slideInterval = 10000
+
+// Slide is a value of a step that can move to another value over the
+// length of the step, like the timbre of "c4(y20>100)"
+type Slide struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// parseSlide parses the value of a decorator like "64" or "20>100"
+func parseSlide(s string) (slide *Slide, ok bool) {
+	parts := strings.SplitN(s, ">", 2)
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return
+	}
+	to := from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return
+		}
+	}
+	return &Slide{From: util.Clamp(from, 0, 127), To: util.Clamp(to, 0, 127)}, true
+}
+
+// At returns the value of the slide at a fraction of the step
+func (s *Slide) At(fraction float64) int {
+	fraction = math.Max(0, math.Min(1, fraction))
+	return s.From + int(math.Round(float64(s.To-s.From)*fraction))
+}
+
+func (s *Slide) moves() bool {
+	return s != nil && s.From != s.To
+}
+
+// valueAt returns the value of a slide at a fraction of the step,
+// or the default value when the step has no slide
+func valueAt(s *Slide, fraction float64, defaultValue int) uint8 {
+	if s == nil {
+		return uint8(defaultValue)
+	}
+	return uint8(s.At(fraction))
+}
+
+// glideAt returns how many semitones the notes of a step are from
+// their pitch at a fraction of the step while they glide
+func (s Step) glideAt(fraction float64, tuning *Tuning) float64 {
+	length := float64(s.Glide) / 100
+	if length <= 0 || s.GlideFrom <= 0 || fraction >= length {
+		return 0
+	}
+	root := -1
+	for _, note := range s.Notes {
+		if !note.IsRest && !note.IsLegato {
+			root = note.Midi
+			break
+		}
+	}
+	from, okFrom := tuning.Pitch(s.GlideFrom)
+	to, okTo := tuning.Pitch(root)
+	if root < 0 || !okFrom || !okTo {
+		return 0
+	}
+	return (from - to) * (1 - fraction/length)
+}
+
+// slides returns whether the expression of a step changes while it plays
+func (s Step) slides() bool {
+	return s.Pressure.moves() || s.Timbre.moves() || (s.Glide > 0 && s.GlideFrom > 0)
+}
+
+// mpeZone spreads the notes of an mpe output over its member channels,
+// which follow the master channel
+type mpeZone struct {
+	master  uint8
+	members []uint8
+	next    int
+	// notes are the channels of the notes that are on
+	notes map[int][]uint8
+	busy  map[uint8]int
+}
+
+func newMpeZone(master int, members int) *mpeZone {
+	z := &mpeZone{master: uint8(master), notes: make(map[int][]uint8), busy: make(map[uint8]int)}
+	for i := 1; i <= members; i++ {
+		z.members = append(z.members, uint8(master+i))
+	}
+	return z
+}
+
+// mpeMembers returns the master channel of an mpe output and how many member
+// channels it has, all the channels after the master unless set with "members=7"
+func mpeMembers(out Function) (master int, members int) {
+	master, _ = out.GetIntPlace("ch", 1)
+	master = util.Clamp(master, 0, 14)
+	members, err := out.GetInt("members")
+	if err != nil {
+		members = 15 - master
+	}
+	members = util.Clamp(members, 1, 15-master)
+	return
+}
+
+// noteOn gives a note the next channel that has no notes, or the next
+// channel if every channel has a note
+func (z *mpeZone) noteOn(midi int) (channel uint8) {
+	channel = z.members[z.next]
+	for i := range z.members {
+		c := z.members[(z.next+i)%len(z.members)]
+		if z.busy[c] == 0 {
+			channel = c
+			z.next = (z.next + i) % len(z.members)
+			break
+		}
+	}
+	z.next = (z.next + 1) % len(z.members)
+	z.busy[channel]++
+	z.notes[midi] = append(z.notes[midi], channel)
+	return
+}
+
+// noteOff frees the channel of the first note that is on with a midi note
+func (z *mpeZone) noteOff(midi int) (channel uint8, ok bool) {
+	channels := z.notes[midi]
+	if len(channels) == 0 {
+		return
+	}
+	channel, ok = channels[0], true
+	if len(channels) == 1 {
+		delete(z.notes, midi)
+	} else {
+		z.notes[midi] = channels[1:]
+	}
+	z.busy[channel]--
+	return
+}
+
+// channel returns the channel of the last note that is on with a midi note
+func (z *mpeZone) channel(midi int) (channel uint8, ok bool) {
+	channels := z.notes[midi]
+	if len(channels) == 0 {
+		return
+	}
+	return channels[len(channels)-1], true
+}
+
+// sendRpn sets a registered parameter on a channel
+func sendRpn(device midiOut, channel uint8, rpn uint8, value uint8) {
+	device.ControlChange(channel, 101, 0)
+	device.ControlChange(channel, 100, rpn)
+	device.ControlChange(channel, 6, value)
+}
+
+// mpeZone returns the zone of an mpe output, the device is told about
+// the zone and the bend range of its members the first time it is used
+func (dm *DeviceManager) mpeZone(name string, device midiOut, out Function) *mpeZone {
+	master, members := mpeMembers(out)
+	if z, ok := dm.mpe[name]; ok && int(z.master) == master && len(z.members) == members {
+		return z
+	}
+	z := newMpeZone(master, members)
+	sendRpn(device, z.master, 6, uint8(members))
+	for _, channel := range z.members {
+		sendRpn(device, channel, 0, MpeBendRange)
+	}
+	dm.mpe[name] = z
+	return z
+}
+
+// playMpe plays or releases the notes of a step on their own channels
+func (dm *DeviceManager) playMpe(name string, device midiOut, out Function, step Step, on bool, tuning *Tuning) {
+	z := dm.mpeZone(name, device, out)
+	for _, note := range step.Notes {
+		pitch, ok := tuning.Pitch(note.Midi)
+		if !ok {
+			continue
+		}
+		key := util.Clamp(int(math.Round(pitch)), 0, 127)
+		if !on {
+			if channel, ok := z.noteOff(note.Midi); ok {
+				device.NoteOff(channel, uint8(key))
+			}
+			continue
+		}
+		channel := z.noteOn(note.Midi)
+		device.PitchBend(channel, bendValue(pitch+step.glideAt(0, tuning)-float64(key), MpeBendRange))
+		device.ControlChange(channel, ccTimbre, valueAt(step.Timbre, 0, defaultTimbre))
+		device.Pressure(channel, valueAt(step.Pressure, 0, defaultPressure))
+		device.NoteOn(channel, uint8(key), uint8(util.Clamp(noteVelocity(step, note), 1, 127)))
+	}
+}
+
+// Slide sends the glide, pressure and timbre of the notes of a step on
+// mpe outputs at a fraction of the step
+func (dm *DeviceManager) Slide(step Step, outFns []Function, tuning *Tuning, fraction float64) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	for _, out := range outFns {
+		if mpe, _ := out.GetBool("mpe"); out.Name != "midi" || !mpe {
+			continue
+		}
+		step := transposeStep(step, transformSemitones(out.Args))
+		glide := step.glideAt(fraction, tuning)
+		name, _ := portName(out)
+		device, ok := dm.midi[name]
+		z, okZone := dm.mpe[name]
+		if !ok || !okZone {
+			continue
+		}
+		for _, note := range playable(step.Notes) {
+			channel, ok := z.channel(note.Midi)
+			pitch, okPitch := tuning.Pitch(note.Midi)
+			if !ok || !okPitch {
+				continue
+			}
+			if step.Glide > 0 {
+				key := util.Clamp(int(math.Round(pitch)), 0, 127)
+				device.PitchBend(channel, bendValue(pitch+glide-float64(key), MpeBendRange))
+			}
+			if step.Timbre.moves() {
+				device.ControlChange(channel, ccTimbre, uint8(step.Timbre.At(fraction)))
+			}
+			if step.Pressure.moves() {
+				device.Pressure(channel, uint8(step.Pressure.At(fraction)))
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func TestMpeDecorators(t *testing.T) {
+	loop := LoopNew()
+	assert.Nil(t, loop.AddLine("c4(p90) e4(g,p20>100,y40) ~ g4(g25)"))
+	assert.Equal(t, 4, len(loop.Steps))
+	assert.Equal(t, &Slide{90, 90}, loop.Steps[0].Pressure)
+	assert.Equal(t, 0, loop.Steps[0].Glide)
+
+	step := loop.Steps[1]
+	assert.Equal(t, 100, step.Glide)
+	assert.Equal(t, 60, step.GlideFrom)
+	assert.Equal(t, &Slide{20, 100}, step.Pressure)
+	assert.Equal(t, &Slide{40, 40}, step.Timbre)
+	assert.True(t, step.slides())
+	assert.Equal(t, 60, step.Pressure.At(0.5))
+	assert.InDelta(t, -4, step.glideAt(0, nil), 0.001)
+	assert.InDelta(t, -1, step.glideAt(0.75, nil), 0.001)
+
+	// glides start from the last note that played
+	step = loop.Steps[3]
+	assert.Equal(t, 25, step.Glide)
+	assert.Equal(t, 64, step.GlideFrom)
+	assert.InDelta(t, 0, step.glideAt(0.5, nil), 0.001)
+	assert.False(t, loop.Steps[0].slides())
+}
+
+func TestMpeZone(t *testing.T) {
+	z := newMpeZone(0, 3)
+	assert.Equal(t, []uint8{1, 2, 3}, z.members)
+	assert.Equal(t, uint8(1), z.noteOn(60))
+	assert.Equal(t, uint8(2), z.noteOn(64))
+	channel, ok := z.noteOff(60)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(1), channel)
+	// the next free channel is used, not the one that was just let go of
+	assert.Equal(t, uint8(3), z.noteOn(67))
+	assert.Equal(t, uint8(1), z.noteOn(72))
+	// every channel is busy so the next one is shared
+	assert.Equal(t, uint8(2), z.noteOn(76))
+	_, ok = z.noteOff(61)
+	assert.False(t, ok)
+
+	master, members := mpeMembers(Function{Name: "midi", Args: []Arg{{Value: "synth"}, {Name: "ch", Value: "0"}, {Name: "members", Value: "7"}}})
+	assert.Equal(t, 0, master)
+	assert.Equal(t, 7, members)
+}
+
+func TestPlayMpe(t *testing.T) {
+	log.SetLevel("info")
+	opened := make(map[string]*fakeMidi)
+	dm := NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		opened[name] = &fakeMidi{}
+		return opened[name], nil
+	}
+	tli, err := newWithDevices(`
+run a
+c4e4g4(y30>90)
+
+tie a
+out midi(synth,mpe=true)
+`, nil, dm)
+	assert.Nil(t, err)
+	chain := tli.ChainsRendered[0]
+	step := chain.Steps[0]
+	assert.Nil(t, dm.PlayNote(step, true, chain.OutFns, nil))
+	device := opened["synth"]
+	assert.Equal(t, []uint8{60, 64, 67}, device.notes)
+	assert.Equal(t, []uint8{1, 2, 3}, device.channels)
+	// the zone and the bend range of the members come first
+	assert.Equal(t, [][2]uint8{{101, 0}, {100, 6}, {6, 15}}, device.controls[:3])
+	assert.Equal(t, []uint8{0, 0, 0}, device.pressure)
+	assert.Equal(t, [2]uint8{74, 30}, device.controls[len(device.controls)-1])
+
+	dm.Slide(step, chain.OutFns, nil, 0.5)
+	assert.Equal(t, [2]uint8{74, 60}, device.controls[len(device.controls)-1])
+
+	assert.Nil(t, dm.PlayNote(step, false, chain.OutFns, nil))
+	assert.Equal(t, 0, len(dm.mpe["synth"].notes))
+	assert.Nil(t, dm.PlayNote(step, true, chain.OutFns, nil))
+	assert.Equal(t, []uint8{4, 5, 6}, device.channels[3:])
+}
+
+func TestWriteMidiMpe(t *testing.T) {
+	tli, err := NewOffline(`
+run a
+c4e4g4 c5(g,p0>127)
+
+tie a
+out midi(synth,mpe=true)
+`)
+	assert.Nil(t, err)
+	events, _ := tli.Arrange()
+	assert.Equal(t, 4, len(events))
+	assert.Equal(t, 15, events[0].Members)
+	assert.InDelta(t, -12, events[3].Glide, 0.001)
+
+	var buf bytes.Buffer
+	assert.Nil(t, tli.WriteMidi(&buf))
+	s, err := smf.ReadFrom(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	channels := []uint8{}
+	pressure := 0
+	for _, ev := range s.Tracks[1] {
+		var channel, key, velocity uint8
+		if ev.Message.GetNoteStart(&channel, &key, &velocity) {
+			channels = append(channels, channel)
+		}
+		if ev.Message.GetAfterTouch(&channel, &velocity) {
+			pressure++
+		}
+	}
+	assert.Equal(t, []uint8{1, 2, 3, 4}, channels)
+	assert.Greater(t, pressure, 10)
+}
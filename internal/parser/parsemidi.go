@@ -28,6 +28,27 @@ func exactMatch(n string) (note Note, ok bool) {
 	}
 	return
 }
+
+// MidiName returns the lowercase name of a midi note, e.g. 60 -> "c4"
+func MidiName(midi int) string {
+	for _, m := range noteDB {
+		if m.MidiValue == midi {
+			return strings.ToLower(m.NameSharp)
+		}
+	}
+	return strconv.Itoa(midi)
+}
+
+// MidiFrequency returns the frequency in hertz of a midi note
+func MidiFrequency(midi int) float64 {
+	for _, m := range noteDB {
+		if m.MidiValue == midi {
+			return m.Frequency
+		}
+	}
+	return 440 * math.Pow(2, float64(midi-69)/12)
+}
+
 func ParseMidi(midiString string, midiNear int) (notes []Note, err error) {
 	// can be a single midi note like "c" in which case we need to find the closest note to midiNear
 	// or can be a single note like "c4" in which case we want an exact match
@@ -48,3 +48,19 @@ func TestParseMidi(t *testing.T) {
 		}
 	}
 }
+
+func TestMidiName(t *testing.T) {
+	tests := []struct {
+		midi     int
+		expected string
+	}{
+		{60, "c4"},
+		{66, "f#4"},
+		{21, "a0"},
+	}
+	for _, test := range tests {
+		if name := MidiName(test.midi); name != test.expected {
+			t.Errorf("MidiName(%d) = %s, expected %s", test.midi, name, test.expected)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ProjectFilename is the name of the optional project file that
+// sits in the same folder as the TLI files, for example:
+//
+//	entry: main.tli
+//	devices:
+//	  synth: UM-ONE MIDI 1
+type Project struct {
+	// Filename is where the project file was found
+	Filename string `yaml:"-"`
+	// Entry is the TLI file that is played, it includes the others
+	Entry string `yaml:"entry"`
+	// Devices are aliases that can be used in out lines instead of the
+	// full name of the device
+	Devices map[string]string `yaml:"devices"`
+}
+
+const ProjectFilename = "aw.yaml"
+
+// FindProject looks for a project file in the folder of the given file,
+// returning nil if there is none
+func FindProject(filename string) (project *Project, err error) {
+	projectFilename := filepath.Join(filepath.Dir(filename), ProjectFilename)
+	b, err := os.ReadFile(projectFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	project = new(Project)
+	err = yaml.Unmarshal(b, project)
+	if err != nil {
+		project = nil
+		return
+	}
+	project.Filename, _ = filepath.Abs(projectFilename)
+	if project.Entry != "" && !filepath.IsAbs(project.Entry) {
+		project.Entry = filepath.Join(filepath.Dir(project.Filename), project.Entry)
+	}
+	return
+}
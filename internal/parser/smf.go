@@ -0,0 +1,262 @@
+package parser
+
+import (
+	"io"
+	"math"
+	"sort"
+
+	"github.com/schollz/aw/internal/util"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// ticks per quarter note of rendered midi files
+const smfResolution = smf.MetricTicks(960)
+
+// rampResolution is how many beats each tempo of a tempo ramp
+// lasts in rendered midi files
+const rampResolution = 0.25
+
+// tempoChange is a tempo of a rendered midi file and where it starts
+type tempoChange struct {
+	microseconds int64
+	tick         int64
+	tempo        float64
+}
+
+// tempoMap returns the tempos of a rendered midi file up to a time. They follow
+// the first chain whose tempo changes, over and over, or else are the tempo of
+// the text. Notes are placed by their time so every chain plays in time.
+func (tli *TLI) tempoMap(total int64) (changes []tempoChange) {
+	var leader *Chain
+	for i := range tli.ChainsRendered {
+		chain := &tli.ChainsRendered[i]
+		if chain.MicrosecondsTotal > 0 && tli.isAudible(i) && !chain.constantTempo(tli.Params.Tempo) {
+			leader = chain
+			break
+		}
+	}
+	if leader == nil {
+		return []tempoChange{{tempo: float64(tli.Params.Tempo)}}
+	}
+	for offset := int64(0); offset == 0 || offset < total; offset += leader.MicrosecondsTotal {
+		for _, segment := range leader.Tempos {
+			pieces := 1
+			if segment.From != segment.To {
+				pieces = int(math.Ceil(segment.Beats / rampResolution))
+			}
+			beats := segment.Beats / float64(pieces)
+			for k := 0; k < pieces; k++ {
+				start, duration := leader.span(segment.Beat+beats*float64(k), beats)
+				if duration <= 0 {
+					continue
+				}
+				// each piece of a ramp gets the tempo that plays it in the same time
+				changes = append(changes, tempoChange{
+					microseconds: offset + start,
+					tempo:        beats * 60000000 / float64(duration),
+				})
+			}
+		}
+	}
+	for i := 1; i < len(changes); i++ {
+		changes[i].tick = ticksAt(changes[:i], changes[i].microseconds)
+	}
+	return
+}
+
+// ticksAt returns the tick of a time in a midi file with tempo changes
+func ticksAt(changes []tempoChange, microseconds int64) int64 {
+	change := changes[0]
+	for _, c := range changes[1:] {
+		if c.microseconds > microseconds {
+			break
+		}
+		change = c
+	}
+	return change.tick + int64(math.Round(float64(microseconds-change.microseconds)*change.tempo*float64(smfResolution)/60000000))
+}
+
+type message struct {
+	tick int64
+	on   bool
+	msg  midi.Message
+}
+
+// rpnMessages set a registered parameter on a channel
+func rpnMessages(tick int64, channel uint8, rpn uint8, value uint8) []message {
+	return []message{
+		{tick, true, midi.ControlChange(channel, 101, 0)},
+		{tick, true, midi.ControlChange(channel, 100, rpn)},
+		{tick, true, midi.ControlChange(channel, 6, value)},
+	}
+}
+
+// mpeMessages are the expression of an mpe note on its channel, from before
+// the note starts to while it plays
+func mpeMessages(e NoteEvent, channel uint8, ticks func(int64) int64) (messages []message) {
+	bendAt := func(t int64) midi.Message {
+		glide := 0.0
+		if t < e.GlideDuration {
+			glide = e.Glide * (1 - float64(t)/float64(e.GlideDuration))
+		}
+		return midi.Pitchbend(channel, bendValue(float64(e.Bend)/8192*MpeBendRange+glide, MpeBendRange))
+	}
+	fraction := func(t int64) float64 {
+		return float64(t) / float64(e.Duration)
+	}
+	messages = append(messages,
+		message{ticks(e.Start), true, bendAt(0)},
+		message{ticks(e.Start), true, midi.ControlChange(channel, ccTimbre, valueAt(e.Timbre, 0, defaultTimbre))},
+		message{ticks(e.Start), true, midi.AfterTouch(channel, valueAt(e.Pressure, 0, defaultPressure))},
+	)
+	for t := int64(slideInterval); t < e.Duration; t += slideInterval {
+		tick := ticks(e.Start + t)
+		if t <= e.GlideDuration && e.Glide != 0 {
+			messages = append(messages, message{tick, true, bendAt(t)})
+		}
+		if e.Timbre.moves() {
+			messages = append(messages, message{tick, true, midi.ControlChange(channel, ccTimbre, uint8(e.Timbre.At(fraction(t))))})
+		}
+		if e.Pressure.moves() {
+			messages = append(messages, message{tick, true, midi.AfterTouch(channel, uint8(e.Pressure.At(fraction(t))))})
+		}
+	}
+	return
+}
+
+// laneMessages returns the control changes of the lanes of a chain that are
+// routed to midi cc outs, over each part of the song the chain plays in
+func (tli *TLI) laneMessages(i int, ticks func(int64) int64) (messages []message) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	chain := tli.ChainsRendered[i]
+	if chain.MicrosecondsTotal <= 0 || !tli.isAudible(i) {
+		return
+	}
+	for _, p := range tli.arrangeParts() {
+		playing := false
+		for _, j := range p.chains {
+			playing = playing || j == i
+		}
+		if !playing {
+			continue
+		}
+		for _, route := range chain.LaneRoutes {
+			if route.Out.Name != "cc" {
+				continue
+			}
+			controller, errCC := route.Out.GetIntPlace("cc", 1)
+			if errCC != nil {
+				continue
+			}
+			channel, _ := route.Out.GetInt("ch")
+			// lanes start over with their chain
+			for offset := int64(0); offset < p.length; offset += chain.MicrosecondsTotal {
+				for _, e := range route.Events {
+					if offset+e.Time >= p.length {
+						break
+					}
+					messages = append(messages, message{ticks(p.start + offset + e.Time), true, midi.ControlChange(
+						uint8(util.Clamp(channel, 0, 15)),
+						uint8(util.Clamp(controller, 0, 127)),
+						uint8(util.Clamp(int(math.Round(e.Value)), 0, 127)),
+					)})
+				}
+			}
+		}
+	}
+	return
+}
+
+// WriteMidi writes the arrangement of the song as a standard midi file
+// with a track for each chain
+func (tli *TLI) WriteMidi(w io.Writer) (err error) {
+	events, total := tli.Arrange()
+	tempos := tli.tempoMap(total)
+	ticks := func(microseconds int64) int64 {
+		return ticksAt(tempos, microseconds)
+	}
+
+	var general smf.Track
+	general.Add(0, smf.MetaTrackSequenceName("aw"))
+	general.Add(0, smf.MetaMeter(uint8(tli.Meter.Beats), uint8(tli.Meter.Unit)))
+	last := int64(0)
+	for _, change := range tempos {
+		if change.microseconds >= total && change.microseconds > 0 {
+			break
+		}
+		general.Add(uint32(change.tick-last), smf.MetaTempo(change.tempo))
+		last = change.tick
+	}
+	general.Close(uint32(ticks(total) - last))
+
+	s := smf.NewSMF1()
+	s.TimeFormat = smfResolution
+	if err = s.Add(general); err != nil {
+		return
+	}
+
+	for i, chain := range tli.ChainsRendered {
+		// control changes of lanes go before the notes on the same tick
+		messages := tli.laneMessages(i, ticks)
+		// mpe notes take the member channel of the zone of their out
+		// that has been free the longest
+		zones := map[uint8]*mpeZone{}
+		free := map[uint8]int64{}
+		for _, e := range events {
+			if e.Chain != i {
+				continue
+			}
+			channel := uint8(util.Clamp(e.Channel, 0, 15))
+			key := uint8(util.Clamp(e.Midi, 0, 127))
+			if e.Members > 0 {
+				zone, ok := zones[channel]
+				if !ok {
+					zone = newMpeZone(int(channel), e.Members)
+					zones[channel] = zone
+					messages = append(messages, rpnMessages(0, zone.master, 6, uint8(e.Members))...)
+					for _, member := range zone.members {
+						messages = append(messages, rpnMessages(0, member, 0, MpeBendRange)...)
+					}
+				}
+				channel = zone.members[0]
+				for _, member := range zone.members {
+					if free[member] < free[channel] {
+						channel = member
+					}
+				}
+				free[channel] = e.Start + e.Duration
+				messages = append(messages, mpeMessages(e, channel, ticks)...)
+			} else if tli.Tuning != nil {
+				// the bend goes before the note on the same tick, each note
+				// of a chord has a channel of its own
+				messages = append(messages, message{ticks(e.Start), true, midi.Pitchbend(channel, e.Bend)})
+			}
+			messages = append(messages,
+				message{ticks(e.Start), true, midi.NoteOn(channel, key, uint8(util.Clamp(e.Velocity, 1, 127)))},
+				message{ticks(e.Start + e.Duration), false, midi.NoteOff(channel, key)},
+			)
+		}
+		// notes end before the next ones start on the same tick
+		sort.SliceStable(messages, func(a, b int) bool {
+			if messages[a].tick == messages[b].tick {
+				return !messages[a].on && messages[b].on
+			}
+			return messages[a].tick < messages[b].tick
+		})
+		var track smf.Track
+		track.Add(0, smf.MetaTrackSequenceName(chain.Name))
+		last := int64(0)
+		for _, m := range messages {
+			track.Add(uint32(m.tick-last), m.msg)
+			last = m.tick
+		}
+		track.Close(uint32(ticks(total) - last))
+		if err = s.Add(track); err != nil {
+			return
+		}
+	}
+	_, err = s.WriteTo(w)
+	return
+}
@@ -0,0 +1,330 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Section is a part of a song that plays some chains together for a number of bars
+type Section struct {
+	Name   string   `json:"name"`
+	Bars   int      `json:"bars"`
+	Chains []string `json:"chains"`
+}
+
+var sectionRegex = regexp.MustCompile(`^(\S+)\s+(\d+)\s*:\s*(.*)$`)
+
+// ParseSection parses a line of a song block like "verse 8: drums bass",
+// where the chains are given by their name or their number
+func ParseSection(line string) (section Section, err error) {
+	match := sectionRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		err = fmt.Errorf("bad section '%s', should be like 'verse 8: drums bass'", line)
+		return
+	}
+	section.Name = match[1]
+	section.Bars, _ = strconv.Atoi(match[2])
+	if section.Bars <= 0 {
+		err = fmt.Errorf("section '%s' needs at least one bar", section.Name)
+		return
+	}
+	section.Chains = strings.Fields(strings.ReplaceAll(match[3], ",", " "))
+	return
+}
+
+// checkSong returns a warning for every chain in the song that does not exist
+func (tli *TLI) checkSong() (warnings []string) {
+	for _, section := range tli.Song {
+		for _, name := range section.Chains {
+			if _, err := tli.FindChain(name); err != nil {
+				warnings = append(warnings, fmt.Sprintf("section '%s': %s", section.Name, err.Error()))
+			}
+		}
+	}
+	return
+}
+
+// sectionMicroseconds returns how long a section lasts in the meter of the
+// song, following the tempo of the first chain of the section from its start
+// or the tempo of the song when the section has no chains
+func (tli *TLI) sectionMicroseconds(section Section) int64 {
+	beats := float64(section.Bars) * tli.Meter.BarBeats()
+	for _, name := range section.Chains {
+		if i, err := tli.FindChain(name); err == nil && len(tli.ChainsRendered[i].Tempos) > 0 {
+			_, duration := tli.ChainsRendered[i].span(0, beats)
+			return duration
+		}
+	}
+	return int64(math.Round(beats * 60000000 / float64(tli.Params.Tempo)))
+}
+
+// songMicroseconds returns how long the song lasts
+func (tli *TLI) songMicroseconds() (total int64) {
+	for _, section := range tli.Song {
+		total += tli.sectionMicroseconds(section)
+	}
+	return
+}
+
+// sectionAt returns the section that is playing at a time since the song
+// started and when it started, the song starts over after the last section
+func (tli *TLI) sectionAt(position int64) (index int, start int64) {
+	total := tli.songMicroseconds()
+	if total <= 0 || position < 0 {
+		return -1, 0
+	}
+	start = position - position%total
+	for i, section := range tli.Song {
+		length := tli.sectionMicroseconds(section)
+		if position < start+length {
+			return i, start
+		}
+		start += length
+	}
+	return len(tli.Song) - 1, start
+}
+
+// inSection returns whether the chain at index i plays in a section
+func (tli *TLI) inSection(section int, i int) bool {
+	if section < 0 || section >= len(tli.Song) {
+		return false
+	}
+	for _, name := range tli.Song[section].Chains {
+		if j, err := tli.FindChain(name); err == nil && j == i {
+			return true
+		}
+	}
+	return false
+}
+
+// Section returns the name of the section of the song that is playing
+func (tli *TLI) Section() string {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	if tli.section < 0 || tli.section >= len(tli.Song) || !tli.IsPlaying() {
+		return ""
+	}
+	return tli.Song[tli.section].Name
+}
+
+// followSong stops the chains that are not in the section playing at a time
+// since the song started, and starts the chains of a new section from their
+// first step when the section begins
+func (tli *TLI) followSong(position int64) {
+	section, start := tli.sectionAt(position)
+	if section < 0 {
+		return
+	}
+	changed := section != tli.section
+	tli.section = section
+	publish := changed
+	for i := range tli.ChainsRendered {
+		in := tli.inSection(section, i)
+		publish = publish || tli.ChainsRendered[i].Stopped == in
+		tli.ChainsRendered[i].Stopped = !in
+		if in && changed {
+			tli.ChainsRendered[i].TimeOffset = tli.songOffset + start
+			tli.ChainsRendered[i].StepCurrent = 0
+			tli.TimePosition[i] = -1
+		}
+	}
+	if publish {
+		tli.publish()
+	}
+}
+
+// NoteEvent is a note of an arrangement
+type NoteEvent struct {
+	Chain int
+	// Midi is the key closest to the pitch of the note and Bend is the
+	// pitch bend that tunes it, which is 0 without a tuning
+	Midi      int
+	Bend      int16
+	Frequency float64
+	Velocity  int
+	Channel   int
+	// Start and Duration are in microseconds
+	Start    int64
+	Duration int64
+	// Voice is the voice of a "synth(saw)" output of the chain
+	Voice string
+	// Adsr is the envelope from an adsr decorator, with the attack, decay
+	// and release in seconds and the sustain level
+	Adsr []float64
+	// Members is the number of member channels after Channel of an mpe
+	// output, each note plays on a member channel of its own
+	Members  int
+	Pressure *Slide
+	Timbre   *Slide
+	// Glide is how many semitones the note starts away from its pitch,
+	// it slides to its pitch over GlideDuration microseconds
+	Glide         float64
+	GlideDuration int64
+}
+
+// arrangePart is a part of the arrangement of a song where some chains play
+// together, the start and length are in microseconds
+type arrangePart struct {
+	start  int64
+	length int64
+	chains []int
+}
+
+// arrangeParts returns the sections of the song, or a single part where every
+// chain plays for as long as the longest chain when there is no song
+func (tli *TLI) arrangeParts() (parts []arrangePart) {
+	if len(tli.Song) > 0 {
+		start := int64(0)
+		for s, section := range tli.Song {
+			p := arrangePart{start: start, length: tli.sectionMicroseconds(section)}
+			for i := range tli.ChainsRendered {
+				if tli.inSection(s, i) {
+					p.chains = append(p.chains, i)
+				}
+			}
+			parts = append(parts, p)
+			start += p.length
+		}
+		return
+	}
+	p := arrangePart{}
+	for i, chain := range tli.ChainsRendered {
+		if chain.MicrosecondsTotal > p.length {
+			p.length = chain.MicrosecondsTotal
+		}
+		p.chains = append(p.chains, i)
+	}
+	return append(parts, p)
+}
+
+// arrangeOut is how an out of a chain plays the notes of an arrangement
+type arrangeOut struct {
+	channel   int
+	members   int
+	bendRange int
+	voice     string
+	semitones int
+}
+
+// arrangeOuts returns how each midi and synth out of a chain plays its notes,
+// a chain without either plays once on the first channel
+func arrangeOuts(outFns []Function) (outs []arrangeOut) {
+	for _, fn := range outFns {
+		out := arrangeOut{bendRange: DefaultBendRange, semitones: transformSemitones(fn.Args)}
+		if mpe, _ := fn.GetBool("mpe"); fn.Name == "midi" && mpe {
+			out.channel, out.members = mpeMembers(fn)
+			out.bendRange = MpeBendRange
+		} else if fn.Name == "midi" {
+			out.channel, _ = fn.GetIntPlace("ch", 1)
+			if val, errBend := fn.GetInt("bend"); errBend == nil {
+				out.bendRange = val
+			}
+		} else if fn.Name == "synth" {
+			out.voice, _ = fn.GetStringPlace("voice", 0)
+		} else {
+			continue
+		}
+		outs = append(outs, out)
+	}
+	if len(outs) == 0 {
+		outs = append(outs, arrangeOut{bendRange: DefaultBendRange})
+	}
+	return
+}
+
+// Arrange goes through the song once and returns every note that plays. Without
+// a song every chain plays together for as long as the longest chain.
+func (tli *TLI) Arrange() (events []NoteEvent, total int64) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	parts := tli.arrangeParts()
+
+	for _, p := range parts {
+		total = p.start + p.length
+		for _, i := range p.chains {
+			chain := tli.ChainsRendered[i]
+			if chain.MicrosecondsTotal <= 0 || !tli.isAudible(i) {
+				continue
+			}
+			// each out plays the notes of the chain on its own, and
+			// shorter chains repeat until the end of the part
+			for _, out := range arrangeOuts(chain.OutFns) {
+				for offset := int64(0); offset < p.length; offset += chain.MicrosecondsTotal {
+					for _, step := range chain.Steps {
+						step = transposeStep(step, out.semitones)
+						start := offset + step.TimeStartMicroseconds
+						if start >= p.length {
+							break
+						}
+						duration := step.TimeDurationMicroseconds * int64(step.Params.Gate) / 100
+						if start+duration > p.length {
+							duration = p.length - start
+						}
+						adsr := stepAdsr(step)
+						glide := step.glideAt(0, tli.Tuning)
+						glideDuration := step.TimeDurationMicroseconds * int64(step.Glide) / 100
+						for k, note := range playable(step.Notes) {
+							key, bend, ok := tli.Tuning.PitchBend(note.Midi, out.bendRange)
+							if !ok {
+								// keys that are not in the tuning do not play
+								continue
+							}
+							channel := out.channel
+							if tli.Tuning != nil && out.members == 0 {
+								channel = tunedChannel(channel, k)
+							}
+							events = append(events, NoteEvent{
+								Chain:     i,
+								Midi:      key,
+								Bend:      bend,
+								Frequency: tli.Tuning.Frequency(note.Midi),
+								Velocity:  noteVelocity(step, note),
+								Channel:   channel,
+								Start:     p.start + start,
+								Duration:  duration,
+								Voice:     out.voice,
+								Adsr:      adsr,
+								Members:   out.members,
+								Pressure:  step.Pressure,
+								Timbre:    step.Timbre,
+								Glide:     glide,
+								// the glide is over part of the step like when playing
+								GlideDuration: glideDuration,
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.SliceStable(events, func(a, b int) bool {
+		return events[a].Start < events[b].Start
+	})
+	return
+}
+
+// stepAdsr returns the envelope of an adsr decorator on the step, where
+// the attack, decay and release are given relative to the length of the step
+func stepAdsr(step Step) (adsr []float64) {
+	for _, arg := range step.Arguments {
+		if !strings.HasPrefix(arg.Value, "adsr") && arg.Name != "adsr" {
+			continue
+		}
+		value := strings.TrimPrefix(strings.TrimPrefix(arg.Value, "adsr="), "adsr")
+		vals := SplitArgFloat(value)
+		if len(vals) != 4 {
+			continue
+		}
+		for i := range vals {
+			if i != 2 {
+				vals[i] *= float64(step.TimeDurationMicroseconds) / 1000000.0
+			}
+		}
+		adsr = vals
+	}
+	return
+}
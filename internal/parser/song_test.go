@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func TestParseSection(t *testing.T) {
+	for _, test := range []struct {
+		line    string
+		section Section
+		err     bool
+	}{
+		{"verse 8: drums bass", Section{Name: "verse", Bars: 8, Chains: []string{"drums", "bass"}}, false},
+		{"intro 2:drums,bass", Section{Name: "intro", Bars: 2, Chains: []string{"drums", "bass"}}, false},
+		{"break 1:", Section{Name: "break", Bars: 1, Chains: []string{}}, false},
+		{"verse: drums", Section{}, true},
+		{"verse 0: drums", Section{}, true},
+	} {
+		section, err := ParseSection(test.line)
+		if test.err {
+			assert.NotNil(t, err, test.line)
+			continue
+		}
+		assert.Nil(t, err, test.line)
+		assert.Equal(t, test.section, section, test.line)
+	}
+}
+
+const songText = `
+run a
+c4 d e f
+
+run b
+g4
+
+tie a
+out midi(synth,ch=2)
+
+tie b
+
+song
+intro 1: a
+verse 2: a b
+`
+
+func TestSong(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := NewOffline(songText)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tli.Song))
+	assert.Empty(t, tli.warnings)
+
+	// a bar is 2 seconds at 120 bpm
+	assert.Equal(t, int64(6000000), tli.songMicroseconds())
+	i, start := tli.sectionAt(1000000)
+	assert.Equal(t, 0, i)
+	assert.Equal(t, int64(0), start)
+	i, start = tli.sectionAt(5000000)
+	assert.Equal(t, 1, i)
+	assert.Equal(t, int64(2000000), start)
+	// the song starts over
+	i, start = tli.sectionAt(8500000)
+	assert.Equal(t, 1, i)
+	assert.Equal(t, int64(8000000), start)
+
+	tli.section = -1
+	tli.followSong(1000000)
+	assert.False(t, tli.ChainsRendered[0].Stopped)
+	assert.True(t, tli.ChainsRendered[1].Stopped)
+	tli.followSong(2500000)
+	assert.False(t, tli.ChainsRendered[1].Stopped)
+	assert.Equal(t, int64(2000000), tli.ChainsRendered[1].TimeOffset)
+
+	events, total := tli.Arrange()
+	assert.Equal(t, int64(6000000), total)
+	// chain a plays its four notes once per bar and chain b once per bar in the verse
+	count := map[int]int{}
+	for _, e := range events {
+		count[e.Chain]++
+	}
+	assert.Equal(t, 12, count[0])
+	assert.Equal(t, 2, count[1])
+	assert.Equal(t, 2, events[0].Channel)
+	assert.Equal(t, 60, events[0].Midi)
+
+	var buf bytes.Buffer
+	assert.Nil(t, tli.WriteMidi(&buf))
+	s, err := smf.ReadFrom(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(3), s.NumTracks())
+}
+
+func TestSongTempoRamp(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := NewOffline(`
+run a
+c4(t60>120) d4 e4 f4
+
+run b
+g4
+
+tie a
+
+tie b
+
+song
+ramp 2: a
+flat 1: b
+`)
+	assert.Nil(t, err)
+	assert.Empty(t, tli.warnings)
+
+	// each bar of the ramp takes as long as the loop, and the bar without
+	// a ramp is 2 seconds at 120 bpm
+	assert.Equal(t, int64(2*2772589), tli.sectionMicroseconds(tli.Song[0]))
+	assert.Equal(t, int64(2*2772589+2000000), tli.songMicroseconds())
+	i, _ := tli.sectionAt(5000000)
+	assert.Equal(t, 0, i)
+	i, start := tli.sectionAt(6000000)
+	assert.Equal(t, 1, i)
+	assert.Equal(t, int64(2*2772589), start)
+	events, total := tli.Arrange()
+	assert.Equal(t, int64(2*2772589+2000000), total)
+	assert.Equal(t, int64(2772589), events[4].Start)
+
+	// the beat of a chain follows its ramp
+	tli.transport.state.Store(int32(TransportPlaying))
+	for _, test := range []struct {
+		position int64
+		beat     float64
+	}{
+		{0, 0},
+		{892574, 1},
+		{1621860, 2},
+		{2238463, 3},
+	} {
+		tli.TimePosition[0] = test.position
+		assert.InDelta(t, test.beat, tli.Beat(0), 0.001, test.position)
+	}
+	tli.transport.state.Store(int32(TransportStopped))
+}
+
+func TestSongWarnings(t *testing.T) {
+	tli, err := NewOffline(`
+run a
+c4
+
+tie a
+
+song
+verse 4: a nope
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tli.warnings))
+}
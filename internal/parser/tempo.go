@@ -0,0 +1,251 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TempoRamp is a tempo that starts at one tempo and moves in straight lines
+// to the tempo of each point, like "120>140:8>100" which goes from 120 to 140
+// over 8 beats and then to 100 by the end of the loop
+type TempoRamp struct {
+	Start  float64      `json:"start"`
+	Points []TempoPoint `json:"points,omitempty"`
+}
+
+// TempoPoint is a tempo that a ramp gets to over some beats, the last
+// point of a ramp can leave them out to get there at the end
+type TempoPoint struct {
+	Tempo float64 `json:"tempo"`
+	Beats float64 `json:"beats,omitempty"`
+}
+
+// TempoSegment is a part of a chain where the tempo goes from one tempo
+// to another in a straight line, or stays the same
+type TempoSegment struct {
+	Beat  float64 `json:"beat"`
+	Beats float64 `json:"beats"`
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+}
+
+// ParseTempoRamp parses a ramp like "120>140" or "120>140:8>100"
+func ParseTempoRamp(s string) (ramp *TempoRamp, err error) {
+	parts := strings.Split(s, ">")
+	ramp = &TempoRamp{}
+	ramp.Start, err = strconv.ParseFloat(parts[0], 64)
+	if err != nil || ramp.Start <= 0 {
+		err = fmt.Errorf("bad tempo ramp '%s', should be like '120>140:8'", s)
+		return
+	}
+	for i, part := range parts[1:] {
+		point := TempoPoint{}
+		tempo, beats, _ := strings.Cut(part, ":")
+		point.Tempo, err = strconv.ParseFloat(tempo, 64)
+		if err == nil && beats != "" {
+			point.Beats, err = strconv.ParseFloat(beats, 64)
+		}
+		// only the last point can go on until the end
+		if err != nil || point.Tempo <= 0 || point.Beats < 0 || (point.Beats == 0 && i < len(parts)-2) {
+			err = fmt.Errorf("bad tempo ramp '%s', should be like '120>140:8'", s)
+			return
+		}
+		ramp.Points = append(ramp.Points, point)
+	}
+	return
+}
+
+// segments returns the tempo of a ramp that starts at a beat and
+// goes on until another beat, points without beats end at the end
+func (r *TempoRamp) segments(beat float64, end float64, until float64) (segments []TempoSegment) {
+	tempo := r.Start
+	for _, point := range r.Points {
+		beats := point.Beats
+		if beats == 0 {
+			beats = end - beat
+		}
+		if beats <= 0 {
+			continue
+		}
+		segments = append(segments, TempoSegment{Beat: beat, Beats: beats, From: tempo, To: point.Tempo})
+		beat += beats
+		tempo = point.Tempo
+	}
+	segments = append(segments, TempoSegment{Beat: beat, Beats: math.Inf(1), From: tempo, To: tempo})
+	// cut the segments at where the next tempo starts
+	cut := segments[:0]
+	for _, segment := range segments {
+		if segment.Beat >= until {
+			break
+		}
+		if segment.Beat+segment.Beats > until {
+			segment.To = segment.at(until)
+			segment.Beats = until - segment.Beat
+		}
+		cut = append(cut, segment)
+	}
+	return cut
+}
+
+// at returns the tempo of a segment at a beat
+func (s TempoSegment) at(beat float64) float64 {
+	if s.From == s.To || s.Beats <= 0 {
+		return s.From
+	}
+	return s.From + (s.To-s.From)*(beat-s.Beat)/s.Beats
+}
+
+// microseconds returns how long the beats from the start of the
+// segment to a beat take to play
+func (s TempoSegment) microseconds(beat float64) float64 {
+	beats := beat - s.Beat
+	if s.From == s.To {
+		return beats * 60000000 / s.From
+	}
+	// the tempo changes at a steady rate per beat, so the time
+	// is the integral of 1/tempo which is a logarithm
+	slope := (s.To - s.From) / s.Beats
+	return 60000000 * math.Log(s.at(beat)/s.From) / slope
+}
+
+// beatAt returns the beat that a segment gets to some microseconds
+// after it starts
+func (s TempoSegment) beatAt(microseconds float64) float64 {
+	if s.From == s.To {
+		return s.Beat + microseconds*s.From/60000000
+	}
+	// the inverse of the logarithm in microseconds
+	slope := (s.To - s.From) / s.Beats
+	return s.Beat + s.From*(math.Exp(microseconds*slope/60000000)-1)/slope
+}
+
+// tempoAt returns the tempo of a chain at a beat
+func (c *Chain) tempoAt(beat float64) float64 {
+	for i, segment := range c.Tempos {
+		if beat < segment.Beat+segment.Beats || i == len(c.Tempos)-1 {
+			return segment.at(math.Min(beat, segment.Beat+segment.Beats))
+		}
+	}
+	return 120
+}
+
+// microseconds returns how long it takes a chain to play up to a beat
+func (c *Chain) microseconds(beat float64) int64 {
+	total := 0.0
+	for _, segment := range c.Tempos {
+		if beat <= segment.Beat {
+			break
+		}
+		total += segment.microseconds(math.Min(beat, segment.Beat+segment.Beats))
+	}
+	if len(c.Tempos) > 0 {
+		// beats past the end of the tempos keep the last tempo
+		last := c.Tempos[len(c.Tempos)-1]
+		if end := last.Beat + last.Beats; beat > end {
+			total += (beat - end) * 60000000 / last.To
+		}
+	}
+	return int64(math.Round(total))
+}
+
+// beatAt returns the beat that a chain plays some microseconds after it
+// starts, the inverse of microseconds
+func (c *Chain) beatAt(microseconds int64) float64 {
+	if len(c.Tempos) == 0 {
+		tempo := 120.0
+		if len(c.Steps) > 0 {
+			tempo = float64(c.Steps[0].Params.Tempo)
+		}
+		return float64(microseconds) * tempo / 60000000
+	}
+	left := float64(microseconds)
+	for _, segment := range c.Tempos {
+		length := segment.microseconds(segment.Beat + segment.Beats)
+		if left < length {
+			return segment.beatAt(left)
+		}
+		left -= length
+	}
+	// beats past the end of the tempos keep the last tempo
+	last := c.Tempos[len(c.Tempos)-1]
+	return last.Beat + last.Beats + left*last.To/60000000
+}
+
+// setTempos works out the tempo of a chain over its steps from the tempo of
+// the text, the tempo of the chain and the tempo decorators of its steps.
+// Ramps go on until the end of the loop their step is in unless they say how
+// many beats they last. The tempo of each step is the tempo it starts at.
+func (c *Chain) setTempos(tempo int) {
+	type change struct {
+		beat float64
+		end  float64
+		ramp *TempoRamp
+	}
+	starts := make([]float64, len(c.Steps)+1)
+	for i, step := range c.Steps {
+		starts[i+1] = starts[i] + step.beats()
+	}
+	total := starts[len(c.Steps)]
+	changes := []change{{0, total, &TempoRamp{Start: float64(tempo)}}}
+	if c.Tempo != nil {
+		changes[0].ramp = c.Tempo
+	}
+	for i, step := range c.Steps {
+		if !step.Params.CheckSet(TempoSet) {
+			continue
+		}
+		ramp := step.Ramp
+		if ramp == nil {
+			ramp = &TempoRamp{Start: float64(step.Params.Tempo)}
+		}
+		// the loop the step is in ends where the steps of the next loop start
+		end := i
+		for end < len(c.Steps) && c.Steps[end].loop == step.loop {
+			end++
+		}
+		if starts[i] == 0 {
+			changes = changes[:0]
+		}
+		changes = append(changes, change{starts[i], starts[end], ramp})
+	}
+	c.Tempos = nil
+	for i, ch := range changes {
+		until := total
+		if i < len(changes)-1 {
+			until = changes[i+1].beat
+		}
+		c.Tempos = append(c.Tempos, ch.ramp.segments(ch.beat, ch.end, until)...)
+	}
+	for i := range c.Steps {
+		c.Steps[i].Params.Tempo = int(math.Round(c.tempoAt(starts[i])))
+	}
+}
+
+// constantTempo returns whether the tempo of a chain stays the same
+func (c *Chain) constantTempo(tempo int) bool {
+	for _, segment := range c.Tempos {
+		if segment.From != float64(tempo) || segment.To != float64(tempo) {
+			return false
+		}
+	}
+	return true
+}
+
+// span returns when some beats of a chain start playing and how long they
+// take, beats past the end of the chain are played from its start again
+func (c *Chain) span(beat float64, beats float64) (start int64, duration int64) {
+	start = c.microseconds(beat)
+	end := beat + beats
+	total := 0.0
+	if len(c.Tempos) > 0 {
+		last := c.Tempos[len(c.Tempos)-1]
+		total = last.Beat + last.Beats
+	}
+	if end <= total || total <= 0 {
+		return start, c.microseconds(end) - start
+	}
+	loops := math.Floor(end / total)
+	return start, int64(loops)*c.microseconds(total) + c.microseconds(end-loops*total) - start
+}
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func TestParseTempoRamp(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		ramp TempoRamp
+		err  bool
+	}{
+		{"120>140", TempoRamp{120, []TempoPoint{{140, 0}}}, false},
+		{"120>140:8>100", TempoRamp{120, []TempoPoint{{140, 8}, {100, 0}}}, false},
+		{"90>60:2.5", TempoRamp{90, []TempoPoint{{60, 2.5}}}, false},
+		{"120>140>100", TempoRamp{}, true},
+		{"120>", TempoRamp{}, true},
+		{"0>120", TempoRamp{}, true},
+		{"120>fast", TempoRamp{}, true},
+	} {
+		ramp, err := ParseTempoRamp(test.s)
+		if test.err {
+			assert.NotNil(t, err, test.s)
+			continue
+		}
+		assert.Nil(t, err, test.s)
+		assert.Equal(t, test.ramp, *ramp, test.s)
+	}
+}
+
+func TestTempoRamp(t *testing.T) {
+	log.SetLevel("info")
+	for _, test := range []struct {
+		text   string
+		tempos []int
+		starts []int64
+		total  int64
+	}{
+		// 4 beats from 60 to 120 take 60000000/15*ln(2) microseconds
+		{"run a\nc4(t60>120) d4 e4 f4", []int{60, 75, 90, 105}, []int64{0, 892574, 1621860, 2238463}, 2772589},
+		{"run a\nc4(t60>120:2) d4 e4 f4", []int{60, 90, 120, 120}, []int64{0, 810930, 1386294, 1886294}, 2386294},
+		{"run a\nc4(t120) d4 e4(t60) f4", []int{120, 120, 60, 60}, []int64{0, 500000, 1000000, 2000000}, 3000000},
+		// the tempo of a chain is taken over by the tempo of a step
+		{"run a\nc4 d4 e4(t90) f4\n\ntie a\ntempo 60>120:2\nout midi(synth)", []int{60, 90, 90, 90}, []int64{0, 810930, 1386294, 2052961}, 2719628},
+		// a ramp goes on until the end of its loop
+		{"run a\nc4(t60>120) d4 e4 f4\n\nrun b\ng4 a4\n\ntie a b\nout midi(synth)", []int{60, 75, 90, 105, 120, 120}, []int64{0, 892574, 1621860, 2238463, 2772589, 3772589}, 4772589},
+	} {
+		tli, err := NewOffline(test.text)
+		assert.Nil(t, err)
+		chain := tli.ChainsRendered[0]
+		tempos := []int{}
+		starts := []int64{}
+		for _, step := range chain.Steps {
+			tempos = append(tempos, step.Params.Tempo)
+			starts = append(starts, step.TimeStartMicroseconds)
+		}
+		assert.Equal(t, test.tempos, tempos, test.text)
+		assert.Equal(t, test.starts, starts, test.text)
+		assert.Equal(t, test.total, chain.MicrosecondsTotal, test.text)
+	}
+}
+
+func TestTempoMidi(t *testing.T) {
+	log.SetLevel("info")
+	tempos := func(text string) (bpms []float64, ticks []int64) {
+		tli, err := NewOffline(text)
+		assert.Nil(t, err)
+		var buf bytes.Buffer
+		assert.Nil(t, tli.WriteMidi(&buf))
+		s, err := smf.ReadFrom(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		for _, event := range s.Tracks[0] {
+			var bpm float64
+			if event.Message.GetMetaTempo(&bpm) {
+				bpms = append(bpms, bpm)
+			}
+		}
+		tick := int64(0)
+		for _, event := range s.Tracks[1] {
+			tick += int64(event.Delta)
+			var channel, key, velocity uint8
+			if event.Message.GetNoteStart(&channel, &key, &velocity) {
+				ticks = append(ticks, tick)
+			}
+		}
+		return
+	}
+	bpms, ticks := tempos("set bpm 90\n\nrun a\nc4 d4 e4 f4")
+	assert.Equal(t, 1, len(bpms))
+	assert.InDelta(t, 90, bpms[0], 0.001)
+	assert.Equal(t, []int64{0, 960, 1920, 2880}, ticks)
+
+	// a ramp is a tempo for every sixteenth and the notes stay on the beats
+	bpms, ticks = tempos("run a\nc4(t60>120) d4 e4 f4")
+	assert.Equal(t, 16, len(bpms))
+	assert.InDelta(t, 60, bpms[0], 2)
+	assert.InDelta(t, 120, bpms[15], 2)
+	assert.Equal(t, 4, len(ticks))
+	for i, tick := range ticks {
+		assert.InDelta(t, i*960, tick, 1)
+	}
+}
@@ -6,30 +6,58 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/goccy/go-json"
-	"github.com/loov/hrtime"
-	"github.com/schollz/aw/internal/crow"
-	"github.com/schollz/gomidi"
+	"github.com/schollz/aw/internal/util"
 	log "github.com/schollz/logger"
 )
 
-var crows crow.Murder
-var mutex sync.Mutex
-var midiDevices map[string]gomidi.Device
-
-func init() {
-	midiDevices = make(map[string]gomidi.Device)
-}
-
 type TLI struct {
-	TimePosition   []int64 `json:"time_position,omitempty"`
-	Chains         []Chain `json:"chains"`
-	ChainsRendered []Chain `json:"rendered"`
-	Loops          []Loop  `json:"loops"`
-	Params         Params  `json:"params"`
-	Playing        bool    `json:"playing"`
+	TimePosition   []int64                   `json:"time_position,omitempty"`
+	Chains         []Chain                   `json:"chains"`
+	ChainsRendered []Chain                   `json:"rendered"`
+	Loops          []Loop                    `json:"loops"`
+	Lanes          []Lane                    `json:"lanes"`
+	Defs           map[string]string         `json:"defs"`
+	Devices        map[string]string         `json:"devices"`
+	DrumMaps       map[string]map[string]int `json:"drum_maps"`
+	Song           []Section                 `json:"song"`
+	Tuning         *Tuning                   `json:"tuning,omitempty"`
+	Script         string                    `json:"script"`
+	Text           string                    `json:"text"`
+	Params         Params                    `json:"params"`
+	Meter          Meter                     `json:"meter"`
+	transport      transport
+	// rendered is the snapshot of the rendered chains that is read
+	// without waiting on the sequencer
+	rendered  atomic.Pointer[[]Chain]
+	startTime time.Duration
+	// clock is what the sequencer plays in time with
+	clock Clock
+	// section is the index of the section of the song that is playing
+	// and songOffset is when the song started after startTime
+	section    int
+	songOffset int64
+	// mu guards the rendered chains and time positions while playing
+	mu sync.Mutex
+	// devices are shared with the other sequencers and outputs are
+	// the keys of the devices this sequencer is using
+	devices *DeviceManager
+	outputs map[string]bool
+	// voices are the notes that are sounding
+	voices voices
+	// warnings are errors that do not stop the text from loading,
+	// like a device that could not be found
+	warnings []string
+	// tempo is the tempo of the text before any SetTempo
+	tempo int
+	// aliases are the device aliases given to the sequencer, like those of
+	// a project, Devices has these and the aliases of the text on top
+	aliases map[string]string
+	// cycles are the next cycles of chains that generate again, by index
+	cycles map[int]*cycle
 }
 
 type Params struct {
@@ -65,7 +93,7 @@ func (p Params) CheckSet(param int) bool {
 	return p.IsSet&param != 0
 }
 
-func (t TLI) String() string {
+func (t *TLI) String() string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("params: %+v", t.Params))
 	for _, c := range t.Chains {
@@ -83,17 +111,33 @@ func (t TLI) String() string {
 }
 
 type Chain struct {
+	Name              string     `json:"name"`
 	NameLoop          []string   `json:"loops"`
 	Outs              []string   `json:"outs"`
 	OutFns            []Function `json:"out_fns"`
 	Steps             []Step     `json:"steps"` // filled in with Render()
 	BeatsTotal        float64    `json:"beats_total"`
 	MicrosecondsTotal int64      `json:"microseconds_total"`
+	Muted             bool       `json:"muted"`
+	Soloed            bool       `json:"soloed"`
+	Stopped           bool       `json:"stopped"`
+	TimeOffset        int64      `json:"time_offset"` // microseconds after the transport started that the chain was launched
+	StepCurrent       int        `json:"step_current"`
+	// VoiceLead moves each chord to the inversion closest to the chord before it
+	VoiceLead bool `json:"voice_lead,omitempty"`
+	// Transforms are applied to the steps before they are rendered, see SplitTransforms
+	Transforms []Arg `json:"transforms,omitempty"`
+	// Tempo is the tempo of the chain from a line like "tempo 100>140",
+	// Tempos is the tempo over the beats of the chain
+	Tempo  *TempoRamp     `json:"tempo,omitempty"`
+	Tempos []TempoSegment `json:"tempos,omitempty"`
+	// LaneRoutes are the outs of the chain that send the values of a lane
+	LaneRoutes []LaneRoute `json:"lanes,omitempty"`
 }
 
 func (c Chain) String() string {
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("chain: %v", c.NameLoop))
+	sb.WriteString(fmt.Sprintf("chain '%s': %v", c.Name, c.NameLoop))
 	sb.WriteString(fmt.Sprintf("\nbeats total: %v", c.BeatsTotal))
 	sb.WriteString(fmt.Sprintf("\nmicroseconds total: %v", c.MicrosecondsTotal))
 	for _, s := range c.Steps {
@@ -104,61 +148,22 @@ func (c Chain) String() string {
 	return sb.String()
 }
 
-func PlayNote(notes []Note, on bool, outFns []Function) (err error) {
-	for _, out := range outFns {
-		log.Debugf("[%+v] note %v: %+v", out, on, notes)
-		switch out.Name {
-		case "midi":
-			var output string
-			output, err = out.GetStringPlace("name", 0)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			channel, _ := out.GetIntPlace("ch", 1)
-			log.Tracef("midi out: %s %d", output, channel)
-			for _, note := range notes {
-				if on {
-					midiDevices[output].NoteOn(uint8(channel), uint8(note.Midi), 120)
-				} else {
-					midiDevices[output].NoteOff(uint8(channel), uint8(note.Midi))
-				}
-			}
-		case "crow":
-			// crow out
-			var output int
-			output, err = out.GetIntPlace("output", 0)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			if crows.IsReady {
-				for i, note := range notes {
-					j := i * 2
-					if on {
-						crows.SetVoltage(output+j, float64(note.Midi-12.0)/12.0)
-					}
-					if crows.UseEnv[output] > 0 {
-						crows.On(crows.UseEnv[output], on)
-					}
-				}
-			}
-		case "sc":
-			// sc out
-		}
-	}
-
-	// for _, note := range notes {
-	// 	sc.Send(sc.Options{Instrument: "jp2", Note: note.Midi, On: true})
-	// }
-	return
-}
-
 type Loop struct {
-	Name             string `json:"name"`
-	Steps            []Step `json:"steps"`
+	Name       string `json:"name"`
+	Steps      []Step `json:"steps"`
+	Regenerate bool   `json:"regenerate"`
+	// Drums is the drum map of a drum loop
+	Drums            string `json:"drums,omitempty"`
 	lastMidiNote     int
+	lastRootNote     int
 	lastBeatsPerLine int
+	defs             map[string]string
+	drumMaps         map[string]map[string]int
+	script           string
+	// lines are the lines of the loop, to parse again when it generates again
+	lines []string
+	// skipGenerators leaves lua() tokens as they are instead of running them
+	skipGenerators bool
 }
 
 func LoopNew() Loop {
@@ -177,6 +182,25 @@ type Step struct {
 	Token                    string  `json:"token,omitempty"`
 	Arguments                []Arg   `json:"arguments,omitempty"`
 	Params                   Params  `json:"params"`
+	// Pressure and Timbre are the channel pressure and cc74 of the notes on
+	// mpe outputs, and the notes glide from GlideFrom over the first Glide
+	// percent of the step
+	Pressure  *Slide `json:"pressure,omitempty"`
+	Timbre    *Slide `json:"timbre,omitempty"`
+	Glide     int    `json:"glide,omitempty"`
+	GlideFrom int    `json:"glide_from,omitempty"`
+	// Arp plays the notes one at a time, see arpeggiate
+	Arp *Arp `json:"arp,omitempty"`
+	// Beats is the explicit duration of a step like "c4:q", which
+	// it lasts instead of its share of the line
+	Beats float64 `json:"beats,omitempty"`
+	// Bar and Beat are where the step starts in the meter, counting from 1
+	Bar  int     `json:"bar,omitempty"`
+	Beat float64 `json:"beat,omitempty"`
+	// Ramp is a tempo ramp like "t120>140" that starts on the step
+	Ramp *TempoRamp `json:"ramp,omitempty"`
+	// loop is the index of the loop of the chain the step is from
+	loop int
 }
 
 func (s Step) String() string {
@@ -190,6 +214,9 @@ type Note struct {
 	NameOriginal string `json:"name_original,omitempty"`
 	IsRest       bool   `json:"is_rest,omitempty"`
 	IsLegato     bool   `json:"is_legato,omitempty"`
+	// Velocity is the velocity of a note in a lane of a drum line, which
+	// plays instead of the velocity of the step when it is set
+	Velocity int `json:"velocity,omitempty"`
 }
 
 func NoteAdd(note Note, interval int) (result Note) {
@@ -208,17 +235,35 @@ const (
 	StateLoop
 	StateChain
 	StateSet
+	StateScript
+	StateSong
+	StateLane
 )
 
 func New(text string) (tli *TLI, err error) {
+	return newWithDevices(text, nil, SharedDevices)
+}
+
+// NewOffline parses and renders the text without opening any devices,
+// for rendering the text to a file
+func NewOffline(text string) (tli *TLI, err error) {
+	return newWithDevices(text, nil, offlineDevices)
+}
+
+func newWithDevices(text string, devices map[string]string, manager *DeviceManager) (tli *TLI, err error) {
 	tli = new(TLI)
 	tli.Params = Params{Tempo: 120}
 	tli.TimePosition = make([]int64, 128)
+	tli.aliases = devices
+	tli.devices = manager
+	tli.outputs = make(map[string]bool)
+	tli.clock = SystemClock
 	err = tli.ParseText(text)
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	tli.tempo = tli.Params.Tempo
 	err = tli.Render()
 	if err != nil {
 		log.Error(err)
@@ -226,25 +271,55 @@ func New(text string) (tli *TLI, err error) {
 	}
 	b, _ := json.Marshal(tli.Chains)
 	json.Unmarshal(b, &tli.ChainsRendered)
+	tli.publish()
+	tli.warnings = append(tli.warnings, tli.checkSong()...)
 
 	return
 }
 
 func (tli *TLI) Update(text string) (err error) {
-	tliTest, err := New(text)
+	tli.mu.Lock()
+	devices, manager := tli.aliases, tli.devices
+	tli.mu.Unlock()
+	tliTest, err := newWithDevices(text, devices, manager)
 	if err != nil {
 		log.Error(err)
 		return
 	}
 	// copy over the rendered chains
-	mutex.Lock()
-	tliTest.Playing = tli.Playing
+	tli.mu.Lock()
+	tliTest.copyChainState(tli.ChainsRendered)
 	for i, v := range tli.TimePosition {
 		tliTest.TimePosition[i] = v
 	}
 	b, _ := json.Marshal(tliTest)
+	tli.Defs = nil
+	tli.Devices = nil
+	tli.DrumMaps = nil
+	tli.Tuning = nil
+	// decoding into the old chains would change the snapshots
+	tli.Chains = nil
+	tli.ChainsRendered = nil
+	tli.Loops = nil
 	json.Unmarshal(b, &tli)
-	mutex.Unlock()
+	// loops keep the lines they generate again from
+	tli.Loops = tliTest.Loops
+	tli.tempo = tliTest.tempo
+	tli.cycles = nil
+	tli.publish()
+	// the new text holds its own devices, so let go of the old ones
+	outputs := tli.outputs
+	tli.devices = tliTest.devices
+	tli.outputs = tliTest.outputs
+	// notes of chains that are gone would never be let go of
+	tli.releaseRemoved()
+	tli.mu.Unlock()
+	for key := range outputs {
+		tli.devices.Release(key)
+	}
+	if len(tliTest.warnings) > 0 {
+		err = fmt.Errorf("%s", strings.Join(tliTest.warnings, "; "))
+	}
 	return
 }
 
@@ -252,8 +327,10 @@ func (tli *TLI) ParseText(text string) (err error) {
 	lines := strings.Split(text, "\n")
 	loop := LoopNew()
 	chain := Chain{}
+	lane := Lane{}
 	tli.Loops = []Loop{}
 	tli.Chains = []Chain{}
+	tli.Lanes = []Lane{}
 	fnFinish := func() {
 		if len(loop.Steps) > 0 {
 			tli.Loops = append(tli.Loops, loop)
@@ -263,9 +340,66 @@ func (tli *TLI) ParseText(text string) (err error) {
 			tli.Chains = append(tli.Chains, chain)
 			chain = Chain{}
 		}
+		if len(lane.Points) > 0 {
+			tli.Lanes = append(tli.Lanes, lane)
+		}
+		lane = Lane{}
+	}
+	// device aliases from the text are added to any given aliases
+	devices := make(map[string]string)
+	for k, v := range tli.aliases {
+		devices[k] = v
 	}
+	tli.Devices = devices
+	tli.Text = text
+	// gather the defs and scripts first so they can be used anywhere
+	tli.Defs = make(map[string]string)
+	tli.DrumMaps = make(map[string]map[string]int)
+	var script strings.Builder
+	inScript := false
+	inSet := false
+	for _, line := range lines {
+		if inScript {
+			if !blockStartRegex.MatchString(strings.TrimSpace(line)) {
+				script.WriteString(line + "\n")
+				continue
+			}
+			inScript = false
+		}
+		if strings.TrimSpace(line) == "script" {
+			inScript = true
+			continue
+		}
+		line = strings.TrimSpace(strings.Split(line, "//")[0])
+		if blockStartRegex.MatchString(line) {
+			inSet = strings.HasPrefix(line, "set")
+		} else if inSet && strings.HasPrefix(line, "map ") {
+			name, drums, errMap := ParseDrumMap(line)
+			if errMap != nil {
+				log.Error(errMap)
+				tli.warnings = append(tli.warnings, errMap.Error())
+			} else {
+				tli.DrumMaps[name] = drums
+			}
+		}
+		if strings.HasPrefix(line, "def ") {
+			name, phrase, errDef := ParseDef(line)
+			if errDef != nil {
+				err = errDef
+				log.Error(err)
+				return
+			}
+			tli.Defs[name] = phrase
+		}
+	}
+	tli.Script = script.String()
+	tli.Song = []Section{}
+	tli.Tuning = nil
+	tli.Meter = DefaultMeter
 	// look for loop
 	state := StateNone
+	// include is the last include line when no block has started since
+	include := ""
 	for _, line := range lines {
 		// skip comments
 		line = strings.Split(line, "//")[0]
@@ -274,18 +408,63 @@ func (tli *TLI) ParseText(text string) (err error) {
 			continue
 		}
 		line = strings.TrimSpace(line)
-		if len(line) == 0 {
+		if len(line) == 0 || strings.HasPrefix(line, "def ") {
+			continue
+		}
+		// an include ends the block before it, which may be the last block
+		// of the included file
+		if strings.HasPrefix(line, "include ") {
+			fnFinish()
+			state = StateNone
+			include = line
 			continue
 		}
-		if strings.HasPrefix(line, "run") {
+		if blockStartRegex.MatchString(line) {
+			include = ""
+		}
+		if state == StateScript && !blockStartRegex.MatchString(line) {
+			continue
+		}
+		if line == "script" {
+			fnFinish()
+			state = StateScript
+			continue
+		} else if line == "song" {
+			fnFinish()
+			state = StateSong
+			continue
+		} else if strings.HasPrefix(line, "run") {
 			fnFinish()
 			state = StateLoop
-			loop.Name = strings.Split(line, " ")[1]
+			// a loop like "run drums(map=gm)" is a drum loop
+			fn, _ := ParseFunction(strings.TrimSpace(strings.TrimPrefix(line, "run")))
+			loop.Name = fn.Name
+			loop.Drums, _ = fn.GetString("map")
+			loop.drumMaps = tli.DrumMaps
+			loop.defs = tli.Defs
+			loop.script = tli.Script
+			continue
+		} else if strings.HasPrefix(line, "lane") {
+			fnFinish()
+			state = StateLane
+			var errLane error
+			lane, errLane = ParseLane(line)
+			if errLane != nil {
+				log.Error(errLane)
+				tli.warnings = append(tli.warnings, errLane.Error())
+			}
 			continue
 		} else if strings.HasPrefix(line, "tie") {
 			fnFinish()
 			state = StateChain
-			chain.NameLoop, err = ParseChain(line)
+			header, transforms, errTransforms := SplitTransforms(line)
+			if errTransforms != nil {
+				log.Error(errTransforms)
+				tli.warnings = append(tli.warnings, errTransforms.Error())
+			}
+			chain.Name = strings.TrimSpace(strings.TrimPrefix(header, "tie"))
+			chain.Transforms = transforms
+			chain.NameLoop, err = ParseChain(header)
 			log.Debugf("parsed chain: '%s' -> %+v", line, chain.NameLoop)
 			if err != nil {
 				log.Error(err)
@@ -294,25 +473,56 @@ func (tli *TLI) ParseText(text string) (err error) {
 			// parse chain
 		} else if strings.HasPrefix(line, "set") {
 			state = StateSet
+			// a setting can be on the set line, like "set meter 7/8"
+			if setting := strings.TrimSpace(strings.TrimPrefix(line, "set")); setting != "" {
+				tli.set(setting)
+			}
 		} else {
 			switch state {
 			case StateLoop:
-				err = loop.AddLine(line)
-				if err != nil {
-					log.Error(err)
+				loop.lines = append(loop.lines, line)
+				if errAdd := loop.AddLine(line); errAdd != nil {
+					log.Error(errAdd)
+					tli.warnings = append(tli.warnings, errAdd.Error())
+				}
+			case StateLane:
+				if lane.Name == "" {
+					continue
+				}
+				if errAdd := lane.AddLine(line); errAdd != nil {
+					log.Error(errAdd)
+					tli.warnings = append(tli.warnings, errAdd.Error())
+				}
+			case StateSong:
+				section, errSection := ParseSection(line)
+				if errSection != nil {
+					log.Error(errSection)
+					tli.warnings = append(tli.warnings, errSection.Error())
+				} else {
+					tli.Song = append(tli.Song, section)
 				}
 			case StateChain:
 				// parse chain
 				if strings.HasPrefix(line, "out") {
 					chain.Outs = append(chain.Outs, strings.TrimSpace(strings.TrimPrefix(line, "out")))
+				} else if line == "voicelead" {
+					chain.VoiceLead = true
+				} else if strings.HasPrefix(line, "tempo ") {
+					ramp, errRamp := ParseTempoRamp(strings.TrimSpace(strings.TrimPrefix(line, "tempo")))
+					if errRamp != nil {
+						log.Error(errRamp)
+						tli.warnings = append(tli.warnings, errRamp.Error())
+					} else {
+						chain.Tempo = ramp
+					}
 				}
 			case StateSet:
-				// parse set
-				if strings.HasPrefix(line, "bpm") {
-					val, errParse := strconv.Atoi(strings.Fields(line)[1])
-					if errParse == nil {
-						tli.Params.Set(TempoSet, val)
-					}
+				tli.set(line)
+			case StateNone:
+				if include != "" {
+					warning := fmt.Sprintf("'%s' is not in a block, start a block after '%s'", line, include)
+					log.Error(warning)
+					tli.warnings = append(tli.warnings, warning)
 				}
 			}
 		}
@@ -329,27 +539,101 @@ func (tli *TLI) ParseText(text string) (err error) {
 		}
 		tli.Chains = append(tli.Chains, chain)
 	}
+	uniqueChainNames(tli.Chains)
 
 	return
 
 }
 
-func (p *Loop) AddLine(line string) (err error) {
-	line = SanitizeLine(line)
-	line = ExpandMultiplication(line)
-	tokens, err := TokenizeLineString(line)
-	if err != nil {
-		log.Error(err)
-		return
+// uniqueChainNames numbers chains that share a name, like "a" and "a (2)",
+// so that each chain can be found by its name
+func uniqueChainNames(chains []Chain) {
+	names := make([]string, len(chains))
+	for i := range chains {
+		names[i] = chains[i].Name
 	}
-	tokens, err = RetokenizeArpeggioArgument(tokens)
-	if err != nil {
-		log.Error(err)
-		return
+	for i, name := range UniqueChainNames(names) {
+		chains[i].Name = name
 	}
-	tokens, err = TokenizeLineString(TokenExpandToLine(tokens))
+}
+
+// ChainAt returns the name of the `tie` chain that the last of the lines
+// is in, or "" when it is not in a tie block
+func ChainAt(lines []string) string {
+	for y := len(lines) - 1; y >= 0; y-- {
+		line := strings.TrimSpace(lines[y])
+		if strings.HasPrefix(line, "tie") {
+			// chains that share a name are numbered in the order of their blocks
+			names := []string{}
+			for i := 0; i <= y; i++ {
+				if tie := strings.TrimSpace(lines[i]); strings.HasPrefix(tie, "tie") {
+					header, _, _ := SplitTransforms(tie)
+					names = append(names, strings.TrimSpace(strings.TrimPrefix(header, "tie")))
+				}
+			}
+			names = UniqueChainNames(names)
+			return names[len(names)-1]
+		} else if blockStartRegex.MatchString(line) {
+			break
+		}
+	}
+	return ""
+}
+
+// UniqueChainNames returns the names of chains in the order of their tie
+// blocks, with a number added to any name that was used before
+func UniqueChainNames(names []string) (unique []string) {
+	taken := make(map[string]bool)
+	for _, name := range names {
+		u := name
+		for n := 2; taken[u]; n++ {
+			u = fmt.Sprintf("%s (%d)", name, n)
+		}
+		unique = append(unique, u)
+		taken[u] = true
+	}
+	return
+}
+
+// set parses a line of a set block
+func (tli *TLI) set(line string) {
+	if strings.HasPrefix(line, "map ") {
+		// drum maps were found already
+	} else if strings.HasPrefix(line, "tuning ") {
+		scl, kbm, errTuning := ParseTuning(line)
+		if errTuning == nil {
+			tli.Tuning, errTuning = LoadTuning(scl, kbm)
+		}
+		if errTuning != nil {
+			tli.Tuning = nil
+			log.Error(errTuning)
+			tli.warnings = append(tli.warnings, errTuning.Error())
+		}
+	} else if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+		// device alias, e.g. synth = "UM-ONE MIDI 1"
+		tli.Devices[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+	} else if strings.HasPrefix(line, "bpm") {
+		val, errParse := strconv.Atoi(strings.Fields(line)[1])
+		if errParse == nil {
+			tli.Params.Set(TempoSet, val)
+		}
+	} else if strings.HasPrefix(line, "meter ") {
+		meter, errMeter := ParseMeter(strings.TrimPrefix(line, "meter "))
+		if errMeter != nil {
+			log.Error(errMeter)
+			tli.warnings = append(tli.warnings, errMeter.Error())
+		} else {
+			tli.Meter = meter
+		}
+	}
+}
+
+func (p *Loop) AddLine(line string) (err error) {
+	if p.Drums != "" {
+		return p.addDrumLine(line)
+	}
+	tokens, err := p.expandLine(line)
 	if err != nil {
-		log.Error(err)
 		return
 	}
 	log.Debugf("tokens: %+v", tokens)
@@ -357,15 +641,28 @@ func (p *Loop) AddLine(line string) (err error) {
 	for _, token := range tokens {
 		fn, _ := ParseFunction(token)
 		log.Debugf("fn: %v, args: %v", fn.Name, fn.Args)
+		// a token like "c4:q" lasts as long as it says
+		name, beats, errDuration := splitDuration(fn.Name)
+		if errDuration != nil {
+			err = errDuration
+			return
+		}
+		fn.Name = name
 		notes, errPhrase := ParseChord(fn.Name, p.lastMidiNote)
 		if errPhrase != nil {
 			notes, errPhrase = ParseMidi(fn.Name, p.lastMidiNote)
 		}
-		step := Step{BeatsPerLine: p.lastBeatsPerLine, Token: token}
+		step := Step{BeatsPerLine: p.lastBeatsPerLine, Token: token, Beats: beats}
 		step.Arguments = fn.Args
+		// notes glide from the first note of the last step with notes
+		glideFrom := p.lastRootNote
 		if errPhrase == nil {
+			if hasVoicing(fn.Args) {
+				notes = VoiceChord(notes, chordRoot(fn.Name, notes), fn.Args)
+			}
 			log.Debugf("notes: %+v", notes)
 			p.lastMidiNote = notes[len(notes)-1].Midi
+			p.lastRootNote = notes[0].Midi
 			step.Notes = notes
 		} else {
 			// check for rest or legato
@@ -377,120 +674,223 @@ func (p *Loop) AddLine(line string) (err error) {
 				continue
 			}
 		}
-		for i := 0; i < len(fn.Args); i++ {
-			decorator := fn.Args[i].Value
-			if strings.HasPrefix(decorator, "t") {
-				tempo, errParse := strconv.Atoi(decorator[1:])
-				if errParse == nil {
-					step.Params.Set(TempoSet, tempo)
-				}
-			} else if strings.HasPrefix(decorator, "b") {
-				beats, errParse := strconv.Atoi(decorator[1:])
-				if errParse == nil {
-					step.BeatsPerLine = beats
-					p.lastBeatsPerLine = beats
-				}
-			} else if strings.HasPrefix(decorator, "v") {
-				velocity, errParse := strconv.Atoi(decorator[1:])
-				if errParse == nil {
-					step.Params.Set(VelocitySet, velocity)
-				}
-			} else if strings.HasPrefix(decorator, "h") {
-				gate, errParse := strconv.Atoi(decorator[1:])
-				if errParse == nil {
-					step.Params.Set(GateSet, gate)
-				}
-			}
+		p.decorate(&step, fn.Args)
+		if step.Glide > 0 {
+			step.GlideFrom = glideFrom
 		}
 		step.StepLineCount = len(tokens)
 		steps = append(steps, step)
 	}
+	shareLeftover(steps)
 	p.Steps = append(p.Steps, steps...)
 	return
 }
 
-func (tli *TLI) Render() (err error) {
-
-	for i := 0; i < len(tli.Chains); i++ {
-		for _, loopName := range tli.Chains[i].NameLoop {
-			for _, loop := range tli.Loops {
-				if loop.Name == loopName {
-					tli.Chains[i].Steps = append(tli.Chains[i].Steps, loop.Steps...)
-				}
-			}
+// shareLeftover gives the steps of a line without an explicit duration an
+// equal share of the beats that the steps with one leave in the line. When
+// nothing is left they keep their share of the whole line, which makes the
+// line last longer.
+func shareLeftover(steps []Step) {
+	explicit := 0.0
+	implicit := 0
+	for _, step := range steps {
+		if step.Beats > 0 {
+			explicit += step.Beats
+		} else {
+			implicit++
 		}
-		// check if there are any steps
-		if len(tli.Chains[i].Steps) == 0 {
-			continue
+	}
+	if explicit == 0 || implicit == 0 {
+		return
+	}
+	for i := range steps {
+		if left := float64(steps[i].BeatsPerLine) - explicit; steps[i].Beats == 0 && left > 0 {
+			steps[i].Beats = left / float64(implicit)
 		}
-		// set the tempo on each step
-		lastTempo := tli.Params.Tempo
-		for j := 0; j < len(tli.Chains[i].Steps); j++ {
-			if tli.Chains[i].Steps[j].Params.CheckSet(TempoSet) {
-				log.Tracef("setting tempo to %d from step: %+v", tli.Chains[i].Steps[j].Params.Tempo, tli.Chains[i].Steps[j])
-				lastTempo = tli.Chains[i].Steps[j].Params.Tempo
+	}
+}
+
+// expandLine expands the defs, multiplications, generators, arpeggios and
+// groups of a line into tokens that each last the same amount of time
+func (p *Loop) expandLine(line string) (tokens []string, err error) {
+	line = SanitizeLine(line)
+	line, err = ExpandMacros(line, p.defs)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	line = ExpandMultiplication(line)
+	tokens, err = TokenizeLineString(line)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	tokens, err = p.expandGenerators(tokens)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	tokens, err = RetokenizeArpeggioArgument(tokens)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	tokens, err = TokenizeLineString(TokenExpandToLine(tokens))
+	if err != nil {
+		log.Error(err)
+	}
+	return
+}
+
+// decorate sets the tempo, beats, velocity and gate of a step from its decorators
+func (p *Loop) decorate(step *Step, args []Arg) {
+	for i := 0; i < len(args); i++ {
+		decorator := args[i].Value
+		if decorator == "arp" || strings.HasPrefix(decorator, "arp(") {
+			arp, errArp := ParseArp(decorator)
+			if errArp != nil {
+				log.Error(errArp)
+				continue
 			}
-			tli.Chains[i].Steps[j].Params.Tempo = lastTempo
-		}
-		// set the gate on each step
-		lastGate := 95
-		for j := 0; j < len(tli.Chains[i].Steps); j++ {
-			if tli.Chains[i].Steps[j].Params.CheckSet(GateSet) {
-				lastGate = tli.Chains[i].Steps[j].Params.Gate
+			step.Arp = arp
+		} else if strings.HasPrefix(decorator, "t") && strings.Contains(decorator, ">") {
+			ramp, errRamp := ParseTempoRamp(decorator[1:])
+			if errRamp != nil {
+				log.Error(errRamp)
+				continue
+			}
+			step.Params.Set(TempoSet, int(math.Round(ramp.Start)))
+			step.Ramp = ramp
+		} else if strings.HasPrefix(decorator, "t") {
+			tempo, errParse := strconv.Atoi(decorator[1:])
+			if errParse == nil {
+				step.Params.Set(TempoSet, tempo)
+			}
+		} else if strings.HasPrefix(decorator, "b") {
+			beats, errParse := strconv.Atoi(decorator[1:])
+			if errParse == nil {
+				step.BeatsPerLine = beats
+				p.lastBeatsPerLine = beats
+			}
+		} else if strings.HasPrefix(decorator, "v") {
+			velocity, errParse := strconv.Atoi(decorator[1:])
+			if errParse == nil {
+				step.Params.Set(VelocitySet, velocity)
+			}
+		} else if strings.HasPrefix(decorator, "h") {
+			gate, errParse := strconv.Atoi(decorator[1:])
+			if errParse == nil {
+				step.Params.Set(GateSet, gate)
+			}
+		} else if strings.HasPrefix(decorator, "p") {
+			if slide, ok := parseSlide(decorator[1:]); ok {
+				step.Pressure = slide
+			}
+		} else if strings.HasPrefix(decorator, "y") {
+			if slide, ok := parseSlide(decorator[1:]); ok {
+				step.Timbre = slide
+			}
+		} else if strings.HasPrefix(decorator, "g") {
+			// "g" glides over the whole step and "g25" over a quarter of it
+			glide, errParse := strconv.Atoi(decorator[1:])
+			if decorator == "g" {
+				glide, errParse = 100, nil
+			}
+			if errParse == nil {
+				step.Glide = util.Clamp(glide, 0, 100)
 			}
-			tli.Chains[i].Steps[j].Params.Gate = lastGate
 		}
-		tli.Chains[i].Render()
+	}
+}
+
+func (tli *TLI) Render() (err error) {
+	for i := range tli.Chains {
+		tli.warnings = append(tli.warnings, tli.renderChain(&tli.Chains[i], tli.Loops, tli.Params.Tempo)...)
 	}
 	// setup outputs
 	for _, chain := range tli.Chains {
-		for _, fn := range chain.OutFns {
-			switch fn.Name {
-			case "midi":
-				name, errFind := fn.GetStringPlace("output", 0)
-				if errFind == nil {
-					if _, ok := midiDevices[name]; !ok {
-						var err error
-						midiDevices[name], err = gomidi.New(name)
-						if err != nil {
-							log.Error(err)
-						} else {
-							midiDevices[name].Open()
-						}
-					}
-				}
-			case "crow":
-				if !crows.IsReady {
-					crows, err = crow.New()
-					if err != nil {
-						log.Error(err)
-					}
-				}
-				if crows.IsReady {
-					var val float64
-					var err error
-					var output int
-					output, _ = fn.GetIntPlace("output", 0)
-					if output > 0 {
-						crows.UseEnv[output], _ = fn.GetInt("env")
-
-						if val, err = fn.GetFloat("slew"); err == nil {
-							crows.SetSlew(output, val)
-						}
-
-					}
+		outs := append([]Function{}, chain.OutFns...)
+		for _, route := range chain.LaneRoutes {
+			outs = append(outs, route.Out)
+		}
+		for _, fn := range outs {
+			if errSetup := tli.setupOutput(fn); errSetup != nil {
+				log.Error(errSetup)
+				tli.warnings = append(tli.warnings, errSetup.Error())
+			}
+		}
+	}
+	return
+}
 
+// renderChain renders the steps of a chain from the steps of its loops
+// at a tempo, it returns a warning for anything that could not be rendered
+func (tli *TLI) renderChain(chain *Chain, loops []Loop, tempo int) (warnings []string) {
+	for k, loopName := range chain.NameLoop {
+		for _, loop := range loops {
+			if loop.Name == loopName {
+				for _, step := range loop.Steps {
+					step.loop = k
+					chain.Steps = append(chain.Steps, step)
 				}
 			}
 		}
 	}
+	// check if there are any steps
+	if len(chain.Steps) == 0 {
+		return
+	}
+	// set the gate on each step
+	lastGate := 95
+	for j := 0; j < len(chain.Steps); j++ {
+		if chain.Steps[j].Params.CheckSet(GateSet) {
+			lastGate = chain.Steps[j].Params.Gate
+		}
+		chain.Steps[j].Params.Gate = lastGate
+	}
+	chain.Steps = transform(chain.Steps, chain.Transforms)
+	chain.setTempos(tempo)
+	if chain.VoiceLead {
+		voiceLead(chain.Steps)
+	}
+	chain.Render()
+	chain.markBars(tli.Meter)
+	warnings = chain.routeLanes(tli.Lanes)
+	tli.resolveDevices(chain)
 	return
 }
 
+// setupOutput opens the device of an out function if this
+// sequencer is not using it yet
+func (tli *TLI) setupOutput(fn Function) (err error) {
+	key := deviceKey(fn)
+	if key != "" && !tli.outputs[key] {
+		key, err = tli.devices.Acquire(fn)
+		if err != nil {
+			return
+		}
+		tli.outputs[key] = true
+	}
+	if fn.Name == "crow" {
+		err = tli.devices.configureCrow(fn)
+	}
+	return
+}
+
+// Close stops the sequencer and lets go of its devices
+func (tli *TLI) Close() {
+	tli.Stop()
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	for key := range tli.outputs {
+		tli.devices.Release(key)
+	}
+	tli.outputs = make(map[string]bool)
+}
+
 func (c *Chain) Render() {
 	// figure out the beats alloted to each
 	beatsTotal := 0.0
-	microSecondsTotal := int64(0)
 	for i := 0; i < len(c.Steps); i++ {
 		for _, note := range c.Steps[i].Notes {
 			if !note.IsRest && !note.IsLegato {
@@ -500,10 +900,10 @@ func (c *Chain) Render() {
 		}
 		if c.Steps[i].IsNote {
 			c.Steps[i].BeatsStart = beatsTotal
-			c.Steps[i].TimeStartMicroseconds = microSecondsTotal
-			c.Steps[i].BeatsDuration = float64(c.Steps[i].BeatsPerLine) / float64(c.Steps[i].StepLineCount)
-			c.Steps[i].TimeDurationMicroseconds = int64(c.Steps[i].BeatsDuration * float64(60000000) / float64(c.Steps[i].Params.Tempo))
-			// find how many steps until the next not or rest
+			c.Steps[i].BeatsDuration = c.Steps[i].beats()
+			// find how many steps until the next not or rest,
+			// an arpeggio that is latched keeps going through rests
+			latched := c.Steps[i].Arp != nil && c.Steps[i].Arp.Latch
 			isStop := false
 			for jj := i + 1; jj < len(c.Steps)*2; jj++ {
 				j := jj
@@ -514,7 +914,7 @@ func (c *Chain) Render() {
 					j -= len(c.Steps)
 				}
 				for _, note := range c.Steps[j].Notes {
-					if (!note.IsRest && !note.IsLegato) || note.IsRest {
+					if (!note.IsRest && !note.IsLegato) || (note.IsRest && !latched) {
 						isStop = true
 						break
 					}
@@ -522,12 +922,12 @@ func (c *Chain) Render() {
 				if isStop {
 					break
 				}
-				c.Steps[i].BeatsDuration += float64(c.Steps[j].BeatsPerLine) / float64(c.Steps[j].StepLineCount)
-				c.Steps[i].TimeDurationMicroseconds += int64(float64(c.Steps[j].BeatsPerLine) / float64(c.Steps[j].StepLineCount) * float64(60000000) / float64(c.Steps[j].Params.Tempo))
+				c.Steps[i].BeatsDuration += c.Steps[j].beats()
 			}
+			// the time follows the tempo of the chain, which can change within a step
+			c.Steps[i].TimeStartMicroseconds, c.Steps[i].TimeDurationMicroseconds = c.span(c.Steps[i].BeatsStart, c.Steps[i].BeatsDuration)
 		}
-		beatsTotal += float64(c.Steps[i].BeatsPerLine) / float64(c.Steps[i].StepLineCount)
-		microSecondsTotal += int64(float64(c.Steps[i].BeatsPerLine) / float64(c.Steps[i].StepLineCount) * float64(60000000) / float64(c.Steps[i].Params.Tempo))
+		beatsTotal += c.Steps[i].beats()
 	}
 	// remove all steps that don't have beats_start
 	newSteps := []Step{}
@@ -543,75 +943,102 @@ func (c *Chain) Render() {
 			log.Error(errFn)
 			continue
 		}
+		// outs with a lane send its values instead of notes
+		if lane, errLane := fn.GetString("lane"); errLane == nil {
+			c.LaneRoutes = append(c.LaneRoutes, LaneRoute{Lane: lane, Out: fn})
+			continue
+		}
 		c.OutFns = append(c.OutFns, fn)
 	}
 	log.Tracef("OutFns: %+v", c.OutFns)
 
 	c.Steps = newSteps
+	c.arpeggiate()
 	c.BeatsTotal = beatsTotal
-	c.MicrosecondsTotal = microSecondsTotal
+	c.MicrosecondsTotal = c.microseconds(beatsTotal)
 }
 
 func (tli *TLI) Toggle() {
-	if tli.Playing {
+	if tli.IsPlaying() {
 		tli.Stop()
 	} else {
 		tli.Play()
 	}
 }
+
+// Stop stops playing, waits for the step that is playing to finish
+// and lets go of every note that is sounding
 func (tli *TLI) Stop() {
-	if tli.Playing {
+	// the loop to wait for is the one that was playing when it stopped,
+	// not one that started after. A loop that is starting can start
+	// playing at any time so that is tried first.
+	tli.mu.Lock()
+	if tli.move(TransportStarting, TransportStopping) || tli.move(TransportPlaying, TransportStopping) {
 		log.Debugf("stopping")
-		tli.Playing = false
+		close(tli.transport.stop)
+		emit(Event{Type: EventStop, Sequencer: tli})
 	}
+	done := tli.transport.done
+	tli.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	tli.mu.Lock()
+	tli.releaseVoices(tli.voices.all())
+	tli.mu.Unlock()
 }
 
+// Play starts the transport if it is stopped
 func (tli *TLI) Play() {
-	if len(tli.ChainsRendered) > 0 {
-		go tli.run()
-	}
-}
-
-func setCrowAdsr(chain Chain, step Step, arg Arg) {
-	log.Trace("[setCrowAdsr] start")
-	for _, out := range chain.OutFns {
-		if out.Name == "crow" {
-			log.Trace("[setCrowAdsr] have crow out")
-			// set adsr
-			output, errInt := out.GetIntPlace("output", 0)
-			log.Tracef("[setCrowAdsr] output: %d", output)
-			log.Trace(errInt)
-			log.Trace(crows.IsReady)
-
-			if errInt == nil && crows.IsReady {
-				vals := SplitArgFloat(arg.Value)
-				log.Tracef("arg: %+v, vals: %+v", arg, vals)
-				if len(vals) == 4 {
-					log.Tracef("setting adsr: %+v", arg.Value)
-					log.Tracef("step: %+v", step)
-					for i, v := range vals {
-						if i != 2 {
-							vals[i] = v * float64(step.TimeDurationMicroseconds) / 1000000.0
-						}
-					}
-					crows.SetADSR(output+1, crow.ADSR{Attack: vals[0], Decay: vals[1], Sustain: vals[2], Release: vals[3]})
-				}
-			}
-		}
+	tli.mu.Lock()
+	if len(tli.ChainsRendered) == 0 || !tli.move(TransportStopped, TransportStarting) {
+		tli.mu.Unlock()
+		return
 	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	tli.transport.stop = stop
+	tli.transport.done = done
+	tli.mu.Unlock()
+	emit(Event{Type: EventPlay, Sequencer: tli})
+	go tli.run(stop, done)
 }
 
-// create a Run that takes an incoming bool channel to stop
-func (tli *TLI) run() {
-	if tli.Playing {
-		return
+// run plays the rendered chains until stop is closed,
+// it closes done when it returns
+func (tli *TLI) run(stop chan struct{}, done chan struct{}) {
+	tli.mu.Lock()
+	clock := tli.clock
+	tli.mu.Unlock()
+	ticks, handled, stopTicker := clock.NewTicker(tickInterval)
+	startTime, joined := clockStart(clock)
+	tli.mu.Lock()
+	tli.startTime = startTime
+	// a sequencer that joins others that are already playing
+	// starts on their next beat
+	offset := int64(0)
+	if joined && tli.Params.Tempo > 0 {
+		beat := int64(60000000 / tli.Params.Tempo)
+		offset = ((clock.Now() - startTime).Microseconds() + beat - 1) / beat * beat
 	}
-	tli.Playing = true
-	ticker := time.NewTicker(10 * time.Microsecond)
-	startTime := hrtime.Now()
 	for i := range tli.TimePosition {
 		tli.TimePosition[i] = -1
 	}
+	for i := range tli.ChainsRendered {
+		tli.ChainsRendered[i].TimeOffset = offset
+		tli.ChainsRendered[i].StepCurrent = 0
+	}
+	tli.section = -1
+	tli.songOffset = offset
+	tli.publish()
+	tli.mu.Unlock()
+	if !tli.move(TransportStarting, TransportPlaying) {
+		// stopped before it started
+		stopTicker()
+		clockStop(clock)
+		tli.transport.state.Store(int32(TransportStopped))
+		close(done)
+		return
+	}
 	go func() {
 		// catch panic
 		defer func() {
@@ -619,34 +1046,71 @@ func (tli *TLI) run() {
 				log.Error(r)
 			}
 		}()
+		defer close(done)
+		defer tli.transport.state.Store(int32(TransportStopped))
+		defer clockStop(clock)
+		defer stopTicker()
+		// the steps that are playing, Stop lets go of their notes
+		// if they are still open when the loop returns
+		gates := []gate{}
 
 		for {
 			select {
-			case <-ticker.C:
-				if !tli.Playing {
+			case <-stop:
+				log.Debug("not playing")
+				return
+			case now := <-ticks:
+				if tli.State() != TransportPlaying {
 					log.Debug("not playing")
 					return
 				}
-				mutex.Lock()
+				tli.mu.Lock()
+				stepped := false
+				elapsed := (now - startTime).Microseconds()
+				if len(tli.Song) > 0 {
+					tli.followSong(elapsed - tli.songOffset)
+				}
+				// notes are let go of before the notes of the next step play
+				gates = tli.closeGates(gates, now)
 				for i, chain := range tli.ChainsRendered {
-					// skip if no steps
-					if len(chain.Steps) == 0 {
+					// skip if no steps or if the chain was stopped on its own
+					if len(chain.Steps) == 0 || chain.Stopped {
+						continue
+					}
+					timePosition := elapsed - chain.TimeOffset
+					if timePosition < 0 {
 						continue
 					}
-					timePosition := hrtime.Since(startTime).Microseconds()
+					audible := tli.isAudible(i)
 					for {
 						if timePosition < chain.MicrosecondsTotal {
 							break
 						}
 						timePosition -= chain.MicrosecondsTotal
 					}
+					if tli.TimePosition[i] >= 0 && timePosition < tli.TimePosition[i] {
+						// a chain that generates again starts over with its next cycle
+						if next, ok := tli.nextCycle(i, elapsed-timePosition); ok {
+							chain = next
+						}
+					}
+					tli.wantCycle(i)
 					for stepi, step := range chain.Steps {
-						if !tli.Playing {
-							mutex.Unlock()
+						if tli.State() != TransportPlaying {
+							tli.mu.Unlock()
 							return
 						}
-						if (timePosition > step.TimeStartMicroseconds && tli.TimePosition[i] <= step.TimeStartMicroseconds) ||
+						if (timePosition >= step.TimeStartMicroseconds && tli.TimePosition[i] < step.TimeStartMicroseconds) ||
 							(timePosition < tli.TimePosition[i] && stepi == 0) {
+							tli.ChainsRendered[i].StepCurrent = stepi
+							stepped = true
+							if stepi == 0 && tli.TimePosition[i] >= 0 && timePosition < tli.TimePosition[i] {
+								emit(Event{Type: EventCycle, Sequencer: tli, Chain: chain.Name})
+							}
+							emit(Event{Type: EventStep, Sequencer: tli, Chain: chain.Name, Step: stepi})
+							if !audible {
+								continue
+							}
 							log.Info(timePosition, step.TimeStartMicroseconds, tli.TimePosition[i])
 							if len(step.Arguments) > 0 {
 								log.Infof("arguments: %+v", step.Arguments)
@@ -655,36 +1119,31 @@ func (tli *TLI) run() {
 								if strings.HasPrefix(arg.Value, "adsr") {
 									arg.Value = strings.TrimPrefix(arg.Value, "adsr=")
 									arg.Value = strings.TrimPrefix(arg.Value, "adsr")
-									setCrowAdsr(chain, step, arg)
+									tli.devices.setCrowAdsr(chain, step, arg)
 								}
 							}
-							PlayNote(step.Notes, true, chain.OutFns)
-							go func(s Step, c Chain) {
-								sleepMS := int64(math.Round(float64(s.TimeDurationMicroseconds) * float64(s.Params.Gate) / 100.0))
-								sleepStart := hrtime.Now()
-								for {
-									if hrtime.Since(sleepStart).Microseconds() > sleepMS {
-										break
-									}
-									time.Sleep(1 * time.Millisecond)
-								}
-								mutex.Lock()
-								if i < len(tli.Chains) {
-									PlayNote(s.Notes, false, c.OutFns)
-								}
-								if crows.NeedsFlush {
-									crows.Flush()
-								}
-								mutex.Unlock()
-							}(step, chain)
+							id, retriggered := tli.voices.start(chain.Name, false, step, chain.OutFns, tli.Tuning)
+							tli.releaseVoices(retriggered)
+							tli.devices.PlayNote(step, true, chain.OutFns, tli.Tuning)
+							// the notes are let go of unless something else let go of them first
+							gates = append(gates, newGate(id, step, chain.OutFns, tli.Tuning, tli.devices, now))
+						}
+					}
+					if audible {
+						for _, route := range chain.LaneRoutes {
+							if value, ok := route.crossed(tli.TimePosition[i], timePosition); ok {
+								tli.devices.SendLane(route.Out, value)
+							}
 						}
 					}
 					tli.TimePosition[i] = timePosition
 				}
-				if crows.NeedsFlush {
-					crows.Flush()
+				if stepped {
+					tli.publish()
 				}
-				mutex.Unlock()
+				tli.devices.Flush()
+				tli.mu.Unlock()
+				handled()
 			}
 		}
 	}()
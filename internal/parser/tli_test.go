@@ -128,9 +128,12 @@ out crow(1)
 	assert.Nil(t, err)
 	log.Debugf("tli: %+v", tli.Chains)
 	log.Debugf("tli: %+v", tli.ChainsRendered)
+	clock := NewManualClock()
+	tli.SetClock(clock)
 	tli.Play()
-	time.Sleep(3 * time.Second)
-
+	waitPlaying(tli)
+	clock.Advance(3 * time.Second)
+	tli.Stop()
 }
 
 func TestTLIUpdate(t *testing.T) {
@@ -149,8 +152,14 @@ out crow(output=1)
 	log.Debugf("tli: %+v", tli.Chains)
 	log.Debugf("tli: %+v", tli.ChainsRendered)
 
+	clock := NewManualClock()
+	tli.SetClock(clock)
 	tli.Play()
-	time.Sleep(3 * time.Second)
+	waitPlaying(tli)
+	clock.Advance(3 * time.Second)
+	step, err := tli.Step("test")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, step)
 	err = tli.Update(`
 run test
 f(t180) e d c
@@ -158,8 +167,8 @@ f(t180) e d c
 tie test
 out crow(output=1)`)
 	assert.Nil(t, err)
-	time.Sleep(3 * time.Second)
+	clock.Advance(3 * time.Second)
 	tli.Stop()
-	log.Debug(tli.Playing)
-	time.Sleep(1 * time.Second)
+	log.Debug(tli.State())
+	assert.Equal(t, TransportStopped, tli.State())
 }
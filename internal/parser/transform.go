@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// SplitTransforms splits the transforms off a tie line, like
+// "tie [a b]*2 (transpose=+5, rev, rot=1, stretch=2)" into "tie [a b]*2" and
+// its transforms, which are applied to the steps of the chain in order:
+//
+//	transpose=+5  moves every note up 5 semitones, or down with a minus
+//	oct=-1        moves every note by octaves
+//	rev           plays the steps backwards, holds stay with their notes
+//	rot=1         moves the first steps to the end, or the last steps to the
+//	              start when it is negative
+//	stretch=2     makes every step twice as long, or half as long with 1/2
+func SplitTransforms(line string) (chain string, transforms []Arg, err error) {
+	i := strings.Index(line, "(")
+	if i < 0 {
+		chain = line
+		return
+	}
+	chain = strings.TrimSpace(line[:i])
+	if !strings.HasSuffix(line, ")") {
+		err = fmt.Errorf("transforms of '%s' should end with ')'", chain)
+		return
+	}
+	fn, err := ParseFunction("transforms" + line[i:])
+	if err != nil {
+		return
+	}
+	for _, arg := range fn.Args {
+		if err = checkTransform(arg); err != nil {
+			return
+		}
+	}
+	transforms = fn.Args
+	return
+}
+
+func checkTransform(arg Arg) (err error) {
+	switch arg.Name {
+	case "transpose", "oct", "rot":
+		if _, errAtoi := strconv.Atoi(arg.Value); errAtoi != nil {
+			err = fmt.Errorf("bad %s '%s', should be a number", arg.Name, arg.Value)
+		}
+	case "stretch":
+		if _, errStretch := parseStretch(arg.Value); errStretch != nil {
+			err = errStretch
+		}
+	case "":
+		if arg.Value != "rev" {
+			err = fmt.Errorf("unknown transform '%s'", arg.Value)
+		}
+	default:
+		err = fmt.Errorf("unknown transform '%s'", arg.Name)
+	}
+	return
+}
+
+// parseStretch returns a stretch like "2", "1.5" or "1/2" as a fraction
+// so that steps stay a whole number of lines
+func parseStretch(s string) (stretch *big.Rat, err error) {
+	stretch, ok := new(big.Rat).SetString(s)
+	if !ok || stretch.Sign() <= 0 {
+		err = fmt.Errorf("bad stretch '%s', should be more than 0", s)
+	}
+	return
+}
+
+// transformSemitones returns how many semitones the transpose and oct
+// arguments of a function move notes
+func transformSemitones(args []Arg) (semitones int) {
+	for _, arg := range args {
+		switch arg.Name {
+		case "transpose":
+			n, _ := strconv.Atoi(arg.Value)
+			semitones += n
+		case "oct":
+			n, _ := strconv.Atoi(arg.Value)
+			semitones += 12 * n
+		}
+	}
+	return
+}
+
+// transposeStep returns a step with its notes moved by semitones, notes that
+// are moved past the midi notes do not play
+func transposeStep(step Step, semitones int) Step {
+	if semitones == 0 {
+		return step
+	}
+	notes := make([]Note, 0, len(step.Notes))
+	for _, note := range step.Notes {
+		if note.IsRest || note.IsLegato {
+			notes = append(notes, note)
+			continue
+		}
+		if midi := note.Midi + semitones; midi >= 0 && midi <= 127 {
+			notes = append(notes, Note{Midi: midi, Name: MidiName(midi), NameOriginal: note.NameOriginal, Velocity: note.Velocity})
+		}
+	}
+	if len(notes) == 0 {
+		notes = []Note{{IsRest: true}}
+	}
+	step.Notes = notes
+	if step.GlideFrom > 0 {
+		step.GlideFrom += semitones
+	}
+	return step
+}
+
+// transform applies the transforms of a chain to its steps
+func transform(steps []Step, transforms []Arg) []Step {
+	steps = append([]Step{}, steps...)
+	for _, arg := range transforms {
+		switch arg.Name {
+		case "transpose", "oct":
+			semitones := transformSemitones([]Arg{arg})
+			for i := range steps {
+				steps[i] = transposeStep(steps[i], semitones)
+			}
+		case "rot":
+			amount, _ := strconv.Atoi(arg.Value)
+			groups := holdGroups(steps)
+			if len(groups) == 0 {
+				continue
+			}
+			amount = (amount%len(groups) + len(groups)) % len(groups)
+			steps = joinGroups(append(groups[amount:], groups[:amount]...))
+		case "stretch":
+			stretch, err := parseStretch(arg.Value)
+			if err != nil {
+				continue
+			}
+			factor, _ := stretch.Float64()
+			for i := range steps {
+				steps[i].BeatsPerLine *= int(stretch.Num().Int64())
+				steps[i].StepLineCount *= int(stretch.Denom().Int64())
+				steps[i].Beats *= factor
+			}
+		case "":
+			if arg.Value == "rev" {
+				groups := holdGroups(steps)
+				for i, j := 0, len(groups)-1; i < j; i, j = i+1, j-1 {
+					groups[i], groups[j] = groups[j], groups[i]
+				}
+				steps = joinGroups(groups)
+			}
+		}
+	}
+	return steps
+}
+
+// holdGroups splits steps into groups of a step and the holds after it
+func holdGroups(steps []Step) (groups [][]Step) {
+	for _, step := range steps {
+		if len(groups) > 0 && len(step.Notes) > 0 && step.Notes[0].IsLegato {
+			groups[len(groups)-1] = append(groups[len(groups)-1], step)
+			continue
+		}
+		groups = append(groups, []Step{step})
+	}
+	return
+}
+
+func joinGroups(groups [][]Step) (steps []Step) {
+	for _, group := range groups {
+		steps = append(steps, group...)
+	}
+	return
+}
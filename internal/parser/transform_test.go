@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func TestSplitTransforms(t *testing.T) {
+	for _, test := range []struct {
+		line       string
+		chain      string
+		transforms []Arg
+		err        bool
+	}{
+		{"tie a b", "tie a b", nil, false},
+		{"tie [a b]*2 (transpose=+5, rev, rot=1, stretch=2)", "tie [a b]*2", []Arg{{"transpose", "+5"}, {"", "rev"}, {"rot", "1"}, {"stretch", "2"}}, false},
+		{"tie a(oct=-1)", "tie a", []Arg{{"oct", "-1"}}, false},
+		{"tie a (stretch=1/2)", "tie a", []Arg{{"stretch", "1/2"}}, false},
+		{"tie a (flip)", "", nil, true},
+		{"tie a (transpose=up)", "", nil, true},
+		{"tie a (stretch=0)", "", nil, true},
+		{"tie a (rev", "", nil, true},
+	} {
+		chain, transforms, err := SplitTransforms(test.line)
+		if test.err {
+			assert.NotNil(t, err, test.line)
+			continue
+		}
+		assert.Nil(t, err, test.line)
+		assert.Equal(t, test.chain, chain, test.line)
+		assert.Equal(t, test.transforms, transforms, test.line)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	log.SetLevel("info")
+	notes := func(text string) (names []string, beats []float64) {
+		tli, err := NewOffline(text)
+		assert.Nil(t, err)
+		for _, step := range tli.ChainsRendered[0].Steps {
+			for _, note := range step.Notes {
+				names = append(names, note.Name)
+			}
+			beats = append(beats, step.BeatsDuration)
+		}
+		return
+	}
+	for _, test := range []struct {
+		transforms string
+		names      []string
+		beats      []float64
+	}{
+		{"", []string{"c4", "d4", "e4"}, []float64{2, 1, 1}},
+		{"(transpose=+5)", []string{"f4", "g4", "a4"}, []float64{2, 1, 1}},
+		{"(oct=-1, transpose=2)", []string{"d3", "e3", "f#3"}, []float64{2, 1, 1}},
+		// holds stay with their notes
+		{"(rev)", []string{"e4", "d4", "c4"}, []float64{1, 1, 2}},
+		{"(rot=1)", []string{"d4", "e4", "c4"}, []float64{1, 1, 2}},
+		{"(rot=-1)", []string{"e4", "c4", "d4"}, []float64{1, 2, 1}},
+		{"(stretch=2)", []string{"c4", "d4", "e4"}, []float64{4, 2, 2}},
+		{"(stretch=1/2)", []string{"c4", "d4", "e4"}, []float64{1, 0.5, 0.5}},
+		// transforms are applied in order
+		{"(rev, rot=1)", []string{"d4", "c4", "e4"}, []float64{1, 2, 1}},
+	} {
+		names, beats := notes("run a\nc4 _ d4 e4\n\ntie a " + test.transforms + "\nout midi(synth)")
+		assert.Equal(t, test.names, names, test.transforms)
+		assert.Equal(t, test.beats, beats, test.transforms)
+	}
+
+	// the chain is named without its transforms
+	tli, err := NewOffline("run a\nc4\n\ntie a (rev)\nout midi(synth)")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", tli.ChainsRendered[0].Name)
+}
+
+func TestOutTransform(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := NewOffline("run a\nc4 e4\n\ntie a\nout midi(synth, oct=-1, transpose=2)")
+	assert.Nil(t, err)
+	events, _ := tli.Arrange()
+	midis := []int{}
+	for _, event := range events {
+		midis = append(midis, event.Midi)
+		assert.Equal(t, 0, event.Channel)
+	}
+	assert.Equal(t, []int{50, 54}, midis)
+}
+
+func TestOutDoubling(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := NewOffline("run a\nc4 e4\n\ntie a\nout midi(synth)\nout midi(bass, ch=1, oct=-1)\nout crow(1)")
+	assert.Nil(t, err)
+	events, _ := tli.Arrange()
+	played := [][2]int{}
+	for _, event := range events {
+		played = append(played, [2]int{event.Channel, event.Midi})
+	}
+	// each midi out plays every note with its own channel and transposition
+	assert.ElementsMatch(t, [][2]int{{0, 60}, {0, 64}, {1, 48}, {1, 52}}, played)
+
+	var buf bytes.Buffer
+	assert.Nil(t, tli.WriteMidi(&buf))
+	s, err := smf.ReadFrom(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	played = played[:0]
+	for _, event := range s.Tracks[1] {
+		var channel, key, velocity uint8
+		if event.Message.GetNoteStart(&channel, &key, &velocity) {
+			played = append(played, [2]int{int(channel), int(key)})
+		}
+	}
+	assert.ElementsMatch(t, [][2]int{{0, 60}, {0, 64}, {1, 48}, {1, 52}}, played)
+}
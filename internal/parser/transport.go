@@ -0,0 +1,437 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/schollz/logger"
+)
+
+// sharedStart is when a clock started for the sequencers that use it, it
+// starts with the first sequencer that plays so that the sequencers that
+// play after it stay in time
+type sharedStart struct {
+	start   time.Duration
+	running int
+}
+
+// sharedStarts are the starts of every clock, sequencers only keep time
+// with the sequencers that use the same clock
+var sharedStarts = struct {
+	sync.Mutex
+	clocks map[Clock]*sharedStart
+}{clocks: make(map[Clock]*sharedStart)}
+
+// clockStart returns when the clock started, starting it if no other
+// sequencer on the clock is playing, and whether other sequencers are playing
+func clockStart(clock Clock) (start time.Duration, joined bool) {
+	sharedStarts.Lock()
+	defer sharedStarts.Unlock()
+	shared, ok := sharedStarts.clocks[clock]
+	if !ok {
+		shared = &sharedStart{start: clock.Now()}
+		sharedStarts.clocks[clock] = shared
+	}
+	joined = shared.running > 0
+	shared.running++
+	start = shared.start
+	return
+}
+
+// clockStop lets the clock know that a sequencer stopped, the clock starts
+// over when no sequencer is playing on it
+func clockStop(clock Clock) {
+	sharedStarts.Lock()
+	defer sharedStarts.Unlock()
+	if shared, ok := sharedStarts.clocks[clock]; ok {
+		shared.running--
+		if shared.running <= 0 {
+			delete(sharedStarts.clocks, clock)
+		}
+	}
+}
+
+// TransportState is where the transport is, it goes from stopped to
+// starting to playing to stopping and back to stopped
+type TransportState int32
+
+const (
+	TransportStopped TransportState = iota
+	TransportStarting
+	TransportPlaying
+	TransportStopping
+)
+
+func (s TransportState) String() string {
+	switch s {
+	case TransportStopped:
+		return "stopped"
+	case TransportStarting:
+		return "starting"
+	case TransportPlaying:
+		return "playing"
+	case TransportStopping:
+		return "stopping"
+	}
+	return fmt.Sprintf("TransportState(%d)", int32(s))
+}
+
+// transport holds the state of the transport, which can be read at any
+// time without waiting on the sequencer
+type transport struct {
+	state atomic.Int32
+	// stop is closed to stop the loop that is playing
+	// and done is closed when it returns
+	stop chan struct{}
+	done chan struct{}
+}
+
+// State returns where the transport is
+func (tli *TLI) State() TransportState {
+	return TransportState(tli.transport.state.Load())
+}
+
+// IsPlaying returns whether the transport is starting or playing
+func (tli *TLI) IsPlaying() bool {
+	state := tli.State()
+	return state == TransportStarting || state == TransportPlaying
+}
+
+// move moves the transport from one state to another if it is in that state
+func (tli *TLI) move(from TransportState, to TransportState) bool {
+	return tli.transport.state.CompareAndSwap(int32(from), int32(to))
+}
+
+// Rendered returns the rendered chains, they are a snapshot that is
+// never changed so they can be read while the sequencer plays
+func (tli *TLI) Rendered() []Chain {
+	if chains := tli.rendered.Load(); chains != nil {
+		return *chains
+	}
+	return nil
+}
+
+// publish swaps in a snapshot of the rendered chains, it is called
+// with tli.mu held after the rendered chains change
+func (tli *TLI) publish() {
+	chains := make([]Chain, len(tli.ChainsRendered))
+	copy(chains, tli.ChainsRendered)
+	tli.rendered.Store(&chains)
+}
+
+// SetClock sets the clock that the sequencer plays in time with,
+// it takes effect the next time it plays
+func (tli *TLI) SetClock(clock Clock) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	tli.clock = clock
+}
+
+// FindChain returns the index of the rendered chain with the given name,
+// the name can also be the 1-indexed position of the chain
+func (tli *TLI) FindChain(name string) (index int, err error) {
+	for i, chain := range tli.ChainsRendered {
+		if chain.Name == name {
+			index = i
+			return
+		}
+	}
+	if num, errNum := strconv.Atoi(name); errNum == nil && num > 0 && num <= len(tli.ChainsRendered) {
+		index = num - 1
+		return
+	}
+	err = fmt.Errorf("no chain named '%s'", name)
+	return
+}
+
+// ToggleMute mutes or unmutes a chain, a muted chain keeps its position
+// but does not play any notes
+func (tli *TLI) ToggleMute(name string) (muted bool, err error) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	i, err := tli.FindChain(name)
+	if err != nil {
+		return
+	}
+	tli.ChainsRendered[i].Muted = !tli.ChainsRendered[i].Muted
+	muted = tli.ChainsRendered[i].Muted
+	tli.publish()
+	log.Debugf("chain '%s' muted: %v", tli.ChainsRendered[i].Name, muted)
+	return
+}
+
+// ToggleSolo solos or unsolos a chain, when any chain is soloed
+// only the soloed chains are heard
+func (tli *TLI) ToggleSolo(name string) (soloed bool, err error) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	i, err := tli.FindChain(name)
+	if err != nil {
+		return
+	}
+	tli.ChainsRendered[i].Soloed = !tli.ChainsRendered[i].Soloed
+	soloed = tli.ChainsRendered[i].Soloed
+	tli.publish()
+	log.Debugf("chain '%s' soloed: %v", tli.ChainsRendered[i].Name, soloed)
+	return
+}
+
+// Launch (re)starts a single chain from its first step, if the transport
+// is stopped it is started
+func (tli *TLI) Launch(name string) (err error) {
+	tli.mu.Lock()
+	i, err := tli.FindChain(name)
+	if err != nil {
+		tli.mu.Unlock()
+		return
+	}
+	tli.ChainsRendered[i].Stopped = false
+	tli.ChainsRendered[i].StepCurrent = 0
+	tli.TimePosition[i] = -1
+	if tli.State() == TransportPlaying {
+		tli.ChainsRendered[i].TimeOffset = (tli.clock.Now() - tli.startTime).Microseconds()
+	}
+	tli.publish()
+	log.Debugf("launched chain '%s'", tli.ChainsRendered[i].Name)
+	tli.mu.Unlock()
+	if !tli.IsPlaying() {
+		tli.Play()
+	}
+	return
+}
+
+// Halt stops a single chain while the rest keep playing
+func (tli *TLI) Halt(name string) (err error) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	i, err := tli.FindChain(name)
+	if err != nil {
+		return
+	}
+	tli.ChainsRendered[i].Stopped = true
+	tli.TimePosition[i] = -1
+	tli.publish()
+	chain := tli.ChainsRendered[i].Name
+	tli.releaseVoices(tli.voices.release(func(v voice) bool {
+		return !v.manual && v.chain == chain
+	}))
+	log.Debugf("halted chain '%s'", tli.ChainsRendered[i].Name)
+	return
+}
+
+// ToggleLaunch launches a stopped chain or halts a running one
+func (tli *TLI) ToggleLaunch(name string) (launched bool, err error) {
+	tli.mu.Lock()
+	i, err := tli.FindChain(name)
+	stopped := err == nil && tli.ChainsRendered[i].Stopped
+	tli.mu.Unlock()
+	if err != nil {
+		return
+	}
+	if stopped || !tli.IsPlaying() {
+		launched = true
+		err = tli.Launch(name)
+	} else {
+		err = tli.Halt(name)
+	}
+	return
+}
+
+// Beat returns the position of the chain at index i in beats since the
+// start of its loop, or -1 if the chain is not playing
+func (tli *TLI) Beat(i int) (beat float64) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	beat = -1
+	if tli.State() != TransportPlaying || i < 0 || i >= len(tli.ChainsRendered) || i >= len(tli.TimePosition) {
+		return
+	}
+	chain := tli.ChainsRendered[i]
+	timePosition := tli.TimePosition[i]
+	if chain.Stopped || timePosition < 0 || len(chain.Steps) == 0 {
+		return
+	}
+	beat = chain.beatAt(timePosition)
+	if beat < 0 {
+		beat = 0
+	} else if beat > chain.BeatsTotal {
+		beat = chain.BeatsTotal
+	}
+	return
+}
+
+// isAudible returns whether the chain at index i should sound,
+// taking mute and solo into account
+func (tli *TLI) isAudible(i int) bool {
+	if tli.ChainsRendered[i].Muted {
+		return false
+	}
+	for _, chain := range tli.ChainsRendered {
+		if chain.Soloed {
+			return tli.ChainsRendered[i].Soloed
+		}
+	}
+	return true
+}
+
+// copyChainState keeps the mute, solo and launch state of chains
+// with the same name across reloads
+func (tli *TLI) copyChainState(chains []Chain) {
+	for i := range tli.ChainsRendered {
+		for _, chain := range chains {
+			if chain.Name == tli.ChainsRendered[i].Name {
+				tli.ChainsRendered[i].Muted = chain.Muted
+				tli.ChainsRendered[i].Soloed = chain.Soloed
+				tli.ChainsRendered[i].Stopped = chain.Stopped
+				tli.ChainsRendered[i].TimeOffset = chain.TimeOffset
+				break
+			}
+		}
+	}
+}
+
+// SetDevices sets the aliases that can be used for devices in out lines,
+// they take effect on the next update
+func (tli *TLI) SetDevices(devices map[string]string) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	tli.aliases = devices
+}
+
+// resolveDevices replaces any device alias in the outputs of the chain
+// with the name of the device
+func (tli *TLI) resolveDevices(chain *Chain) {
+	outs := append([]Function{}, chain.OutFns...)
+	for _, route := range chain.LaneRoutes {
+		outs = append(outs, route.Out)
+	}
+	for _, fn := range outs {
+		if fn.Name != "midi" && fn.Name != "cc" {
+			continue
+		}
+		if j := portArg(fn); j >= 0 {
+			if name, ok := tli.Devices[fn.Args[j].Value]; ok {
+				fn.Args[j].Value = name
+			}
+		}
+	}
+}
+
+// Step returns the index of the step that the chain is on
+func (tli *TLI) Step(name string) (step int, err error) {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	i, err := tli.FindChain(name)
+	if err != nil {
+		return
+	}
+	step = tli.ChainsRendered[i].StepCurrent
+	return
+}
+
+// Tempo returns the tempo in beats per minute
+func (tli *TLI) Tempo() int {
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	return tli.Params.Tempo
+}
+
+// SetTempo changes the tempo of everything that is playing by scaling the
+// timing of every step, it lasts until the text is updated
+func (tli *TLI) SetTempo(bpm int) (err error) {
+	if bpm <= 0 {
+		err = fmt.Errorf("bad tempo %d", bpm)
+		return
+	}
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	scale := float64(tli.Params.Tempo) / float64(bpm)
+	for i := range tli.ChainsRendered {
+		tli.ChainsRendered[i].scaleTempo(scale)
+		if tli.State() == TransportPlaying && i < len(tli.TimePosition) && tli.TimePosition[i] > 0 {
+			// keep the same place in the chain
+			tli.TimePosition[i] = int64(float64(tli.TimePosition[i]) * scale)
+			tli.ChainsRendered[i].TimeOffset = (tli.clock.Now() - tli.startTime).Microseconds() - tli.TimePosition[i]
+		}
+	}
+	// cycles that were generated already play at the new tempo too
+	for _, c := range tli.cycles {
+		if c.chain != nil {
+			c.chain.scaleTempo(scale)
+		}
+	}
+	tli.Params.Tempo = bpm
+	tli.publish()
+	return
+}
+
+// scaleTempo makes the steps of a chain take longer by a scale, they are
+// copied since snapshots still hold the old ones
+func (c *Chain) scaleTempo(scale float64) {
+	steps := make([]Step, len(c.Steps))
+	for j, step := range c.Steps {
+		steps[j] = step
+		steps[j].Params.Tempo = int(math.Round(float64(step.Params.Tempo) / scale))
+		steps[j].TimeStartMicroseconds = int64(float64(step.TimeStartMicroseconds) * scale)
+		steps[j].TimeDurationMicroseconds = int64(float64(step.TimeDurationMicroseconds) * scale)
+	}
+	tempos := make([]TempoSegment, len(c.Tempos))
+	for j, segment := range c.Tempos {
+		tempos[j] = segment
+		tempos[j].From = segment.From / scale
+		tempos[j].To = segment.To / scale
+	}
+	routes := make([]LaneRoute, len(c.LaneRoutes))
+	for j, route := range c.LaneRoutes {
+		routes[j] = route
+		routes[j].Events = make([]LaneEvent, len(route.Events))
+		for k, event := range route.Events {
+			routes[j].Events[k] = event
+			routes[j].Events[k].Time = int64(float64(event.Time) * scale)
+		}
+	}
+	c.Steps = steps
+	c.Tempos = tempos
+	c.LaneRoutes = routes
+	c.MicrosecondsTotal = int64(float64(c.MicrosecondsTotal) * scale)
+}
+
+// SendNote plays or releases a note right away on an output
+// like "midi(synth,ch=1)" or "crow(1)"
+func (tli *TLI) SendNote(out string, midi int, on bool) (err error) {
+	fn, err := ParseFunction(out)
+	if err != nil {
+		return
+	}
+	tli.mu.Lock()
+	defer tli.mu.Unlock()
+	chain := Chain{OutFns: []Function{fn}}
+	tli.resolveDevices(&chain)
+	err = tli.setupOutput(fn)
+	if err != nil {
+		return
+	}
+	step := Step{Notes: []Note{{Midi: midi, Name: MidiName(midi)}}}
+	if !on {
+		output := outputKey(chain.OutFns[0])
+		released := tli.voices.release(func(v voice) bool {
+			return v.manual && v.output == output && v.note.Midi == midi
+		})
+		if len(released) > 0 {
+			tli.releaseVoices(released)
+			return
+		}
+		err = tli.devices.PlayNote(step, false, chain.OutFns, tli.Tuning)
+		tli.devices.Flush()
+		return
+	}
+	_, retriggered := tli.voices.start("", true, step, chain.OutFns, tli.Tuning)
+	tli.releaseVoices(retriggered)
+	err = tli.devices.PlayNote(step, true, chain.OutFns, tli.Tuning)
+	tli.devices.Flush()
+	return
+}
@@ -0,0 +1,304 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	log.SetLevel("info")
+	text := `
+run a
+c4 d e f
+
+run b
+g4 a
+
+tie a
+out crow(1)
+
+tie b
+out crow(3)
+`
+	tli, err := New(text)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tli.ChainsRendered))
+
+	i, err := tli.FindChain("b")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, i)
+	i, err = tli.FindChain("1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, i)
+	_, err = tli.FindChain("nope")
+	assert.NotNil(t, err)
+
+	// mute
+	muted, err := tli.ToggleMute("a")
+	assert.Nil(t, err)
+	assert.True(t, muted)
+	assert.False(t, tli.isAudible(0))
+	assert.True(t, tli.isAudible(1))
+
+	// solo overrides everything else
+	soloed, err := tli.ToggleSolo("a")
+	assert.Nil(t, err)
+	assert.True(t, soloed)
+	assert.False(t, tli.isAudible(0))
+	assert.False(t, tli.isAudible(1))
+	tli.ToggleMute("a")
+	assert.True(t, tli.isAudible(0))
+
+	// halt and launch a single chain
+	tli.transport.state.Store(int32(TransportPlaying))
+	err = tli.Halt("b")
+	assert.Nil(t, err)
+	assert.True(t, tli.ChainsRendered[1].Stopped)
+	launched, err := tli.ToggleLaunch("b")
+	assert.Nil(t, err)
+	assert.True(t, launched)
+	assert.False(t, tli.ChainsRendered[1].Stopped)
+	tli.Halt("b")
+	tli.transport.state.Store(int32(TransportStopped))
+
+	// state is kept across updates
+	err = tli.Update(text)
+	assert.Nil(t, err)
+	assert.True(t, tli.ChainsRendered[0].Soloed)
+	assert.False(t, tli.ChainsRendered[0].Muted)
+	assert.True(t, tli.ChainsRendered[1].Stopped)
+}
+
+func TestTransportDuplicateNames(t *testing.T) {
+	log.SetLevel("info")
+	text := `
+run a
+c4 d e f
+
+tie a
+out crow(1)
+
+tie a
+out crow(3)
+`
+	tli, err := New(text)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tli.ChainsRendered))
+	assert.Equal(t, "a", tli.ChainsRendered[0].Name)
+	assert.Equal(t, "a (2)", tli.ChainsRendered[1].Name)
+
+	// each chain is muted on its own
+	muted, err := tli.ToggleMute("a (2)")
+	assert.Nil(t, err)
+	assert.True(t, muted)
+	assert.True(t, tli.isAudible(0))
+	assert.False(t, tli.isAudible(1))
+
+	// and keeps its state across updates
+	err = tli.Update(text)
+	assert.Nil(t, err)
+	assert.False(t, tli.ChainsRendered[0].Muted)
+	assert.True(t, tli.ChainsRendered[1].Muted)
+}
+
+func TestDevices(t *testing.T) {
+	tli, err := newWithDevices(`
+run a
+c4 d
+
+tie a
+out midi(synth,ch=1)
+`, map[string]string{"synth": "UM-ONE MIDI 1"}, SharedDevices)
+	assert.Nil(t, err)
+	name, err := tli.Chains[0].OutFns[0].GetStringPlace("output", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "UM-ONE MIDI 1", name)
+}
+
+func TestDevicesSet(t *testing.T) {
+	tli, err := newWithDevices(`
+set
+synth = "UM-ONE MIDI 1"
+
+run a
+c4 d
+
+tie a
+out midi(synth,ch=1)
+`, map[string]string{"drums": "OP-1"}, SharedDevices)
+	assert.Nil(t, err)
+	assert.Equal(t, "OP-1", tli.Devices["drums"])
+	name, err := tli.Chains[0].OutFns[0].GetStringPlace("output", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "UM-ONE MIDI 1", name)
+
+	// an alias that is taken out of the text is gone after an update
+	// while the given aliases stay, the devices are not there to open
+	tli.Update("run a\nc4 d\n\ntie a\nout midi(drums)")
+	_, ok := tli.Devices["synth"]
+	assert.False(t, ok)
+	assert.Equal(t, "OP-1", tli.Devices["drums"])
+	name, err = tli.Chains[0].OutFns[0].GetStringPlace("output", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "OP-1", name)
+
+	// given aliases take effect on the next update
+	tli.SetDevices(map[string]string{"drums": "TR-8"})
+	tli.Update(tli.Text)
+	assert.Equal(t, map[string]string{"drums": "TR-8"}, tli.Devices)
+	tli.Close()
+}
+
+func TestSetTempo(t *testing.T) {
+	tli, err := New(`
+set
+bpm 120
+
+run a
+c4 d e f
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 120, tli.Tempo())
+	total := tli.ChainsRendered[0].MicrosecondsTotal
+	assert.Nil(t, tli.SetTempo(60))
+	assert.Equal(t, 60, tli.Tempo())
+	assert.Equal(t, total*2, tli.ChainsRendered[0].MicrosecondsTotal)
+	assert.Equal(t, 60, tli.ChainsRendered[0].Steps[1].Params.Tempo)
+	assert.NotNil(t, tli.SetTempo(0))
+
+	step, err := tli.Step("1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, step)
+}
+
+func TestTransportRace(t *testing.T) {
+	log.SetLevel("info")
+	device := &fakeMidi{}
+	dm := NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		return device, nil
+	}
+	text := `
+set
+bpm 3000
+
+run a
+c4 d e f
+
+run b
+g4 - a ~
+
+tie a
+out midi(synth)
+
+tie b
+out midi(synth,ch=2)
+`
+	tli, err := newWithDevices(text, nil, dm)
+	assert.Nil(t, err)
+	assert.Equal(t, TransportStopped, tli.State())
+	clock := NewManualClock()
+	tli.SetClock(clock)
+
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				f(i)
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	run(func(i int) {
+		tli.Play()
+	})
+	run(func(i int) {
+		clock.Advance(5 * time.Millisecond)
+	})
+	run(func(i int) {
+		if i%3 == 0 {
+			tli.Stop()
+		}
+	})
+	run(func(i int) {
+		tli.Toggle()
+	})
+	run(func(i int) {
+		assert.Nil(t, tli.Update(strings.Replace(text, "c4", []string{"c4", "e4"}[i%2], 1)))
+	})
+	run(func(i int) {
+		tli.ToggleMute("b")
+		tli.Launch("a")
+		tli.Beat(0)
+	})
+	run(func(i int) {
+		// snapshots can be read while everything else changes
+		for _, chain := range tli.Rendered() {
+			for _, step := range chain.Steps {
+				_ = step.TimeStartMicroseconds
+			}
+			_ = chain.StepCurrent
+		}
+		tli.IsPlaying()
+	})
+	wg.Wait()
+
+	tli.Stop()
+	assert.Equal(t, TransportStopped, tli.State())
+	assert.Equal(t, 0, tli.voices.count())
+	// every note that was played was let go of once
+	dm.mu.Lock()
+	notes := append([]uint8{}, device.notes...)
+	offs := append([]uint8{}, device.offs...)
+	dm.mu.Unlock()
+	sort.Slice(notes, func(i, j int) bool { return notes[i] < notes[j] })
+	sort.Slice(offs, func(i, j int) bool { return offs[i] < offs[j] })
+	assert.NotEmpty(t, notes)
+	assert.Equal(t, notes, offs)
+}
+
+func TestTransportStates(t *testing.T) {
+	log.SetLevel("info")
+	dm := NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		return &fakeMidi{}, nil
+	}
+	tli, err := newWithDevices(`
+run a
+c4 d e f
+
+tie a
+out midi(synth)
+`, nil, dm)
+	assert.Nil(t, err)
+	assert.Equal(t, "stopped", tli.State().String())
+
+	// stopping while starting never plays
+	tli.Play()
+	assert.True(t, tli.IsPlaying())
+	tli.Stop()
+	assert.Equal(t, TransportStopped, tli.State())
+
+	// playing twice starts once
+	tli.Play()
+	tli.Play()
+	for tli.State() == TransportStarting {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, TransportPlaying, tli.State())
+	snapshot := tli.Rendered()
+	tli.ToggleMute("a")
+	assert.False(t, snapshot[0].Muted)
+	assert.True(t, tli.Rendered()[0].Muted)
+	tli.Stop()
+	assert.Equal(t, TransportStopped, tli.State())
+	assert.False(t, tli.IsPlaying())
+}
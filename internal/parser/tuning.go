@@ -0,0 +1,304 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/schollz/aw/internal/util"
+)
+
+// DefaultBendRange is the pitch bend range in semitones of midi outputs,
+// it can be changed with "out midi(synth,bend=12)"
+const DefaultBendRange = 2
+
+// Tuning is a scale from a Scala .scl file and the keyboard mapping from
+// a .kbm file that says which midi note plays which degree of the scale
+type Tuning struct {
+	Name string `json:"name"`
+	// Cents are the degrees of the scale above the first one,
+	// the last one is the period, usually an octave
+	Cents  []float64 `json:"cents"`
+	Keymap Keymap    `json:"keymap"`
+}
+
+// Keymap is a Scala keyboard mapping
+type Keymap struct {
+	// Size is the number of keys before the mapping repeats, when it is 0
+	// every key plays the next degree of the scale
+	Size  int `json:"size"`
+	First int `json:"first"`
+	Last  int `json:"last"`
+	// Middle is the key that plays the first degree of the scale
+	Middle int `json:"middle"`
+	// Reference is the key that plays at Frequency
+	Reference int     `json:"reference"`
+	Frequency float64 `json:"frequency"`
+	// Octave is the degree that the mapping repeats at
+	Octave int `json:"octave"`
+	// Map has the degree of each key in the mapping, -1 for keys that do not play
+	Map []int `json:"map"`
+}
+
+// DefaultKeymap maps the scale from middle c up, with a4 at 440 Hz
+var DefaultKeymap = Keymap{Last: 127, Middle: 60, Reference: 69, Frequency: 440}
+
+// ParseTuning parses a tuning line of a set block like "tuning just.scl just.kbm"
+// into the scale file and the optional keyboard mapping file
+func ParseTuning(line string) (scl string, kbm string, err error) {
+	fields := strings.Fields(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "tuning")))
+	if len(fields) == 0 || len(fields) > 2 {
+		err = fmt.Errorf("bad tuning '%s', should be like 'tuning just.scl just.kbm'", line)
+		return
+	}
+	scl = strings.Trim(fields[0], `"'`)
+	if len(fields) == 2 {
+		kbm = strings.Trim(fields[1], `"'`)
+	}
+	return
+}
+
+// LoadTuning reads a .scl file and an optional .kbm file
+func LoadTuning(scl string, kbm string) (t *Tuning, err error) {
+	b, err := os.ReadFile(scl)
+	if err != nil {
+		return
+	}
+	t, err = ParseScala(string(b))
+	if err != nil {
+		err = fmt.Errorf("%s: %w", filepath.Base(scl), err)
+		return
+	}
+	if kbm != "" {
+		b, err = os.ReadFile(kbm)
+		if err != nil {
+			return
+		}
+		t.Keymap, err = ParseKeymap(string(b))
+		if err != nil {
+			err = fmt.Errorf("%s: %w", filepath.Base(kbm), err)
+			return
+		}
+	}
+	if t.Frequency(t.Keymap.Reference) <= 0 {
+		err = fmt.Errorf("reference note %d is not mapped", t.Keymap.Reference)
+	}
+	return
+}
+
+// scalaLines returns the lines of a Scala file without the comments
+func scalaLines(text string) (lines []string) {
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return
+}
+
+// ParseScala parses the text of a .scl file, where each degree is in
+// cents like "701.955" or a ratio like "3/2"
+func ParseScala(text string) (t *Tuning, err error) {
+	lines := scalaLines(text)
+	if len(lines) < 2 {
+		err = fmt.Errorf("scale needs a description and the number of notes")
+		return
+	}
+	t = &Tuning{Name: lines[0], Keymap: DefaultKeymap}
+	lines = lines[1:]
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		err = fmt.Errorf("scale needs the number of notes")
+		return
+	}
+	count, err := strconv.Atoi(strings.Fields(lines[0] + " x")[0])
+	if err != nil || count <= 0 {
+		err = fmt.Errorf("bad number of notes '%s'", lines[0])
+		return
+	}
+	for _, line := range lines[1:] {
+		if len(t.Cents) == count {
+			break
+		}
+		if line == "" {
+			continue
+		}
+		var cents float64
+		cents, err = parseScalaPitch(strings.Fields(line)[0])
+		if err != nil {
+			return
+		}
+		t.Cents = append(t.Cents, cents)
+	}
+	if len(t.Cents) != count {
+		err = fmt.Errorf("scale has %d notes instead of %d", len(t.Cents), count)
+	}
+	return
+}
+
+// parseScalaPitch returns the cents of a pitch, which are in cents
+// when there is a period and are a ratio otherwise
+func parseScalaPitch(s string) (cents float64, err error) {
+	if strings.Contains(s, ".") {
+		cents, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			err = fmt.Errorf("bad cents '%s'", s)
+		}
+		return
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) == 1 {
+		parts = append(parts, "1")
+	}
+	num, errNum := strconv.ParseFloat(parts[0], 64)
+	den, errDen := strconv.ParseFloat(parts[1], 64)
+	if errNum != nil || errDen != nil || num <= 0 || den <= 0 {
+		err = fmt.Errorf("bad ratio '%s'", s)
+		return
+	}
+	cents = 1200 * math.Log2(num/den)
+	return
+}
+
+// ParseKeymap parses the text of a .kbm file
+func ParseKeymap(text string) (k Keymap, err error) {
+	values := []string{}
+	for _, line := range scalaLines(text) {
+		if line != "" {
+			values = append(values, strings.Fields(line)[0])
+		}
+	}
+	if len(values) < 7 {
+		err = fmt.Errorf("keyboard mapping needs 7 values before the map")
+		return
+	}
+	ints := make([]int, 7)
+	for i, v := range values[:7] {
+		if i == 5 {
+			k.Frequency, err = strconv.ParseFloat(v, 64)
+		} else {
+			ints[i], err = strconv.Atoi(v)
+		}
+		if err != nil {
+			err = fmt.Errorf("bad value '%s'", v)
+			return
+		}
+	}
+	k.Size, k.First, k.Last, k.Middle, k.Reference, k.Octave = ints[0], ints[1], ints[2], ints[3], ints[4], ints[6]
+	if k.Size < 0 || k.Frequency <= 0 {
+		err = fmt.Errorf("bad keyboard mapping")
+		return
+	}
+	for _, v := range values[7:] {
+		if len(k.Map) == k.Size {
+			break
+		}
+		degree := -1
+		if v != "x" && v != "X" {
+			degree, err = strconv.Atoi(v)
+			if err != nil || degree < 0 {
+				err = fmt.Errorf("bad degree '%s'", v)
+				return
+			}
+		}
+		k.Map = append(k.Map, degree)
+	}
+	// keys missing from the end of the map do not play
+	for len(k.Map) < k.Size {
+		k.Map = append(k.Map, -1)
+	}
+	return
+}
+
+// degreeCents returns the cents of a degree of the scale above the first one
+func (t *Tuning) degreeCents(degree int) float64 {
+	n := len(t.Cents)
+	period := floorDiv(degree, n)
+	cents := float64(period) * t.Cents[n-1]
+	if r := degree - period*n; r > 0 {
+		cents += t.Cents[r-1]
+	}
+	return cents
+}
+
+// keyCents returns the cents of a key above the middle key
+func (t *Tuning) keyCents(midi int) (cents float64, ok bool) {
+	k := t.Keymap
+	if midi < k.First || midi > k.Last {
+		return
+	}
+	if k.Size == 0 {
+		return t.degreeCents(midi - k.Middle), true
+	}
+	offset := midi - k.Middle
+	repeats := floorDiv(offset, k.Size)
+	degree := k.Map[offset-repeats*k.Size]
+	if degree < 0 {
+		return
+	}
+	octave := k.Octave
+	if octave == 0 {
+		octave = len(t.Cents)
+	}
+	return float64(repeats)*t.degreeCents(octave) + t.degreeCents(degree), true
+}
+
+// Frequency returns the frequency of a midi note, which is 0 for keys that
+// are not mapped. Without a tuning notes are equal tempered.
+func (t *Tuning) Frequency(midi int) float64 {
+	if t == nil {
+		return MidiFrequency(midi)
+	}
+	cents, ok := t.keyCents(midi)
+	reference, okReference := t.keyCents(t.Keymap.Reference)
+	if !ok || !okReference {
+		return 0
+	}
+	return t.Keymap.Frequency * math.Pow(2, (cents-reference)/1200)
+}
+
+// Pitch returns the pitch of a midi note in equal tempered semitones,
+// so a4 is 69 and a note a quarter tone above it is 69.5
+func (t *Tuning) Pitch(midi int) (pitch float64, ok bool) {
+	if t == nil {
+		return float64(midi), true
+	}
+	frequency := t.Frequency(midi)
+	if frequency <= 0 {
+		return
+	}
+	return 69 + 12*math.Log2(frequency/440), true
+}
+
+// Volts returns the voltage of a midi note for 1V/octave outputs
+func (t *Tuning) Volts(midi int) (volts float64, ok bool) {
+	pitch, ok := t.Pitch(midi)
+	return (pitch - 12) / 12, ok
+}
+
+// PitchBend returns the midi key closest to a note and the pitch bend
+// that tunes it, for a bend range in semitones
+func (t *Tuning) PitchBend(midi int, bendRange int) (key int, bend int16, ok bool) {
+	pitch, ok := t.Pitch(midi)
+	if !ok {
+		return
+	}
+	key = util.Clamp(int(math.Round(pitch)), 0, 127)
+	bend = bendValue(pitch-float64(key), bendRange)
+	return
+}
+
+// bendValue returns the pitch bend of a number of semitones for a bend range
+func bendValue(semitones float64, bendRange int) int16 {
+	if bendRange <= 0 {
+		bendRange = DefaultBendRange
+	}
+	value := math.Round(semitones / float64(bendRange) * 8192)
+	return int16(math.Max(-8192, math.Min(8191, value)))
+}
@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+const justScl = `! just.scl
+!
+5-limit just intonation
+ 12
+!
+ 16/15
+ 9/8
+ 6/5
+ 5/4
+ 4/3
+ 45/32
+ 3/2
+ 8/5
+ 5/3
+ 9/5
+ 15/8
+ 2/1
+`
+
+func TestParseScala(t *testing.T) {
+	tuning, err := ParseScala(justScl)
+	assert.Nil(t, err)
+	assert.Equal(t, "5-limit just intonation", tuning.Name)
+	assert.Equal(t, 12, len(tuning.Cents))
+	assert.InDelta(t, 701.955, tuning.Cents[6], 0.001)
+	assert.InDelta(t, 1200, tuning.Cents[11], 0.001)
+
+	tuning, err = ParseScala("quarter tones\n2\n50.0\n100.0 ! comment\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{50, 100}, tuning.Cents)
+
+	for _, text := range []string{"", "bad\nx\n", "bad\n3\n100.0\n", "bad\n1\n-3/2\n"} {
+		_, err = ParseScala(text)
+		assert.NotNil(t, err, text)
+	}
+}
+
+func TestTuningFrequency(t *testing.T) {
+	var equal *Tuning
+	assert.InDelta(t, 440, equal.Frequency(69), 0.001)
+	pitch, ok := equal.Pitch(61)
+	assert.True(t, ok)
+	assert.Equal(t, 61.0, pitch)
+
+	tuning, err := ParseScala(justScl)
+	assert.Nil(t, err)
+	// a4 stays at 440 and c4 is a just major sixth below it
+	assert.InDelta(t, 440, tuning.Frequency(69), 0.001)
+	assert.InDelta(t, 264, tuning.Frequency(60), 0.001)
+	assert.InDelta(t, 396, tuning.Frequency(67), 0.001)
+	assert.InDelta(t, 132, tuning.Frequency(48), 0.001)
+
+	// g4 is about 18 cents above an equal one since a4 is the reference
+	key, bend, ok := tuning.PitchBend(67, 2)
+	assert.True(t, ok)
+	assert.Equal(t, 67, key)
+	assert.InDelta(t, 721, int(bend), 1)
+	// and e4 is about 2 cents above
+	key, bend, _ = tuning.PitchBend(64, 2)
+	assert.Equal(t, 64, key)
+	assert.InDelta(t, 80, int(bend), 1)
+	_, bend, _ = tuning.PitchBend(64, 12)
+	assert.InDelta(t, 13, int(bend), 1)
+
+	volts, ok := tuning.Volts(60)
+	assert.True(t, ok)
+	assert.InDelta(t, 4+0.1564/12, volts, 0.001)
+}
+
+func TestParseKeymap(t *testing.T) {
+	// a pentatonic scale on the white keys, with the black keys silent
+	keymap, err := ParseKeymap(`! white.kbm
+12
+0
+127
+60
+69
+440.0
+5
+! mapping
+0
+x
+1
+x
+2
+x
+x
+3
+x
+4
+x
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, keymap.Size)
+	assert.Equal(t, []int{0, -1, 1, -1, 2, -1, -1, 3, -1, 4, -1, -1}, keymap.Map)
+
+	tuning, err := ParseScala("pentatonic\n5\n9/8\n5/4\n3/2\n5/3\n2/1\n")
+	assert.Nil(t, err)
+	tuning.Keymap = keymap
+	assert.InDelta(t, 440, tuning.Frequency(69), 0.001)
+	assert.InDelta(t, 264, tuning.Frequency(60), 0.001)
+	assert.InDelta(t, 528, tuning.Frequency(72), 0.001)
+	assert.InDelta(t, 220, tuning.Frequency(57), 0.001)
+	assert.Equal(t, 0.0, tuning.Frequency(61))
+	_, _, ok := tuning.PitchBend(61, 2)
+	assert.False(t, ok)
+
+	_, err = ParseKeymap("12\n0\n127\n")
+	assert.NotNil(t, err)
+	_, err = ParseKeymap("2\n0\n127\n60\n69\n440\n2\n0\ny\n")
+	assert.NotNil(t, err)
+}
+
+func TestTuningText(t *testing.T) {
+	log.SetLevel("info")
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "just.scl"), []byte(justScl), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "song.tli"), []byte(`
+set
+tuning just.scl
+
+run a
+c4 e4 g4
+
+tie a
+out midi(synth)
+`), 0644))
+	text, _, err := Load(filepath.Join(dir, "song.tli"))
+	assert.Nil(t, err)
+	assert.Contains(t, text, "tuning "+filepath.Join(dir, "just.scl"))
+
+	opened := make(map[string]*fakeMidi)
+	dm := NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		opened[name] = &fakeMidi{}
+		return opened[name], nil
+	}
+	tli, err := newWithDevices(text, nil, dm)
+	assert.Nil(t, err)
+	assert.NotNil(t, tli.Tuning)
+
+	events, _ := tli.Arrange()
+	assert.Equal(t, 3, len(events))
+	assert.InDelta(t, 264, events[0].Frequency, 0.001)
+	assert.InDelta(t, 330, events[1].Frequency, 0.001)
+	assert.Equal(t, 64, events[1].Midi)
+	assert.InDelta(t, 80, int(events[1].Bend), 1)
+
+	assert.Nil(t, tli.SendNote("midi(synth)", 67, true))
+	assert.Equal(t, []uint8{67}, opened["synth"].notes)
+	assert.Equal(t, 1, len(opened["synth"].bends))
+	assert.InDelta(t, 721, int(opened["synth"].bends[0]), 1)
+
+	// each note of a chord plays on a channel of its own, as a channel has
+	// one bend, and is let go of on that channel
+	dm = NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		opened[name] = &fakeMidi{}
+		return opened[name], nil
+	}
+	tli, err = newWithDevices("set\ntuning "+filepath.Join(dir, "just.scl")+"\n\nrun a\nc4e4g4\n\ntie a\nout midi(synth,ch=2)\n", nil, dm)
+	assert.Nil(t, err)
+	step := tli.ChainsRendered[0].Steps[0]
+	outFns := tli.ChainsRendered[0].OutFns
+	id, _ := tli.voices.start("a", false, step, outFns, tli.Tuning)
+	assert.Nil(t, tli.devices.PlayNote(step, true, outFns, tli.Tuning))
+	tli.releaseVoices(tli.voices.stop(id))
+	assert.Equal(t, []uint8{60, 64, 67}, opened["synth"].notes)
+	assert.Equal(t, []uint8{2, 3, 4}, opened["synth"].channels)
+	assert.Equal(t, []uint8{2, 3, 4}, opened["synth"].bendChannels)
+	assert.Equal(t, []uint8{2, 3, 4}, opened["synth"].offChannels)
+	events, _ = tli.Arrange()
+	channels := []int{}
+	for _, e := range events {
+		channels = append(channels, e.Channel)
+	}
+	assert.Equal(t, []int{2, 3, 4}, channels)
+
+	tli, err = newWithDevices("set\ntuning nope.scl\n", nil, dm)
+	assert.Nil(t, err)
+	assert.Nil(t, tli.Tuning)
+	assert.Equal(t, 1, len(tli.warnings))
+}
@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// voice is a note that is sounding on an output
+type voice struct {
+	// id is the step that started the note, which lets go of it
+	id    uint64
+	chain string
+	// manual notes are played with SendNote rather than by a chain
+	manual bool
+	output string
+	note   Note
+	// channel is the midi channel the note plays on
+	channel int
+	out     Function
+	tuning  *Tuning
+}
+
+// voices keeps track of every note that a sequencer has sounding on each
+// output, so that stopping or reloading lets go of exactly those notes
+type voices struct {
+	mu     sync.Mutex
+	nextID uint64
+	held   []voice
+}
+
+// outputKey is the name of an output that notes are tracked by,
+// like "midi(synth,ch=1)"
+func outputKey(out Function) string {
+	args := []string{}
+	for _, arg := range out.Args {
+		if arg.Name != "" {
+			args = append(args, arg.Name+"="+arg.Value)
+		} else {
+			args = append(args, arg.Value)
+		}
+	}
+	return out.Name + "(" + strings.Join(args, ",") + ")"
+}
+
+// start holds the notes of a step of a chain on every output and returns the
+// id that lets go of them. Notes that were already held on the same output
+// are returned so they can be released before they are played again.
+func (vs *voices) start(chain string, manual bool, step Step, outFns []Function, tuning *Tuning) (id uint64, retriggered []voice) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.nextID++
+	id = vs.nextID
+	for _, out := range outFns {
+		output := outputKey(out)
+		for k, note := range playable(step.Notes) {
+			for i := 0; i < len(vs.held); i++ {
+				if vs.held[i].output == output && vs.held[i].note.Midi == note.Midi {
+					retriggered = append(retriggered, vs.held[i])
+					vs.held = append(vs.held[:i], vs.held[i+1:]...)
+					i--
+				}
+			}
+			vs.held = append(vs.held, voice{id: id, chain: chain, manual: manual, output: output, note: note, channel: noteChannel(out, tuning, k), out: out, tuning: tuning})
+		}
+	}
+	return
+}
+
+// release lets go of the notes that match and returns them
+func (vs *voices) release(match func(v voice) bool) (released []voice) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	held := vs.held[:0]
+	for _, v := range vs.held {
+		if match(v) {
+			released = append(released, v)
+		} else {
+			held = append(held, v)
+		}
+	}
+	vs.held = held
+	return
+}
+
+// stop lets go of the notes started with an id that are still held
+func (vs *voices) stop(id uint64) []voice {
+	return vs.release(func(v voice) bool { return v.id == id })
+}
+
+// all lets go of every note
+func (vs *voices) all() []voice {
+	return vs.release(func(v voice) bool { return true })
+}
+
+// count returns how many notes are held
+func (vs *voices) count() int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	return len(vs.held)
+}
+
+// releaseVoices sends the note offs of notes that were let go of
+func (tli *TLI) releaseVoices(released []voice) {
+	for _, v := range released {
+		tli.devices.playNote(Step{Notes: []Note{v.note}}, false, []Function{v.out}, v.tuning, v.channel)
+	}
+	if len(released) > 0 {
+		tli.devices.Flush()
+	}
+}
+
+// releaseRemoved lets go of the notes of chains that are gone or
+// no longer play on the output the note is on
+func (tli *TLI) releaseRemoved() {
+	outputs := make(map[string]bool)
+	for _, chain := range tli.ChainsRendered {
+		for _, out := range chain.OutFns {
+			outputs[chain.Name+" "+outputKey(out)] = true
+		}
+	}
+	tli.releaseVoices(tli.voices.release(func(v voice) bool {
+		return !v.manual && !outputs[v.chain+" "+v.output]
+	}))
+}
+
+// gate is a step that is playing, its notes are let go of when its gate
+// ends and its slides are sent until then
+type gate struct {
+	id      uint64
+	step    Step
+	outFns  []Function
+	tuning  *Tuning
+	devices *DeviceManager
+	start   time.Duration
+	end     time.Duration
+	slid    time.Duration
+}
+
+// newGate returns the gate of a step that started playing at a time
+func newGate(id uint64, step Step, outFns []Function, tuning *Tuning, devices *DeviceManager, start time.Duration) gate {
+	length := int64(math.Round(float64(step.TimeDurationMicroseconds) * float64(step.Params.Gate) / 100.0))
+	return gate{
+		id:      id,
+		step:    step,
+		outFns:  outFns,
+		tuning:  tuning,
+		devices: devices,
+		start:   start,
+		end:     start + time.Duration(length)*time.Microsecond,
+		slid:    start,
+	}
+}
+
+// closeGates lets go of the notes of the gates that have ended by a time
+// and sends the slides of the others, it returns the gates that are open
+func (tli *TLI) closeGates(gates []gate, now time.Duration) []gate {
+	open := gates[:0]
+	for _, g := range gates {
+		if now >= g.end {
+			tli.releaseVoices(tli.voices.stop(g.id))
+			continue
+		}
+		if g.step.slides() && now-g.slid >= slideInterval*time.Microsecond {
+			fraction := float64((now - g.start).Microseconds()) / float64(g.step.TimeDurationMicroseconds)
+			g.devices.Slide(g.step, g.outFns, g.tuning, fraction)
+			g.slid = now
+		}
+		open = append(open, g)
+	}
+	return open
+}
@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVoices(t *testing.T) {
+	vs := voices{}
+	out := Function{Name: "midi", Args: []Arg{{Value: "synth"}, {Name: "ch", Value: "1"}}}
+	assert.Equal(t, "midi(synth,ch=1)", outputKey(out))
+	chord := Step{Notes: []Note{{Midi: 60}, {Midi: 64}, {IsRest: true}}}
+
+	first, retriggered := vs.start("a", false, chord, []Function{out}, nil)
+	assert.Empty(t, retriggered)
+	assert.Equal(t, 2, vs.count())
+
+	// playing a note that is held lets go of it first
+	second, retriggered := vs.start("a", false, Step{Notes: []Note{{Midi: 64}}}, []Function{out}, nil)
+	assert.Equal(t, 1, len(retriggered))
+	assert.Equal(t, 64, retriggered[0].note.Midi)
+	assert.Equal(t, 2, vs.count())
+
+	// the first step only lets go of the note it still holds
+	released := vs.stop(first)
+	assert.Equal(t, 1, len(released))
+	assert.Equal(t, 60, released[0].note.Midi)
+	assert.Empty(t, vs.stop(first))
+
+	// another chain playing the held note takes it over
+	_, retriggered = vs.start("b", false, chord, []Function{out}, nil)
+	assert.Equal(t, 1, len(retriggered))
+	assert.Empty(t, vs.stop(second))
+	assert.Equal(t, 2, len(vs.all()))
+	assert.Equal(t, 0, vs.count())
+}
+
+func TestStuckNotes(t *testing.T) {
+	log.SetLevel("info")
+	opened := make(map[string]*fakeMidi)
+	dm := NewDeviceManager()
+	dm.openMidi = func(name string) (midiOut, error) {
+		opened[name] = &fakeMidi{}
+		return opened[name], nil
+	}
+	tli, err := newWithDevices(`
+run a
+c4 e4
+
+run b
+g4
+
+tie a
+out midi(synth)
+
+tie b
+out midi(synth)
+`, nil, dm)
+	assert.Nil(t, err)
+	play := func(i int) {
+		chain := tli.ChainsRendered[i]
+		tli.voices.start(chain.Name, false, chain.Steps[0], chain.OutFns, nil)
+		dm.PlayNote(chain.Steps[0], true, chain.OutFns, nil)
+	}
+	device := opened["synth"]
+
+	// stopping lets go of every note
+	play(0)
+	play(1)
+	tli.Stop()
+	assert.Equal(t, []uint8{60, 67}, device.offs)
+	assert.Equal(t, 0, tli.voices.count())
+
+	// reloading lets go of the notes of chains that are gone
+	device.offs = nil
+	play(0)
+	play(1)
+	assert.Nil(t, tli.Update(`
+run a
+c4 e4
+
+tie a
+out midi(synth)
+`))
+	assert.Equal(t, []uint8{67}, device.offs)
+	assert.Equal(t, 1, tli.voices.count())
+
+	// halting a chain lets go of its notes
+	assert.Nil(t, tli.Halt("a"))
+	assert.Equal(t, []uint8{67, 60}, device.offs)
+
+	// notes sent again are let go of before they play
+	device.offs = nil
+	assert.Nil(t, tli.SendNote("midi(synth)", 62, true))
+	assert.Nil(t, tli.SendNote("midi(synth)", 62, true))
+	assert.Equal(t, []uint8{62}, device.offs)
+	tli.Close()
+	assert.Equal(t, []uint8{62, 62}, device.offs)
+	assert.True(t, device.closed)
+}
@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// isVoicing returns whether a decorator voices a chord, like "drop2" or "oct+1"
+func isVoicing(decorator string) bool {
+	switch decorator {
+	case "drop2", "drop3", "open", "spread", "omit5":
+		return true
+	}
+	if strings.HasPrefix(decorator, "oct+") || strings.HasPrefix(decorator, "oct-") {
+		_, err := strconv.Atoi(decorator[3:])
+		return err == nil
+	}
+	return false
+}
+
+func hasVoicing(args []Arg) bool {
+	for _, arg := range args {
+		if isVoicing(arg.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// chordRoot returns the pitch class of the root of a chord, which is not
+// the lowest note of an inversion like "c/e"
+func chordRoot(name string, notes []Note) int {
+	name = strings.Split(strings.Split(name, ";")[0], "/")[0]
+	if root, err := ParseChord(name, 0); err == nil && len(root) > 0 {
+		return root[0].Midi % 12
+	}
+	return notes[0].Midi % 12
+}
+
+// VoiceChord voices the notes of a chord with decorators, which are applied
+// in the order they are written:
+//
+//	drop2  drops the second highest note an octave
+//	drop3  drops the third highest note an octave
+//	open   raises every other note above the lowest an octave
+//	spread drops the lowest note and raises the highest note an octave
+//	omit5  leaves out the fifth above the root, a pitch class
+//	oct+1  moves every note up an octave, or down with oct-1
+func VoiceChord(notes []Note, root int, args []Arg) (voiced []Note) {
+	midis := noteMidis(notes)
+	for _, arg := range args {
+		decorator := arg.Value
+		if !isVoicing(decorator) {
+			continue
+		}
+		switch {
+		case decorator == "drop2" && len(midis) >= 2:
+			midis[len(midis)-2] -= 12
+		case decorator == "drop3" && len(midis) >= 3:
+			midis[len(midis)-3] -= 12
+		case decorator == "open":
+			for i := 1; i < len(midis); i += 2 {
+				midis[i] += 12
+			}
+		case decorator == "spread" && len(midis) >= 2:
+			midis[0] -= 12
+			midis[len(midis)-1] += 12
+		case decorator == "omit5":
+			kept := []int{}
+			for _, midi := range midis {
+				if ((midi-root)%12+12)%12 != 7 {
+					kept = append(kept, midi)
+				}
+			}
+			if len(kept) > 0 {
+				midis = kept
+			}
+		case strings.HasPrefix(decorator, "oct"):
+			octaves, _ := strconv.Atoi(decorator[3:])
+			for i := range midis {
+				midis[i] += 12 * octaves
+			}
+		}
+		sort.Ints(midis)
+	}
+	return midiNotes(midis)
+}
+
+// noteMidis returns the midi notes of the notes that play, lowest first
+func noteMidis(notes []Note) (midis []int) {
+	for _, note := range playable(notes) {
+		midis = append(midis, note.Midi)
+	}
+	sort.Ints(midis)
+	return
+}
+
+// midiNotes returns the notes of midi notes, leaving out the ones that
+// are not midi notes
+func midiNotes(midis []int) (notes []Note) {
+	for _, midi := range midis {
+		if midi >= 0 && midi <= 127 {
+			notes = append(notes, Note{Midi: midi, Name: MidiName(midi)})
+		}
+	}
+	return
+}
+
+// voiceLead plays each chord of the steps in the inversion and octave that
+// moves the least from the chord before it. Chords with a voicing or a bass
+// note are played as they are written.
+func voiceLead(steps []Step) {
+	var last []int
+	for i, step := range steps {
+		midis := noteMidis(step.Notes)
+		if len(midis) < 2 {
+			continue
+		}
+		fn, _ := ParseFunction(step.Token)
+		if last != nil && !hasVoicing(step.Arguments) && !strings.Contains(fn.Name, "/") {
+			midis = closestVoicing(midis, last)
+			steps[i].Notes = midiNotes(midis)
+		}
+		last = midis
+	}
+}
+
+// closestVoicing returns the inversion of a chord, in the octave, that is
+// closest to another chord. The chord as it is written wins a tie.
+func closestVoicing(midis []int, last []int) (best []int) {
+	bestDistance := math.MaxInt
+	for inversion := 0; inversion < len(midis); inversion++ {
+		inverted := append([]int{}, midis...)
+		for i := 0; i < inversion; i++ {
+			inverted[i] += 12
+		}
+		sort.Ints(inverted)
+		for _, shift := range []int{0, -12, 12, -24, 24} {
+			candidate := make([]int, len(inverted))
+			for i, midi := range inverted {
+				candidate[i] = midi + shift
+			}
+			if candidate[0] < 0 || candidate[len(candidate)-1] > 127 {
+				continue
+			}
+			if distance := voiceDistance(candidate, last); distance < bestDistance {
+				best, bestDistance = candidate, distance
+			}
+		}
+	}
+	return
+}
+
+// voiceDistance is how far the notes of two chords are from the closest
+// notes of the other chord
+func voiceDistance(a []int, b []int) (distance int) {
+	closest := func(midi int, others []int) int {
+		d := math.MaxInt
+		for _, other := range others {
+			if abs := int(math.Abs(float64(midi - other))); abs < d {
+				d = abs
+			}
+		}
+		return d
+	}
+	for _, midi := range a {
+		distance += closest(midi, b)
+	}
+	for _, midi := range b {
+		distance += closest(midi, a)
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"testing"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVoiceChord(t *testing.T) {
+	log.SetLevel("info")
+	for _, test := range []struct {
+		chord string
+		want  []int
+	}{
+		{"Cmaj7", []int{60, 64, 67, 71}},
+		{"Cmaj7(drop2)", []int{55, 60, 64, 71}},
+		{"Cmaj7(drop3)", []int{52, 60, 67, 71}},
+		{"C(open)", []int{60, 67, 76}},
+		{"C(spread)", []int{48, 64, 79}},
+		{"C7(omit5)", []int{60, 64, 70}},
+		{"C(oct+1)", []int{72, 76, 79}},
+		{"C(oct-2)", []int{36, 40, 43}},
+		// the fifth of an inversion is found from the root
+		{"C/E(omit5)", []int{64, 72}},
+		// voicings are applied in order
+		{"Cmaj7(drop2,oct-1)", []int{43, 48, 52, 59}},
+	} {
+		fn, err := ParseFunction(test.chord)
+		assert.Nil(t, err)
+		notes, err := ParseChord(fn.Name, 0)
+		assert.Nil(t, err)
+		if hasVoicing(fn.Args) {
+			notes = VoiceChord(notes, chordRoot(fn.Name, notes), fn.Args)
+		}
+		assert.Equal(t, test.want, noteMidis(notes), test.chord)
+	}
+}
+
+func TestVoiceLead(t *testing.T) {
+	log.SetLevel("info")
+	chords := func(voicelead string) (midis [][]int) {
+		tli, err := NewOffline(`
+run a
+C;4 F;4 G;4 Am;4 C;4(drop2) F/C
+
+tie a
+` + voicelead)
+		assert.Nil(t, err)
+		for _, step := range tli.ChainsRendered[0].Steps {
+			midis = append(midis, noteMidis(step.Notes))
+		}
+		return
+	}
+	assert.Equal(t, [][]int{
+		{60, 64, 67},
+		{65, 69, 72},
+		{67, 71, 74},
+		{69, 72, 76},
+		{52, 60, 67},
+		{60, 65, 69},
+	}, chords(""))
+	assert.Equal(t, [][]int{
+		{60, 64, 67},
+		// each chord moves to the inversion closest to the last one
+		{60, 65, 69},
+		{59, 62, 67},
+		{60, 64, 69},
+		// a chord with a voicing or a bass note is played as written
+		{52, 60, 67},
+		{60, 65, 69},
+	}, chords("voicelead"))
+}
@@ -0,0 +1,175 @@
+// Package synth renders the notes of a song to audio offline with a few
+// simple voices, so songs can be heard without any hardware attached.
+// Rendering is deterministic so the same song always gives the same audio.
+package synth
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/schollz/aw/internal/parser"
+)
+
+// SampleRate is the number of samples per second of rendered audio
+const SampleRate = 44100
+
+// the level of all the notes together, to leave room for chords
+const masterGain = 0.25
+
+// the envelope of notes without an adsr decorator, short enough
+// to keep notes from clicking
+var defaultAdsr = []float64{0.005, 0, 1, 0.02}
+
+// voices that have their own envelope when there is no adsr decorator,
+// so noise sounds like a drum
+var voiceAdsr = map[string][]float64{
+	"noise": {0.001, 0.12, 0, 0.01},
+}
+
+// Voice returns a sample of a waveform at a phase between 0 and 1
+type Voice func(phase float64, noise *Noise) float64
+
+// Voices are the voices that can be used with "out synth(voice)"
+var Voices = map[string]Voice{
+	"sine": func(phase float64, n *Noise) float64 {
+		return math.Sin(2 * math.Pi * phase)
+	},
+	"saw": func(phase float64, n *Noise) float64 {
+		return 2*phase - 1
+	},
+	"square": func(phase float64, n *Noise) float64 {
+		if phase < 0.5 {
+			return 1
+		}
+		return -1
+	},
+	"noise": func(phase float64, n *Noise) float64 {
+		return n.next()
+	},
+}
+
+// DefaultVoice is used for chains without a synth output
+const DefaultVoice = "sine"
+
+// Noise is a xorshift generator, seeded the same for every note
+// so that noise is the same on every render
+type Noise uint32
+
+func (n *Noise) next() float64 {
+	*n ^= *n << 13
+	*n ^= *n >> 17
+	*n ^= *n << 5
+	return float64(*n)/float64(math.MaxUint32)*2 - 1
+}
+
+// Render synthesizes the notes into samples between -1 and 1, the audio
+// lasts for the total microseconds plus the release of the last notes
+func Render(events []parser.NoteEvent, total int64) (samples []float64) {
+	length := int(total * SampleRate / 1000000)
+	for _, e := range events {
+		adsr := envelope(e)
+		end := int((e.Start+e.Duration)*SampleRate/1000000) + int(adsr[3]*SampleRate)
+		if end > length {
+			length = end
+		}
+	}
+	samples = make([]float64, length)
+	for _, e := range events {
+		renderNote(samples, e)
+	}
+	for i, v := range samples {
+		samples[i] = math.Max(-1, math.Min(1, v*masterGain))
+	}
+	return
+}
+
+func envelope(e parser.NoteEvent) []float64 {
+	if len(e.Adsr) == 4 {
+		return e.Adsr
+	}
+	if adsr, ok := voiceAdsr[e.Voice]; ok {
+		return adsr
+	}
+	return defaultAdsr
+}
+
+// renderNote adds one note to the samples
+func renderNote(samples []float64, e parser.NoteEvent) {
+	voice, ok := Voices[e.Voice]
+	if !ok {
+		voice = Voices[DefaultVoice]
+	}
+	adsr := envelope(e)
+	attack, decay, sustain, release := adsr[0], adsr[1], adsr[2], adsr[3]
+	start := int(e.Start * SampleRate / 1000000)
+	gate := float64(e.Duration) / 1000000
+	amp := float64(e.Velocity) / 127
+	n := Noise(2463534242)
+	// the level when the note is released, so the release starts
+	// from wherever the envelope was
+	levelAt := func(t float64) float64 {
+		switch {
+		case t < attack:
+			return t / attack
+		case t < attack+decay:
+			return 1 - (1-sustain)*(t-attack)/decay
+		}
+		return sustain
+	}
+	released := levelAt(gate)
+	glideDuration := float64(e.GlideDuration) / 1000000
+	glidePhase := 0.0
+	for i := 0; start+i < len(samples); i++ {
+		t := float64(i) / SampleRate
+		level := 0.0
+		if t < gate {
+			level = levelAt(t)
+		} else if t < gate+release {
+			level = released * (1 - (t-gate)/release)
+		} else {
+			break
+		}
+		phase := math.Mod(e.Frequency*t, 1)
+		if e.Glide != 0 {
+			// the frequency changes while gliding so the phase adds up
+			frequency := e.Frequency
+			if t < glideDuration {
+				frequency *= math.Pow(2, e.Glide*(1-t/glideDuration)/12)
+			}
+			glidePhase += frequency / SampleRate
+			phase = math.Mod(glidePhase, 1)
+		}
+		samples[start+i] += amp * level * voice(phase, &n)
+	}
+}
+
+// WriteWav writes the samples as a mono 16-bit wav file
+func WriteWav(w io.Writer, samples []float64) (err error) {
+	dataSize := uint32(len(samples) * 2)
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(36 + dataSize),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16),             // size of the format chunk
+		uint16(1),              // pcm
+		uint16(1),              // channels
+		uint32(SampleRate),     // sample rate
+		uint32(SampleRate * 2), // bytes per second
+		uint16(2),              // bytes per sample
+		uint16(16),             // bits per sample
+		[4]byte{'d', 'a', 't', 'a'},
+		dataSize,
+	}
+	for _, v := range header {
+		if err = binary.Write(w, binary.LittleEndian, v); err != nil {
+			return
+		}
+	}
+	data := make([]int16, len(samples))
+	for i, v := range samples {
+		data[i] = int16(math.Round(v * math.MaxInt16))
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
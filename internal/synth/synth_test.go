@@ -0,0 +1,93 @@
+package synth
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/schollz/aw/internal/parser"
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+const goldenText = `
+run lead
+c4 e g(adsr(0.1,0.2,0.5,0.3)) ~
+
+run bass
+c2 _ g1 _
+
+run drums
+c4 c4 c4 c4
+
+tie lead
+out synth(saw)
+
+tie bass
+out synth(square)
+
+tie drums
+out synth(noise)
+
+song
+a 1: lead drums
+b 1: lead bass drums
+`
+
+func TestRenderGolden(t *testing.T) {
+	log.SetLevel("info")
+	tli, err := parser.NewOffline(goldenText)
+	assert.Nil(t, err)
+	samples := Render(tli.Arrange())
+	// two bars at 120 bpm, every note is released before the end
+	assert.Equal(t, 4*SampleRate, len(samples))
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteWav(&buf, samples))
+	assert.Equal(t, 44+2*len(samples), buf.Len())
+	assert.Equal(t, "RIFF", buf.String()[:4])
+
+	// the samples are compared within a tolerance, as compilers can fuse
+	// the float arithmetic of the voices differently on other platforms
+	for _, sample := range []struct {
+		index int
+		value float64
+	}{
+		{1000, 0.306242324},
+		{5000, 0.064180545},
+		{66150, 0.097933071},
+		{100000, -0.470439766},
+		{150000, -0.276064599},
+		{170000, -0.236220472},
+	} {
+		assert.InDelta(t, sample.value, samples[sample.index], 1e-6, sample.index)
+	}
+	// the loudness of every half second, the bass comes in with the second bar
+	rms := []float64{}
+	n := SampleRate / 2
+	for start := 0; start+n <= len(samples); start += n {
+		sum := 0.0
+		for _, v := range samples[start : start+n] {
+			assert.True(t, v >= -1 && v <= 1)
+			sum += v * v
+		}
+		rms = append(rms, math.Sqrt(sum/float64(n)))
+	}
+	assert.InDeltaSlice(t, []float64{0.139056, 0.138833, 0.086663, 0.042398, 0.273808, 0.267041, 0.250748, 0.229910}, rms, 1e-4)
+}
+
+func TestRenderVoices(t *testing.T) {
+	for name := range Voices {
+		samples := Render([]parser.NoteEvent{{Midi: 69, Frequency: 440, Velocity: 127, Duration: 100000, Voice: name}}, 100000)
+		peak := 0.0
+		for _, v := range samples {
+			assert.True(t, v >= -1 && v <= 1)
+			if v > peak {
+				peak = v
+			}
+		}
+		assert.True(t, peak > 0.1, name)
+		// the same notes always render the same
+		assert.Equal(t, samples, Render([]parser.NoteEvent{{Midi: 69, Frequency: 440, Velocity: 127, Duration: 100000, Voice: name}}, 100000), name)
+	}
+}